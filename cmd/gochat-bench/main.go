@@ -0,0 +1,94 @@
+/*
+GoChat-bench is a load generator for measuring GoChat server capacity.
+
+It opens a number of concurrent WebSocket connections at a controlled ramp
+rate, has each client send messages at a fixed rate, and reports end-to-end
+latency percentiles, dropped connections, rate-limit rejections and
+throughput.
+
+Usage:
+
+	gochat-bench [flags]
+
+Flags:
+
+	-url string        WebSocket endpoint (default "ws://localhost:8080/ws")
+	-origin string     Origin header sent with each handshake (default "http://localhost:8080")
+	-clients int       Number of concurrent connections (default 50)
+	-ramp float        New connections per second, 0 opens all at once (default 25)
+	-rate float        Messages per second per client (default 1)
+	-duration duration Sending window after the ramp completes (default 30s)
+	-size int          Message content size in bytes (default 64)
+	-drain duration    Time to wait for in-flight messages after sending stops (default 2s)
+	-json              Write the report as JSON instead of text
+	-out string        Write the report to a file instead of stdout
+
+Note that the server's per-connection rate limit (RATE_LIMIT_BURST and
+RATE_LIMIT_REFILL_INTERVAL) applies to benchmark clients too; messages sent
+faster than it allows are reported as rate limited.
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Tyrowin/gochat/internal/bench"
+)
+
+func main() {
+	cfg := bench.DefaultConfig()
+	jsonOutput := flag.Bool("json", false, "write the report as JSON instead of text")
+	outPath := flag.String("out", "", "write the report to a file instead of stdout")
+
+	flag.StringVar(&cfg.URL, "url", cfg.URL, "WebSocket endpoint")
+	flag.StringVar(&cfg.Origin, "origin", cfg.Origin, "Origin header sent with each handshake")
+	flag.IntVar(&cfg.Clients, "clients", cfg.Clients, "number of concurrent connections")
+	flag.Float64Var(&cfg.RampRate, "ramp", cfg.RampRate, "new connections per second (0 opens all at once)")
+	flag.Float64Var(&cfg.MessageRate, "rate", cfg.MessageRate, "messages per second per client")
+	flag.DurationVar(&cfg.Duration, "duration", cfg.Duration, "sending window after the ramp completes")
+	flag.IntVar(&cfg.PayloadSize, "size", cfg.PayloadSize, "message content size in bytes")
+	flag.DurationVar(&cfg.DrainTimeout, "drain", cfg.DrainTimeout, "time to wait for in-flight messages after sending stops")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Benchmarking %s with %d clients", cfg.URL, cfg.Clients)
+	report, err := bench.Run(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Benchmark failed: %v", err)
+	}
+
+	if err := writeReport(report, *outPath, *jsonOutput); err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+}
+
+// writeReport writes the report to stdout or to the given file.
+func writeReport(report *bench.Report, path string, asJSON bool) error {
+	var out io.Writer = os.Stdout
+	if path != "" {
+		file, err := os.Create(path) // #nosec G304 -- path is an operator-supplied flag
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := file.Close(); cerr != nil {
+				fmt.Fprintf(os.Stderr, "Error closing report file: %v\n", cerr)
+			}
+		}()
+		out = file
+	}
+
+	if asJSON {
+		return report.WriteJSON(out)
+	}
+	return report.WriteText(out)
+}
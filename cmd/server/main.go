@@ -7,10 +7,18 @@ and includes a built-in test page for development and testing.
 Usage:
 
 	gochat
+	gochat export [-url url] [-token token] [-room room] [-since time] [-until time] [-format jsonl|text] [-out file]
+	gochat import [-url url] [-token token] [-in file]
+
+The export and import subcommands move chat data through the admin API of a
+running server, which must have ADMIN_TOKEN set. Exports are JSON Lines, or a
+plain-text transcript with -format text; imported messages keep their ids.
 
 The server will start on port 8080 by default and provide the following endpoints:
 
   - / - Health check endpoint
+  - /healthz - Liveness probe with JSON status
+  - /readyz - Readiness probe, failing before startup and while draining
   - /ws - WebSocket endpoint for chat connections
   - /test - HTML test page for WebSocket functionality
 */
@@ -29,9 +37,32 @@ import (
 	"github.com/Tyrowin/gochat/internal/server"
 )
 
+// Version and Commit are set at link time by the Makefile and reported by
+// the health endpoints.
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "export":
+			if err := runExport(os.Args[2:]); err != nil {
+				log.Fatalf("Export failed: %v", err)
+			}
+			return
+		case "import":
+			if err := runImport(os.Args[2:]); err != nil {
+				log.Fatalf("Import failed: %v", err)
+			}
+			return
+		}
+	}
+
 	fmt.Println("Starting GoChat server...")
 
+	server.SetVersion(Version, Commit)
 	config := server.NewConfigFromEnv()
 	server.SetConfig(config)
 	server.StartHub()
@@ -60,7 +91,7 @@ func main() {
 		log.Printf("Received shutdown signal: %v", sig)
 
 		// Initiate graceful shutdown
-		if err := gracefulShutdown(httpServer); err != nil {
+		if err := gracefulShutdown(httpServer, config.DrainTimeout); err != nil {
 			log.Fatalf("Graceful shutdown failed: %v", err)
 		}
 
@@ -69,9 +100,11 @@ func main() {
 }
 
 // gracefulShutdown performs orderly shutdown of the server components
-func gracefulShutdown(httpServer *http.Server) error {
-	// Define shutdown timeout
-	const shutdownTimeout = 30 * time.Second
+func gracefulShutdown(httpServer *http.Server, drainTimeout time.Duration) error {
+	hub := server.GetHub()
+
+	// Define shutdown timeout; draining gets its own configured budget
+	shutdownTimeout := drainTimeout + 30*time.Second
 
 	// Create a context with timeout for the entire shutdown process
 	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
@@ -81,16 +114,20 @@ func gracefulShutdown(httpServer *http.Server) error {
 	shutdownComplete := make(chan error, 1)
 
 	go func() {
-		// Step 1: Stop accepting new HTTP connections
-		log.Println("Step 1: Stopping HTTP server...")
+		// Step 1: Drain clients (readiness fails, new connections are refused,
+		// clients are told to reconnect and then closed with 1001)
+		log.Println("Step 1: Draining clients...")
+		hub.Drain()
+
+		// Step 2: Stop accepting new HTTP connections
+		log.Println("Step 2: Stopping HTTP server...")
 		if err := server.ShutdownServer(httpServer, 15*time.Second); err != nil {
 			shutdownComplete <- fmt.Errorf("HTTP server shutdown error: %w", err)
 			return
 		}
 
-		// Step 2: Shutdown the hub (closes all WebSocket connections)
-		log.Println("Step 2: Shutting down WebSocket hub...")
-		hub := server.GetHub()
+		// Step 3: Shutdown the hub (closes any remaining connections)
+		log.Println("Step 3: Shutting down WebSocket hub...")
 		if err := hub.Shutdown(15 * time.Second); err != nil {
 			shutdownComplete <- fmt.Errorf("hub shutdown error: %w", err)
 			return
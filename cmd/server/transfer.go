@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// transferFlags are the flags shared by the export and import subcommands.
+type transferFlags struct {
+	url   string
+	token string
+}
+
+func (f *transferFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.url, "url", "http://localhost:8080", "base URL of the running server")
+	fs.StringVar(&f.token, "token", os.Getenv("ADMIN_TOKEN"), "admin token (default $ADMIN_TOKEN)")
+}
+
+// runExport implements "gochat export": it downloads an export from the
+// admin API of a running server and writes it to stdout or a file.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	var common transferFlags
+	common.register(fs)
+	room := fs.String("room", "", "only export this room")
+	since := fs.String("since", "", "only export messages sent at or after this time (RFC 3339 or YYYY-MM-DD)")
+	until := fs.String("until", "", "only export messages sent before this time (RFC 3339 or YYYY-MM-DD)")
+	format := fs.String("format", "jsonl", "output format: jsonl or text")
+	outPath := fs.String("out", "", "write the export to a file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	for name, value := range map[string]string{"room": *room, "since": *since, "until": *until, "format": *format} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	resp, err := adminCall(http.MethodGet, common, "/admin/export?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var out io.Writer = os.Stdout
+	if *outPath != "" {
+		file, err := os.Create(*outPath) // #nosec G304 -- path is an operator-supplied flag
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := file.Close(); cerr != nil {
+				fmt.Fprintf(os.Stderr, "Error closing export file: %v\n", cerr)
+			}
+		}()
+		out = file
+	}
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
+
+// runImport implements "gochat import": it uploads a JSON Lines export to
+// the admin API of a running server and prints what was imported.
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	var common transferFlags
+	common.register(fs)
+	inPath := fs.String("in", "", "read the export from a file instead of stdin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var in io.Reader = os.Stdin
+	if *inPath != "" {
+		file, err := os.Open(*inPath) // #nosec G304 -- path is an operator-supplied flag
+		if err != nil {
+			return err
+		}
+		defer func() { _ = file.Close() }()
+		in = file
+	}
+
+	resp, err := adminCall(http.MethodPost, common, "/admin/import", in)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var result struct {
+		Rooms    int `json:"rooms"`
+		Members  int `json:"members"`
+		Messages int `json:"messages"`
+		Evicted  int `json:"evicted"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("reading import result: %w", err)
+	}
+	fmt.Printf("Imported %d rooms, %d members and %d messages\n", result.Rooms, result.Members, result.Messages)
+	if result.Evicted > 0 {
+		fmt.Printf("%d of the oldest messages did not fit in the history; raise HISTORY_SIZE to keep them\n", result.Evicted)
+	}
+	return nil
+}
+
+// adminCall sends an admin API request and returns the response if it
+// succeeded; otherwise the error carries the server's message.
+func adminCall(method string, f transferFlags, path string, body io.Reader) (*http.Response, error) {
+	if f.token == "" {
+		return nil, fmt.Errorf("no admin token; set ADMIN_TOKEN or pass -token")
+	}
+	req, err := http.NewRequest(method, strings.TrimRight(f.url, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+f.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("server returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
@@ -0,0 +1,447 @@
+// Package bench drives synthetic load against a GoChat server. It opens a
+// configurable number of WebSocket connections at a controlled ramp rate,
+// sends messages at a fixed rate per connection, and measures end-to-end
+// delivery latency as seen by the other connected clients.
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Config describes a single benchmark run.
+type Config struct {
+	// URL is the WebSocket endpoint, for example ws://localhost:8080/ws.
+	URL string
+	// Origin is sent with every handshake and must be allowed by the server.
+	Origin string
+	// Clients is the number of concurrent connections to open.
+	Clients int
+	// RampRate is the number of new connections opened per second.
+	// Zero or a negative value opens every connection at once.
+	RampRate float64
+	// MessageRate is the number of messages each client sends per second.
+	MessageRate float64
+	// Duration is how long clients keep sending once the ramp has finished.
+	Duration time.Duration
+	// PayloadSize is the length of each message's content in bytes.
+	PayloadSize int
+	// DrainTimeout is how long to keep reading after sending stops so that
+	// in-flight messages are still counted.
+	DrainTimeout time.Duration
+}
+
+// DefaultConfig returns a small run against a local server.
+func DefaultConfig() Config {
+	return Config{
+		URL:          "ws://localhost:8080/ws",
+		Origin:       "http://localhost:8080",
+		Clients:      50,
+		RampRate:     25,
+		MessageRate:  1,
+		Duration:     30 * time.Second,
+		PayloadSize:  64,
+		DrainTimeout: 2 * time.Second,
+	}
+}
+
+// minPayloadSize leaves room for the worker id, sequence and timestamp that
+// prefix every benchmark message.
+const minPayloadSize = 48
+
+func (cfg Config) validate() error {
+	if cfg.URL == "" {
+		return errors.New("bench: URL is required")
+	}
+	if cfg.Clients <= 0 {
+		return errors.New("bench: clients must be positive")
+	}
+	if cfg.MessageRate <= 0 {
+		return errors.New("bench: message rate must be positive")
+	}
+	if cfg.Duration <= 0 {
+		return errors.New("bench: duration must be positive")
+	}
+	if cfg.PayloadSize < minPayloadSize {
+		return fmt.Errorf("bench: payload size must be at least %d bytes", minPayloadSize)
+	}
+	return nil
+}
+
+// counters aggregates run-wide statistics that workers update concurrently.
+type counters struct {
+	attempted   atomic.Int64
+	established atomic.Int64
+	failed      atomic.Int64
+	dropped     atomic.Int64
+	sent        atomic.Int64
+	sendErrors  atomic.Int64
+	received    atomic.Int64
+	rateLimited atomic.Int64
+}
+
+// run holds the shared state of one benchmark execution.
+type run struct {
+	cfg      Config
+	stats    counters
+	stopping atomic.Bool
+
+	// workers is indexed by worker id so receivers can find a sender
+	// without locking.
+	workers []atomic.Pointer[worker]
+
+	// sendCtx bounds the sending window. It is set before sending is
+	// released by closing ramped.
+	sendCtx context.Context
+	ramped  chan struct{}
+}
+
+// Run executes the benchmark described by cfg and returns its report. The
+// run stops early, but still reports, if ctx is cancelled.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	r := &run{
+		cfg:     cfg,
+		workers: make([]atomic.Pointer[worker], cfg.Clients),
+		ramped:  make(chan struct{}),
+	}
+	started := time.Now()
+
+	var wg sync.WaitGroup
+	r.ramp(ctx, &wg)
+
+	sendCtx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+	r.sendCtx = sendCtx
+	sendingStarted := time.Now()
+	close(r.ramped)
+
+	<-sendCtx.Done()
+	sendingStopped := time.Now()
+
+	r.drain(ctx)
+	r.stopping.Store(true)
+	r.closeAll()
+	wg.Wait()
+
+	return r.report(started, sendingStopped.Sub(sendingStarted)), nil
+}
+
+// ramp opens connections at the configured rate and returns once every
+// handshake has completed or ctx is done.
+func (r *run) ramp(ctx context.Context, wg *sync.WaitGroup) {
+	var interval time.Duration
+	if r.cfg.RampRate > 0 {
+		interval = time.Duration(float64(time.Second) / r.cfg.RampRate)
+	}
+
+	var dials sync.WaitGroup
+	defer dials.Wait()
+
+	for id := 0; id < r.cfg.Clients; id++ {
+		if id > 0 && interval > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		wg.Add(1)
+		dials.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			r.startWorker(ctx, id, dials.Done)
+		}(id)
+	}
+}
+
+// drain waits for in-flight messages after the sending phase has ended.
+func (r *run) drain(ctx context.Context) {
+	if r.cfg.DrainTimeout <= 0 {
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(r.cfg.DrainTimeout):
+	}
+}
+
+// closeAll sends a close frame on every live connection.
+func (r *run) closeAll() {
+	var wg sync.WaitGroup
+	for i := range r.workers {
+		if w := r.workers[i].Load(); w != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				w.close()
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+// worker is one benchmark connection with its own sender and reader.
+type worker struct {
+	id        int
+	conn      *websocket.Conn
+	run       *run
+	writeMu   sync.Mutex
+	sent      atomic.Int64
+	delivered []atomic.Bool
+	latency   *LatencyHistogram
+	// rateLimited counts the throttling episodes the server reported for
+	// this worker. It sends one rate_limited error when it starts dropping
+	// a client's messages, not one per dropped message.
+	rateLimited atomic.Int64
+	done        chan struct{}
+}
+
+// startWorker dials the server and runs the worker until the run finishes.
+// dialed is called once the handshake has succeeded or failed.
+func (r *run) startWorker(ctx context.Context, id int, dialed func()) {
+	r.stats.attempted.Add(1)
+
+	conn, err := r.dial(ctx)
+	dialed()
+	if err != nil {
+		r.stats.failed.Add(1)
+		return
+	}
+	r.stats.established.Add(1)
+
+	w := &worker{
+		id:        id,
+		conn:      conn,
+		run:       r,
+		delivered: make([]atomic.Bool, r.maxMessagesPerClient()),
+		latency:   NewLatencyHistogram(),
+		done:      make(chan struct{}),
+	}
+
+	r.workers[id].Store(w)
+
+	go w.send()
+	w.read()
+}
+
+func (r *run) dial(ctx context.Context) (*websocket.Conn, error) {
+	dialer := websocket.Dialer{HandshakeTimeout: 10 * time.Second}
+	headers := http.Header{}
+	if r.cfg.Origin != "" {
+		headers.Set("Origin", r.cfg.Origin)
+	}
+
+	conn, resp, err := dialer.DialContext(ctx, r.cfg.URL, headers)
+	if resp != nil {
+		_ = resp.Body.Close()
+	}
+	return conn, err
+}
+
+// maxMessagesPerClient bounds how many messages a single client can send,
+// which sizes the per-worker delivery tracking table.
+func (r *run) maxMessagesPerClient() int {
+	return int(math.Ceil(r.cfg.MessageRate*r.cfg.Duration.Seconds())) + 1
+}
+
+// send waits for the ramp to finish and then writes messages at the
+// configured rate until the sending window closes.
+func (w *worker) send() {
+	<-w.run.ramped
+	ctx := w.run.sendCtx
+	interval := time.Duration(float64(time.Second) / w.run.cfg.MessageRate)
+
+	// Spread the first message of each worker across one interval so that
+	// clients opened in the same instant do not send in lockstep.
+	offset := interval * time.Duration(w.id%16) / 16
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(offset):
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if !w.sendOne() {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendOne writes the next sequenced message and returns false if the
+// connection is no longer usable.
+func (w *worker) sendOne() bool {
+	seq := w.sent.Load()
+	if seq >= int64(len(w.delivered)) {
+		return false
+	}
+
+	payload, err := json.Marshal(struct {
+		Content string `json:"content"`
+	}{Content: encodeContent(w.id, seq, time.Now(), w.run.cfg.PayloadSize)})
+	if err != nil {
+		w.run.stats.sendErrors.Add(1)
+		return false
+	}
+
+	w.writeMu.Lock()
+	err = w.conn.WriteMessage(websocket.TextMessage, payload)
+	w.writeMu.Unlock()
+	if err != nil {
+		w.run.stats.sendErrors.Add(1)
+		return false
+	}
+
+	w.sent.Add(1)
+	w.run.stats.sent.Add(1)
+	return true
+}
+
+// read consumes frames until the connection fails or the run closes it.
+func (w *worker) read() {
+	defer close(w.done)
+
+	for {
+		_, frame, err := w.conn.ReadMessage()
+		if err != nil {
+			if !w.run.stopping.Load() {
+				w.run.stats.dropped.Add(1)
+			}
+			return
+		}
+		w.handleFrame(frame, time.Now())
+	}
+}
+
+// handleFrame records every benchmark message and rate_limited error event
+// in a frame. The server may coalesce queued messages into a single frame
+// separated by newlines.
+func (w *worker) handleFrame(frame []byte, receivedAt time.Time) {
+	for _, part := range bytes.Split(frame, []byte{'\n'}) {
+		var msg struct {
+			Type    string `json:"type"`
+			Code    string `json:"code"`
+			Content string `json:"content"`
+		}
+		if err := json.Unmarshal(part, &msg); err != nil {
+			continue
+		}
+		if msg.Type == "error" && msg.Code == "rate_limited" {
+			w.rateLimited.Add(1)
+			w.run.stats.rateLimited.Add(1)
+			continue
+		}
+
+		senderID, seq, sentAt, ok := decodeContent(msg.Content)
+		if !ok {
+			continue
+		}
+
+		w.run.stats.received.Add(1)
+		w.latency.Record(receivedAt.Sub(sentAt))
+		w.run.markDelivered(senderID, seq)
+	}
+}
+
+// markDelivered notes that at least one peer received the given message.
+func (r *run) markDelivered(senderID int, seq int64) {
+	if senderID < 0 || senderID >= len(r.workers) {
+		return
+	}
+	sender := r.workers[senderID].Load()
+	if sender != nil && seq >= 0 && seq < int64(len(sender.delivered)) {
+		sender.delivered[seq].Store(true)
+	}
+}
+
+// close sends a normal close frame and waits briefly for the reader to exit.
+func (w *worker) close() {
+	w.writeMu.Lock()
+	_ = w.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second))
+	w.writeMu.Unlock()
+
+	select {
+	case <-w.done:
+	case <-time.After(time.Second):
+	}
+	_ = w.conn.Close()
+}
+
+// undelivered counts messages this worker sent that no peer received. A
+// worker that was rate limited counts none, since the server does not say
+// which of its messages it dropped.
+func (w *worker) undelivered() int64 {
+	if w.rateLimited.Load() > 0 {
+		return 0
+	}
+	var missing int64
+	sent := w.sent.Load()
+	for seq := int64(0); seq < sent; seq++ {
+		if !w.delivered[seq].Load() {
+			missing++
+		}
+	}
+	return missing
+}
+
+// encodeContent builds a message body of exactly size bytes that carries the
+// sender id, sequence number and send time.
+func encodeContent(id int, seq int64, sentAt time.Time, size int) string {
+	prefix := fmt.Sprintf("bench:%d:%d:%d:", id, seq, sentAt.UnixNano())
+	if len(prefix) >= size {
+		return prefix
+	}
+	return prefix + strings.Repeat("x", size-len(prefix))
+}
+
+// decodeContent parses a body produced by encodeContent.
+func decodeContent(content string) (int, int64, time.Time, bool) {
+	parts := strings.SplitN(content, ":", 5)
+	if len(parts) != 5 || parts[0] != "bench" {
+		return 0, 0, time.Time{}, false
+	}
+
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, time.Time{}, false
+	}
+	seq, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return 0, 0, time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return 0, 0, time.Time{}, false
+	}
+
+	return id, seq, time.Unix(0, nanos), true
+}
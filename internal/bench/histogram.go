@@ -0,0 +1,151 @@
+// Package bench implements a log-bucketed latency histogram so that millions
+// of delivery samples can be summarized without keeping every value.
+package bench
+
+import (
+	"math/bits"
+	"time"
+)
+
+// subBuckets is the number of linear buckets per power of two. 32 keeps the
+// relative error of any reported percentile below roughly 3%.
+const (
+	subBuckets     = 32
+	subBucketBits  = 5
+	histogramSlots = (64 - subBucketBits + 1) * subBuckets
+)
+
+// LatencyHistogram records latency samples with microsecond resolution.
+// It is not safe for concurrent use; each worker keeps its own histogram and
+// the results are combined with Merge once the run has finished.
+type LatencyHistogram struct {
+	counts [histogramSlots]uint64
+	total  uint64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+// NewLatencyHistogram returns an empty histogram ready to record samples.
+func NewLatencyHistogram() *LatencyHistogram {
+	return &LatencyHistogram{}
+}
+
+// Record adds a single latency sample. Negative durations are clamped to zero.
+func (h *LatencyHistogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	h.counts[bucketFor(uint64(d.Microseconds()))]++
+	h.sum += d
+	if h.total == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.total++
+}
+
+// Merge adds all samples from other into h.
+func (h *LatencyHistogram) Merge(other *LatencyHistogram) {
+	if other == nil || other.total == 0 {
+		return
+	}
+
+	for i, count := range other.counts {
+		h.counts[i] += count
+	}
+	if h.total == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+	h.sum += other.sum
+	h.total += other.total
+}
+
+// Count returns the number of recorded samples.
+func (h *LatencyHistogram) Count() uint64 {
+	return h.total
+}
+
+// Min returns the smallest recorded sample.
+func (h *LatencyHistogram) Min() time.Duration {
+	return h.min
+}
+
+// Max returns the largest recorded sample.
+func (h *LatencyHistogram) Max() time.Duration {
+	return h.max
+}
+
+// Mean returns the arithmetic mean of all recorded samples.
+func (h *LatencyHistogram) Mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.total)
+}
+
+// Percentile returns the latency below which the given fraction of samples
+// fall. p is expressed as a fraction, so 0.99 asks for the 99th percentile.
+func (h *LatencyHistogram) Percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	if p <= 0 {
+		return h.min
+	}
+	if p >= 1 {
+		return h.max
+	}
+
+	rank := uint64(p*float64(h.total) + 0.5)
+	if rank == 0 {
+		rank = 1
+	}
+
+	var seen uint64
+	for i, count := range h.counts {
+		seen += count
+		if seen >= rank {
+			return h.clamp(time.Duration(bucketUpperBound(i)) * time.Microsecond)
+		}
+	}
+	return h.max
+}
+
+// clamp keeps bucket-derived estimates inside the observed range.
+func (h *LatencyHistogram) clamp(d time.Duration) time.Duration {
+	if d < h.min {
+		return h.min
+	}
+	if d > h.max {
+		return h.max
+	}
+	return d
+}
+
+// bucketFor maps a value to its bucket index. Values below subBuckets get a
+// bucket each; larger values share a bucket with neighbours that have the
+// same top subBucketBits+1 bits.
+func bucketFor(v uint64) int {
+	if v < subBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(v) - subBucketBits - 1
+	return (shift+1)*subBuckets + int(v>>uint(shift)) - subBuckets
+}
+
+// bucketUpperBound returns the largest value that maps to bucket i.
+func bucketUpperBound(i int) uint64 {
+	if i < subBuckets {
+		return uint64(i)
+	}
+	shift := uint(i/subBuckets - 1)
+	mantissa := uint64(i%subBuckets + subBuckets)
+	return (mantissa+1)<<shift - 1
+}
@@ -0,0 +1,180 @@
+// Package bench summarizes a benchmark run as a Report that can be written
+// as JSON for comparing releases or as plain text for humans.
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Report is the result of a benchmark run. Its JSON form is stable so that
+// results from different releases can be compared by tooling.
+type Report struct {
+	StartedAt   time.Time         `json:"started_at"`
+	Elapsed     float64           `json:"elapsed_seconds"`
+	Settings    ReportSettings    `json:"settings"`
+	Connections ConnectionSummary `json:"connections"`
+	Messages    MessageSummary    `json:"messages"`
+	Throughput  ThroughputSummary `json:"throughput"`
+	Latency     LatencySummary    `json:"latency_ms"`
+}
+
+// ReportSettings echoes the configuration the run used.
+type ReportSettings struct {
+	URL           string  `json:"url"`
+	Clients       int     `json:"clients"`
+	RampRate      float64 `json:"ramp_rate"`
+	MessageRate   float64 `json:"message_rate"`
+	DurationSecs  float64 `json:"duration_seconds"`
+	PayloadSize   int     `json:"payload_size"`
+	DrainTimeSecs float64 `json:"drain_timeout_seconds"`
+}
+
+// ConnectionSummary counts connection outcomes.
+type ConnectionSummary struct {
+	Attempted   int64 `json:"attempted"`
+	Established int64 `json:"established"`
+	Failed      int64 `json:"failed"`
+	// Dropped counts established connections that the server closed
+	// before the run finished.
+	Dropped int64 `json:"dropped"`
+}
+
+// MessageSummary counts message outcomes.
+type MessageSummary struct {
+	Sent       int64 `json:"sent"`
+	SendErrors int64 `json:"send_errors"`
+	Received   int64 `json:"received"`
+	// RateLimited counts the rate_limited error events the server sent.
+	// Each marks the start of a throttling episode, during which any number
+	// of a client's messages may be dropped.
+	RateLimited int64 `json:"rate_limited"`
+	// Undelivered counts messages from clients that were never rate
+	// limited that were written successfully but never reached any peer,
+	// for example because the server dropped a slow consumer. It stays zero
+	// when fewer than two clients connected, since there was no peer to
+	// deliver to.
+	Undelivered int64 `json:"undelivered"`
+}
+
+// ThroughputSummary reports message rates over the sending window.
+type ThroughputSummary struct {
+	SentPerSecond     float64 `json:"sent_per_second"`
+	ReceivedPerSecond float64 `json:"received_per_second"`
+}
+
+// LatencySummary reports end-to-end delivery latency in milliseconds.
+type LatencySummary struct {
+	Samples uint64  `json:"samples"`
+	Min     float64 `json:"min"`
+	Mean    float64 `json:"mean"`
+	P50     float64 `json:"p50"`
+	P90     float64 `json:"p90"`
+	P95     float64 `json:"p95"`
+	P99     float64 `json:"p99"`
+	Max     float64 `json:"max"`
+}
+
+// report assembles the final Report once every worker has stopped.
+func (r *run) report(started time.Time, sendWindow time.Duration) *Report {
+	latency := NewLatencyHistogram()
+	hasPeers := r.stats.established.Load() > 1
+	var undelivered int64
+	for i := range r.workers {
+		if w := r.workers[i].Load(); w != nil {
+			latency.Merge(w.latency)
+			if hasPeers {
+				undelivered += w.undelivered()
+			}
+		}
+	}
+
+	window := sendWindow.Seconds()
+	sent := r.stats.sent.Load()
+	received := r.stats.received.Load()
+
+	return &Report{
+		StartedAt: started.UTC(),
+		Elapsed:   time.Since(started).Seconds(),
+		Settings: ReportSettings{
+			URL:           r.cfg.URL,
+			Clients:       r.cfg.Clients,
+			RampRate:      r.cfg.RampRate,
+			MessageRate:   r.cfg.MessageRate,
+			DurationSecs:  r.cfg.Duration.Seconds(),
+			PayloadSize:   r.cfg.PayloadSize,
+			DrainTimeSecs: r.cfg.DrainTimeout.Seconds(),
+		},
+		Connections: ConnectionSummary{
+			Attempted:   r.stats.attempted.Load(),
+			Established: r.stats.established.Load(),
+			Failed:      r.stats.failed.Load(),
+			Dropped:     r.stats.dropped.Load(),
+		},
+		Messages: MessageSummary{
+			Sent:        sent,
+			SendErrors:  r.stats.sendErrors.Load(),
+			Received:    received,
+			RateLimited: r.stats.rateLimited.Load(),
+			Undelivered: undelivered,
+		},
+		Throughput: ThroughputSummary{
+			SentPerSecond:     perSecond(sent, window),
+			ReceivedPerSecond: perSecond(received, window),
+		},
+		Latency: summarizeLatency(latency),
+	}
+}
+
+func perSecond(count int64, seconds float64) float64 {
+	if seconds <= 0 {
+		return 0
+	}
+	return float64(count) / seconds
+}
+
+func summarizeLatency(h *LatencyHistogram) LatencySummary {
+	return LatencySummary{
+		Samples: h.Count(),
+		Min:     milliseconds(h.Min()),
+		Mean:    milliseconds(h.Mean()),
+		P50:     milliseconds(h.Percentile(0.50)),
+		P90:     milliseconds(h.Percentile(0.90)),
+		P95:     milliseconds(h.Percentile(0.95)),
+		P99:     milliseconds(h.Percentile(0.99)),
+		Max:     milliseconds(h.Max()),
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// WriteJSON writes the report as indented JSON.
+func (rep *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(rep)
+}
+
+// WriteText writes a human-readable summary of the report.
+func (rep *Report) WriteText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, `GoChat benchmark against %s
+  clients:      %d (ramp %.1f/s), %.2f msg/s each, %.0fs window
+  connections:  %d attempted, %d established, %d failed, %d dropped
+  messages:     %d sent, %d received, %d send errors, %d rate limited, %d undelivered
+  throughput:   %.1f sent/s, %.1f received/s
+  latency (ms): min %.2f  mean %.2f  p50 %.2f  p90 %.2f  p95 %.2f  p99 %.2f  max %.2f  (%d samples)
+`,
+		rep.Settings.URL,
+		rep.Settings.Clients, rep.Settings.RampRate, rep.Settings.MessageRate, rep.Settings.DurationSecs,
+		rep.Connections.Attempted, rep.Connections.Established, rep.Connections.Failed, rep.Connections.Dropped,
+		rep.Messages.Sent, rep.Messages.Received, rep.Messages.SendErrors, rep.Messages.RateLimited, rep.Messages.Undelivered,
+		rep.Throughput.SentPerSecond, rep.Throughput.ReceivedPerSecond,
+		rep.Latency.Min, rep.Latency.Mean, rep.Latency.P50, rep.Latency.P90, rep.Latency.P95, rep.Latency.P99,
+		rep.Latency.Max, rep.Latency.Samples,
+	)
+	return err
+}
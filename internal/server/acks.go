@@ -0,0 +1,195 @@
+// Package server implements delivery acknowledgements. A chat message may
+// carry a client_id; the server acks it to the sender with the assigned
+// message id once the message is stored, and answers a retransmission of the
+// same client_id with the original id instead of broadcasting it again.
+// Clients that connect in reliable mode ack the messages they receive, and
+// on their next connection the server redelivers every retained message
+// after the last one their user acked.
+//
+// A guest's identity is a random label that lasts only as long as its
+// connection and any session resumed from it. Client ids therefore dedupe a
+// guest's retransmissions only within that session, and guests cannot use
+// reliable mode, which needs an identity that outlives the connection.
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// maxClientIDBytes bounds the length of a client-side message id.
+const maxClientIDBytes = 64
+
+// clientIDWindow is how many recent client ids are remembered for
+// deduplication across all senders.
+const clientIDWindow = 4096
+
+// Ack frame and event type. Servers ack sent messages and clients ack
+// received ones with the same type.
+const frameAck = "ack"
+
+// ackEvent tells a sender which id its message was stored under. Duplicate
+// is set when the message had already been accepted and was dropped.
+type ackEvent struct {
+	Type      string `json:"type"`
+	ClientID  string `json:"client_id"`
+	ID        uint64 `json:"id"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+}
+
+// deliveryState holds the recently seen client ids, mapped to the message
+// id they were stored under (0 while the message is being broadcast), and
+// the highest message id each user has acked.
+type deliveryState struct {
+	mu      sync.Mutex
+	sent    map[string]uint64
+	order   []string
+	cursors map[string]uint64
+}
+
+func newDeliveryState() *deliveryState {
+	return &deliveryState{
+		sent:    make(map[string]uint64),
+		cursors: make(map[string]uint64),
+	}
+}
+
+func clientIDKey(identity, clientID string) string {
+	return identity + "\x00" + clientID
+}
+
+// lookup returns the message id a client id was stored under and whether
+// the client id has been seen.
+func (d *deliveryState) lookup(identity, clientID string) (uint64, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	id, seen := d.sent[clientIDKey(identity, clientID)]
+	return id, seen
+}
+
+// reserve records a client id before its message is broadcast. It reports
+// false if the client id is already known.
+func (d *deliveryState) reserve(identity, clientID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := clientIDKey(identity, clientID)
+	if _, seen := d.sent[key]; seen {
+		return false
+	}
+	d.sent[key] = 0
+	d.order = append(d.order, key)
+	if len(d.order) > clientIDWindow {
+		delete(d.sent, d.order[0])
+		d.order = d.order[1:]
+	}
+	return true
+}
+
+// complete stores the message id of a reserved client id, or forgets the
+// reservation if the message was not stored.
+func (d *deliveryState) complete(identity, clientID string, id uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := clientIDKey(identity, clientID)
+	if _, reserved := d.sent[key]; !reserved {
+		return
+	}
+	if id == 0 {
+		delete(d.sent, key)
+		return
+	}
+	d.sent[key] = id
+}
+
+// ack moves the ack cursor of user forward to id.
+func (d *deliveryState) ack(user string, id uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if id > d.cursors[user] {
+		d.cursors[user] = id
+	}
+}
+
+// cursor returns the ack cursor of user. If the user has none yet, it is
+// started at latestID, so that only later messages are redelivered.
+func (d *deliveryState) cursor(user string, latestID uint64) uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	cursor, ok := d.cursors[user]
+	if !ok {
+		cursor = latestID
+		d.cursors[user] = cursor
+	}
+	return cursor
+}
+
+// reliableRequested reports whether a connection asked for reliable mode.
+func reliableRequested(r *http.Request) bool {
+	value := r.URL.Query().Get("reliable")
+	return value == "1" || value == "true"
+}
+
+// checkReliable refuses a guest connection that asks for reliable mode with
+// 400. Guests have no ack cursor, so nothing could be redelivered to them.
+func checkReliable(w http.ResponseWriter, r *http.Request, user string) bool {
+	if user != "" || !reliableRequested(r) {
+		return true
+	}
+	log.Printf("Refused reliable mode for guest connection from %s", clientAddr(r))
+	http.Error(w, "Reliable mode requires a user token", http.StatusBadRequest)
+	return false
+}
+
+// prepareRedelivery makes a reliable client that did not ask to resume from
+// a position of its own replay everything after its user's ack cursor.
+// Guests have no cursor, as their identity does not survive a reconnect;
+// checkReliable keeps them out of reliable mode.
+func (h *Hub) prepareRedelivery(client *Client) {
+	if !client.reliable || client.resume || client.user == "" {
+		return
+	}
+	client.resume = true
+	client.resumeAfter = h.delivery.cursor(client.user, h.history.latestID())
+}
+
+// acknowledge handles an ack frame for a received message.
+func (h *Hub) acknowledge(c *Client, id uint64) error {
+	if c.user == "" {
+		return errForbidden
+	}
+	if id == 0 || id > h.history.latestID() {
+		return errMessageNotFound
+	}
+	h.delivery.ack(c.user, id)
+	return nil
+}
+
+// submitChat broadcasts a chat message with a client id, deduplicating
+// retransmissions, and acks it to the sender once it is stored.
+func (h *Hub) submitChat(c *Client, msg *Message, clientID string) {
+	identity := c.identity()
+	if !h.delivery.reserve(identity, clientID) {
+		// Another connection of the same user raced us with this id.
+		return
+	}
+
+	id, ok := h.submit(h.ctx, BroadcastMessage{Sender: c, Message: msg})
+	h.delivery.complete(identity, clientID, id)
+	if ok {
+		h.sendAck(c, clientID, id, false)
+	}
+}
+
+// sendAck tells a sender the id its message was stored under.
+func (h *Hub) sendAck(c *Client, clientID string, id uint64, duplicate bool) {
+	payload, err := json.Marshal(ackEvent{Type: frameAck, ClientID: clientID, ID: id, Duplicate: duplicate})
+	if err != nil {
+		log.Printf("Error encoding ack for %s: %v", c.addr, err)
+		return
+	}
+	h.sendEvent(c, payload)
+}
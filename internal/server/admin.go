@@ -0,0 +1,185 @@
+// Package server implements the operator-facing admin API. Requests must
+// carry the configured admin token; the API is disabled when no token is set.
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// authorizeAdmin checks the request's bearer token against the configured
+// admin token, writing an error response and returning false on failure.
+func authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	token := currentConfig().AdminToken
+	if token == "" {
+		http.Error(w, "Admin API is disabled", http.StatusNotFound)
+		return false
+	}
+
+	scheme, presented, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") ||
+		subtle.ConstantTimeCompare([]byte(strings.TrimSpace(presented)), []byte(token)) != 1 {
+		log.Printf("Rejected admin request from %s", clientIP(r))
+		auditRefusal(r, auditAuthFailed, "", "invalid admin token")
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gochat-admin"`)
+		http.Error(w, "Invalid or missing admin token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// writeJSON writes v as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing JSON response: %v", err)
+	}
+}
+
+// banRequest is the body of POST /admin/bans. It names either an address
+// range in CIDR or a user. Duration is a Go duration string such as "30m" or
+// "24h"; an empty duration bans permanently.
+type banRequest struct {
+	CIDR     string `json:"cidr"`
+	User     string `json:"user"`
+	Reason   string `json:"reason"`
+	Duration string `json:"duration"`
+}
+
+// banResponse reports a new ban and how many clients it disconnected.
+type banResponse struct {
+	Ban
+	Disconnected int `json:"disconnected"`
+}
+
+// BansHandler serves /admin/bans:
+//
+//	GET    lists active bans
+//	POST   adds a ban and disconnects matching clients
+//	DELETE lifts the ban given by the cidr or user query parameter
+func BansHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, bans.list())
+	case http.MethodPost:
+		addBan(w, r)
+	case http.MethodDelete:
+		liftBan(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "Method not allowed. Bans endpoint accepts GET, POST and DELETE requests.", http.StatusMethodNotAllowed)
+	}
+}
+
+func addBan(w http.ResponseWriter, r *http.Request) {
+	var req banRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, "Invalid ban request", http.StatusBadRequest)
+		return
+	}
+
+	var duration time.Duration
+	if req.Duration != "" {
+		parsed, err := time.ParseDuration(req.Duration)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "Invalid ban duration", http.StatusBadRequest)
+			return
+		}
+		duration = parsed
+	}
+
+	if req.User != "" {
+		if req.CIDR != "" {
+			http.Error(w, "Specify either cidr or user", http.StatusBadRequest)
+			return
+		}
+		banUser(w, r, req, duration)
+		return
+	}
+
+	ban, network, err := bans.add(req.CIDR, req.Reason, duration)
+	if errors.Is(err, errInvalidCIDR) {
+		http.Error(w, "Invalid IP address or CIDR", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		// The ban is active in memory even if it could not be persisted.
+		log.Printf("Failed to persist ban on %s: %v", ban.CIDR, err)
+	}
+
+	disconnected := hub.disconnectNetwork(network, closeReason("banned", ban.Reason))
+	log.Printf("Banned %s (%s), disconnected %d clients", ban.CIDR, ban.Reason, disconnected)
+	recordAudit(auditRecord{Action: frameBan, Actor: "admin", IP: clientIP(r), Target: ban.CIDR, Reason: ban.Reason, Duration: req.Duration})
+
+	writeJSON(w, http.StatusCreated, banResponse{Ban: ban, Disconnected: disconnected})
+}
+
+func banUser(w http.ResponseWriter, r *http.Request, req banRequest, duration time.Duration) {
+	ban, err := bans.addUser(req.User, req.Reason, duration)
+	if err != nil {
+		log.Printf("Failed to persist ban on user %s: %v", ban.User, err)
+	}
+
+	disconnected := hub.disconnectUser(ban.User, closeReason("banned", ban.Reason))
+	log.Printf("Banned user %s (%s), disconnected %d clients", ban.User, ban.Reason, disconnected)
+	recordAudit(auditRecord{Action: frameBan, Actor: "admin", IP: clientIP(r), Target: ban.User, Reason: ban.Reason, Duration: req.Duration})
+
+	writeJSON(w, http.StatusCreated, banResponse{Ban: ban, Disconnected: disconnected})
+}
+
+func liftBan(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("cidr")
+	var removed bool
+	var err error
+	if user := r.URL.Query().Get("user"); user != "" {
+		target = user
+		removed, err = bans.removeUser(user)
+	} else {
+		removed, err = bans.remove(target)
+	}
+	if errors.Is(err, errInvalidCIDR) {
+		http.Error(w, "Invalid IP address or CIDR", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to persist removal of ban on %s: %v", target, err)
+	}
+	if !removed {
+		http.Error(w, "Ban not found", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Lifted ban on %s", target)
+	recordAudit(auditRecord{Action: frameUnban, Actor: "admin", IP: clientIP(r), Target: target})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// inboxesResponse is the body of GET /admin/inboxes.
+type inboxesResponse struct {
+	Inboxes []InboxSize `json:"inboxes"`
+}
+
+// InboxesHandler serves GET /admin/inboxes, which lists the offline inbox
+// of every user with queued direct messages.
+func InboxesHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed. Inboxes endpoint only accepts GET requests.", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, inboxesResponse{Inboxes: hub.inboxes.sizes(currentConfig().Inbox)})
+}
@@ -0,0 +1,235 @@
+// Package server implements admission control for new connections: a cap on
+// total connections, a cap on concurrent connections per client IP or
+// network prefix, and a limit on how fast one address may open connections.
+package server
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ConnectionLimitConfig bounds how many connections the server accepts.
+// Per-IP limits group addresses by network prefix, so with an IPv6 prefix of
+// 64 every address in a /64 shares one allowance.
+type ConnectionLimitConfig struct {
+	MaxConnections      int
+	MaxConnectionsPerIP int
+	IPv4Prefix          int
+	IPv6Prefix          int
+	UpgradeBurst        int
+	UpgradeInterval     time.Duration
+	RetryAfter          time.Duration
+}
+
+func defaultConnectionLimitConfig() ConnectionLimitConfig {
+	return ConnectionLimitConfig{
+		MaxConnections:      10000,
+		MaxConnectionsPerIP: 100,
+		IPv4Prefix:          32,
+		IPv6Prefix:          64,
+		UpgradeBurst:        50,
+		UpgradeInterval:     time.Second,
+		RetryAfter:          5 * time.Second,
+	}
+}
+
+func sanitizeConnectionLimitConfig(cfg ConnectionLimitConfig) ConnectionLimitConfig {
+	defaults := defaultConnectionLimitConfig()
+	if cfg.MaxConnections <= 0 {
+		cfg.MaxConnections = defaults.MaxConnections
+	}
+	if cfg.MaxConnectionsPerIP <= 0 {
+		cfg.MaxConnectionsPerIP = defaults.MaxConnectionsPerIP
+	}
+	if cfg.IPv4Prefix <= 0 || cfg.IPv4Prefix > 32 {
+		cfg.IPv4Prefix = defaults.IPv4Prefix
+	}
+	if cfg.IPv6Prefix <= 0 || cfg.IPv6Prefix > 128 {
+		cfg.IPv6Prefix = defaults.IPv6Prefix
+	}
+	if cfg.UpgradeBurst <= 0 {
+		cfg.UpgradeBurst = defaults.UpgradeBurst
+	}
+	if cfg.UpgradeInterval <= 0 {
+		cfg.UpgradeInterval = defaults.UpgradeInterval
+	}
+	if cfg.RetryAfter <= 0 {
+		cfg.RetryAfter = defaults.RetryAfter
+	}
+	return cfg
+}
+
+// admissionIdleLimit is how long an upgrade limiter for an address with no
+// open connections is kept before it is discarded.
+const admissionIdleLimit = 10 * time.Minute
+
+// upgradeLimiter is the upgrade-rate bucket for one address group.
+type upgradeLimiter struct {
+	limiter  *rateLimiter
+	lastSeen time.Time
+}
+
+// admissionControl tracks open connections per address group.
+type admissionControl struct {
+	mu        sync.Mutex
+	total     int
+	perIP     map[string]int
+	limiters  map[string]*upgradeLimiter
+	lastSweep time.Time
+}
+
+var admission = &admissionControl{
+	perIP:    make(map[string]int),
+	limiters: make(map[string]*upgradeLimiter),
+}
+
+// admissionError describes a refused connection.
+type admissionError struct {
+	status     int
+	retryAfter time.Duration
+	reason     string
+}
+
+func (e *admissionError) Error() string {
+	return e.reason
+}
+
+// admit reserves a connection slot for the request's client address and
+// returns the key to release it with. Denied and banned addresses get a 403;
+// other refusals get a 429 or 503 response with Retry-After, as do all
+// connections while the server drains. Denied addresses are refused before
+// the limits are consulted, and callers check the origin before calling
+// admit, so that rejected requests do not use up the allowance of an address.
+func admit(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if refuseWhileDraining(w) {
+		return "", false
+	}
+	if !checkIPAccess(w, r) {
+		return "", false
+	}
+
+	ip := clientIP(r)
+	key, err := admission.acquire(ip, currentConfig().ConnectionLimits)
+	if err != nil {
+		log.Printf("Refused connection from %s: %s", ip, err.reason)
+		seconds := int(math.Ceil(err.retryAfter.Seconds()))
+		if seconds < 1 {
+			seconds = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		http.Error(w, err.reason, err.status)
+		return "", false
+	}
+	return key, true
+}
+
+// acquire checks the limits for ip and, if they allow it, counts a new
+// connection against them.
+func (a *admissionControl) acquire(ip string, cfg ConnectionLimitConfig) (string, *admissionError) {
+	key := admissionKey(ip, cfg)
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.sweepLocked(now)
+
+	limiter := a.limiterLocked(key, cfg, now)
+	if !limiter.allow() {
+		return "", &admissionError{
+			status:     http.StatusTooManyRequests,
+			retryAfter: limiter.retryAfter(),
+			reason:     "Too many connection attempts",
+		}
+	}
+
+	if a.perIP[key] >= cfg.MaxConnectionsPerIP {
+		return "", &admissionError{
+			status:     http.StatusTooManyRequests,
+			retryAfter: cfg.RetryAfter,
+			reason:     fmt.Sprintf("Too many connections from this address (limit %d)", cfg.MaxConnectionsPerIP),
+		}
+	}
+
+	if a.total >= cfg.MaxConnections {
+		return "", &admissionError{
+			status:     http.StatusServiceUnavailable,
+			retryAfter: cfg.RetryAfter,
+			reason:     "Server is at connection capacity",
+		}
+	}
+
+	a.perIP[key]++
+	a.total++
+	return key, nil
+}
+
+// release frees a slot reserved by acquire.
+func (a *admissionControl) release(key string) {
+	if key == "" {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.perIP[key] <= 1 {
+		delete(a.perIP, key)
+	} else {
+		a.perIP[key]--
+	}
+	if a.total > 0 {
+		a.total--
+	}
+}
+
+func (a *admissionControl) limiterLocked(key string, cfg ConnectionLimitConfig, now time.Time) *rateLimiter {
+	entry, ok := a.limiters[key]
+	if !ok {
+		entry = &upgradeLimiter{limiter: newRateLimiter(cfg.UpgradeBurst, cfg.UpgradeInterval)}
+		a.limiters[key] = entry
+	}
+	entry.lastSeen = now
+	return entry.limiter
+}
+
+// resetLimiters discards every upgrade-rate bucket. It is called when the
+// configuration changes, since limits and address grouping may have changed.
+// Open connection counts are kept.
+func (a *admissionControl) resetLimiters() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.limiters = make(map[string]*upgradeLimiter)
+}
+
+// sweepLocked discards upgrade limiters for idle addresses, at most once a
+// minute, so that the map does not grow with every address ever seen.
+func (a *admissionControl) sweepLocked(now time.Time) {
+	if now.Sub(a.lastSweep) < time.Minute {
+		return
+	}
+	a.lastSweep = now
+	for key, entry := range a.limiters {
+		if a.perIP[key] == 0 && now.Sub(entry.lastSeen) > admissionIdleLimit {
+			delete(a.limiters, key)
+		}
+	}
+}
+
+// admissionKey groups an address by the configured network prefix.
+func admissionKey(ip string, cfg ConnectionLimitConfig) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(cfg.IPv4Prefix, 32)), Mask: net.CIDRMask(cfg.IPv4Prefix, 32)}).String()
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(cfg.IPv6Prefix, 128)), Mask: net.CIDRMask(cfg.IPv6Prefix, 128)}).String()
+}
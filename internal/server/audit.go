@@ -0,0 +1,174 @@
+// Package server records moderation actions and security-relevant events,
+// such as rejected origins, rate-limit drops, oversized messages, failed
+// authentication and admin actions, in an audit log. Each event is one JSON
+// object per line, appended to the configured audit log file or, when none
+// is configured, written to the server log. The file is rotated when it
+// reaches its maximum size.
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Security event types recorded in the audit log.
+const (
+	auditOriginRejected  = "origin_rejected"
+	auditRateLimited     = "rate_limited"
+	auditMessageTooLarge = "message_too_large"
+	auditAuthFailed      = "auth_failed"
+	auditAccessDenied    = "access_denied"
+)
+
+// Refused requests are recorded at most refusalAuditBurst times per
+// refusalAuditInterval for each action and address, so that a client
+// retrying while refused cannot flood the audit log.
+const (
+	refusalAuditBurst          = 20
+	refusalAuditInterval       = time.Minute
+	refusalAuditPruneThreshold = 1024
+)
+
+// refusalLimiter is the audit budget of one action and address.
+type refusalLimiter struct {
+	limiter  *rateLimiter
+	lastSeen time.Time
+}
+
+var (
+	refusalLimitersMu sync.Mutex
+	refusalLimiters   = make(map[string]*refusalLimiter)
+)
+
+// auditMu serializes writes so that concurrent records do not interleave.
+var auditMu sync.Mutex
+
+// auditRecord is one line of the audit log. Actor is the identity that
+// caused the event, if known, and IP the address of the client.
+type auditRecord struct {
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"`
+	Actor    string    `json:"actor"`
+	IP       string    `json:"ip,omitempty"`
+	Target   string    `json:"target,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	Duration string    `json:"duration,omitempty"`
+}
+
+// auditRequest records a security event caused by an HTTP request.
+func auditRequest(r *http.Request, action, actor, reason string) {
+	recordAudit(auditRecord{Action: action, Actor: actor, IP: clientIP(r), Reason: reason})
+}
+
+// auditRefusal records a refused request like auditRequest, within the
+// refusal budget of its action and address. Like rate_limited records, the
+// refusal that exhausts the budget says so, and later ones are dropped until
+// the budget refills.
+func auditRefusal(r *http.Request, action, actor, reason string) {
+	ip := clientIP(r)
+	allowed, started := throttleRefusalAudit(action, ip)
+	if !allowed {
+		if !started {
+			return
+		}
+		reason = strings.TrimPrefix(reason+"; further records suppressed", "; ")
+	}
+	recordAudit(auditRecord{Action: action, Actor: actor, IP: ip, Reason: reason})
+}
+
+func throttleRefusalAudit(action, ip string) (allowed, started bool) {
+	refusalLimitersMu.Lock()
+	defer refusalLimitersMu.Unlock()
+
+	now := time.Now()
+	key := action + "\x00" + ip
+	entry, ok := refusalLimiters[key]
+	if !ok {
+		if len(refusalLimiters) >= refusalAuditPruneThreshold {
+			for other, idle := range refusalLimiters {
+				if now.Sub(idle.lastSeen) >= refusalAuditInterval {
+					delete(refusalLimiters, other)
+				}
+			}
+		}
+		entry = &refusalLimiter{limiter: newRateLimiter(refusalAuditBurst, refusalAuditInterval)}
+		refusalLimiters[key] = entry
+	}
+	entry.lastSeen = now
+	return entry.limiter.throttle()
+}
+
+// resetRefusalAudits discards every refusal budget when the configuration
+// changes, like the upgrade-rate buckets.
+func resetRefusalAudits() {
+	refusalLimitersMu.Lock()
+	defer refusalLimitersMu.Unlock()
+	refusalLimiters = make(map[string]*refusalLimiter)
+}
+
+// audit records a security event caused by a connected client.
+func (c *Client) audit(action, reason string) {
+	recordAudit(auditRecord{Action: action, Actor: c.identity(), IP: c.ip, Reason: reason})
+}
+
+// recordAudit appends a record to the audit log. Failures are logged and
+// otherwise ignored so that a full disk cannot block moderation.
+func recordAudit(record auditRecord) {
+	if record.Time.IsZero() {
+		record.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Error encoding audit record: %v", err)
+		return
+	}
+
+	cfg := currentConfig()
+	path := cfg.AuditLogPath
+	if path == "" {
+		log.Printf("Audit: %s", line)
+		return
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	if info, err := os.Stat(path); err == nil && info.Size() > 0 && info.Size()+int64(len(line))+1 > cfg.AuditMaxBytes {
+		rotateAuditLog(path, cfg.AuditBackups)
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600) // #nosec G304 -- path comes from operator configuration
+	if err != nil {
+		log.Printf("Error opening audit log %s: %v", path, err)
+		return
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		log.Printf("Error writing audit log %s: %v", path, err)
+	}
+	if err := file.Close(); err != nil {
+		log.Printf("Error closing audit log %s: %v", path, err)
+	}
+}
+
+// rotateAuditLog renames the audit log to path.1, shifting older backups up
+// and dropping any beyond backups.
+func rotateAuditLog(path string, backups int) {
+	backup := func(n int) string { return path + "." + strconv.Itoa(n) }
+	if err := os.Remove(backup(backups)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing old audit log %s: %v", backup(backups), err)
+	}
+	for n := backups - 1; n >= 1; n-- {
+		if err := os.Rename(backup(n), backup(n+1)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error rotating audit log %s: %v", backup(n), err)
+		}
+	}
+	if err := os.Rename(path, backup(1)); err != nil {
+		log.Printf("Error rotating audit log %s: %v", path, err)
+	}
+}
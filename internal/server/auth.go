@@ -0,0 +1,164 @@
+// Package server authenticates chat clients against the configured user
+// accounts and assigns their roles. Clients that present no token join as
+// guests.
+package server
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Role is a client's privilege level.
+type Role string
+
+// Roles from most to least privileged.
+const (
+	RoleOwner     Role = "owner"
+	RoleModerator Role = "moderator"
+	RoleMember    Role = "member"
+	RoleGuest     Role = "guest"
+)
+
+// rank orders roles so that a higher rank may moderate a lower one.
+func (r Role) rank() int {
+	switch r {
+	case RoleOwner:
+		return 3
+	case RoleModerator:
+		return 2
+	case RoleMember:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// canModerate reports whether the role may use moderation commands.
+func (r Role) canModerate() bool {
+	return r.rank() >= RoleModerator.rank()
+}
+
+// UserAccount is a named user who authenticates with Token.
+type UserAccount struct {
+	Name  string
+	Token string
+	Role  Role
+}
+
+// parseUsers parses a comma-separated list of name:token[:role] entries.
+func parseUsers(value string) []UserAccount {
+	var users []UserAccount
+	for _, part := range strings.Split(value, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ":")
+		if len(fields) < 2 || len(fields) > 3 {
+			log.Printf("Ignoring user entry: expected name:token or name:token:role")
+			continue
+		}
+		user := UserAccount{Name: strings.TrimSpace(fields[0]), Token: strings.TrimSpace(fields[1])}
+		if len(fields) == 3 {
+			user.Role = Role(strings.ToLower(strings.TrimSpace(fields[2])))
+		}
+		users = append(users, user)
+	}
+	return users
+}
+
+func sanitizeUsers(users []UserAccount) []UserAccount {
+	valid := make([]UserAccount, 0, len(users))
+	seen := make(map[string]bool, len(users))
+	for _, user := range users {
+		if user.Name == "" || user.Token == "" {
+			log.Printf("Ignoring user account with an empty name or token")
+			continue
+		}
+		if seen[user.Name] {
+			log.Printf("Ignoring duplicate user account %q", user.Name)
+			continue
+		}
+		switch user.Role {
+		case RoleOwner, RoleModerator, RoleMember:
+		case "":
+			user.Role = RoleMember
+		default:
+			log.Printf("Unknown role %q for user %q; using %q", user.Role, user.Name, RoleMember)
+			user.Role = RoleMember
+		}
+		seen[user.Name] = true
+		valid = append(valid, user)
+	}
+	return valid
+}
+
+// userRole returns the configured role of a named user, or RoleGuest if
+// there is no such user.
+func userRole(name string) Role {
+	for _, user := range currentConfig().Users {
+		if user.Name == name {
+			return user.Role
+		}
+	}
+	return RoleGuest
+}
+
+// requestToken returns the token a client presented, from the token query
+// parameter (which browsers can set on WebSocket and EventSource URLs) or an
+// Authorization bearer header.
+func requestToken(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if found && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// authenticateClient resolves the request's user and role. Requests without
+// a token are guests. An unknown token gets a 401 and a banned user a 403;
+// in both cases false is returned.
+func authenticateClient(w http.ResponseWriter, r *http.Request) (string, Role, bool) {
+	token := requestToken(r)
+	if token == "" {
+		return "", RoleGuest, true
+	}
+
+	var account *UserAccount
+	users := currentConfig().Users
+	for i := range users {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(users[i].Token)) == 1 && account == nil {
+			account = &users[i]
+		}
+	}
+	if account == nil {
+		log.Printf("Rejected unknown token from %s", clientIP(r))
+		auditRefusal(r, auditAuthFailed, "", "unknown user token")
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return "", "", false
+	}
+
+	if bans.userBanned(account.Name) {
+		log.Printf("Refused banned user %s from %s", account.Name, clientIP(r))
+		auditRefusal(r, auditAccessDenied, account.Name, "user is banned")
+		http.Error(w, "User is banned", http.StatusForbidden)
+		return "", "", false
+	}
+
+	return account.Name, account.Role, true
+}
+
+// guestNamePrefix starts the random label that identifies a guest connection.
+const guestNamePrefix = "guest-"
+
+// identity is the name used for the client in moderation and slow mode:
+// the user name, or a random per-connection label for guests. Guest labels
+// are not derived from the client address, since every client behind one
+// proxy or NAT shares it.
+func (c *Client) identity() string {
+	if c.user != "" {
+		return c.user
+	}
+	return c.guestName
+}
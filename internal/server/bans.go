@@ -0,0 +1,377 @@
+// Package server filters clients by IP address and user. Static allow and
+// deny lists come from the configuration; bans on address ranges or users are
+// added and lifted at runtime through the admin API or moderation commands,
+// can expire, and are persisted to a local file so that they survive
+// restarts.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Ban is a runtime block on an address range or, if User is set, on a user
+// account. A nil ExpiresAt never expires.
+type Ban struct {
+	CIDR      string     `json:"cidr,omitempty"`
+	User      string     `json:"user,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func (b Ban) expired(now time.Time) bool {
+	return b.ExpiresAt != nil && !now.Before(*b.ExpiresAt)
+}
+
+// key identifies the ban in the ban list.
+func (b Ban) key() string {
+	if b.User != "" {
+		return userBanKey(b.User)
+	}
+	return b.CIDR
+}
+
+func userBanKey(name string) string {
+	return "user:" + name
+}
+
+// banList holds the active bans. It is loaded from the configured file the
+// first time it is used, and again after the configured path changes. The
+// path is set by sanitizeConfig, so that checks do not copy the whole
+// configuration.
+type banList struct {
+	mu     sync.Mutex
+	path   string
+	loaded bool
+	bans   map[string]Ban
+	nets   map[string]*net.IPNet
+}
+
+var bans = &banList{}
+
+// errInvalidCIDR is returned when a ban names an unparseable address range.
+var errInvalidCIDR = errors.New("invalid IP address or CIDR")
+
+// ipAccessDenied reports why ip may not use the server, or "" if it may.
+func ipAccessDenied(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+
+	configMu.RLock()
+	denied := ipInNets(parsed, deniedNets)
+	notAllowed := len(allowedNets) > 0 && !ipInNets(parsed, allowedNets)
+	configMu.RUnlock()
+
+	switch {
+	case denied:
+		return "Address is denied"
+	case notAllowed:
+		return "Address is not allowed"
+	case bans.matches(parsed):
+		return "Address is banned"
+	default:
+		return ""
+	}
+}
+
+// checkIPAccess writes a 403 response and returns false if the request's
+// client address is denied, not allowed, or banned.
+func checkIPAccess(w http.ResponseWriter, r *http.Request) bool {
+	ip := clientIP(r)
+	if reason := ipAccessDenied(ip); reason != "" {
+		log.Printf("Refused request from %s: %s", ip, reason)
+		auditRefusal(r, auditAccessDenied, "", strings.ToLower(reason))
+		http.Error(w, reason, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// matches reports whether ip is covered by an unexpired ban.
+func (bl *banList) matches(ip net.IP) bool {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	bl.ensureLoadedLocked()
+
+	now := time.Now()
+	for key, network := range bl.nets {
+		if network.Contains(ip) && !bl.bans[key].expired(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// userBanned reports whether a user account is under an unexpired ban.
+func (bl *banList) userBanned(name string) bool {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	bl.ensureLoadedLocked()
+
+	ban, ok := bl.bans[userBanKey(name)]
+	return ok && !ban.expired(time.Now())
+}
+
+// list returns the unexpired bans ordered by creation time.
+func (bl *banList) list() []Ban {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	bl.ensureLoadedLocked()
+
+	now := time.Now()
+	result := make([]Ban, 0, len(bl.bans))
+	for _, ban := range bl.bans {
+		if !ban.expired(now) {
+			result = append(result, ban)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.Before(result[j].CreatedAt) })
+	return result
+}
+
+// add records a ban on an address range, replacing any existing ban on the
+// same range, and returns it with the range in canonical form.
+func (bl *banList) add(cidr, reason string, duration time.Duration) (Ban, *net.IPNet, error) {
+	network := parseCIDRs([]string{cidr}, "ban")
+	if len(network) == 0 {
+		return Ban{}, nil, errInvalidCIDR
+	}
+
+	ban := newBan(reason, duration)
+	ban.CIDR = network[0].String()
+	return ban, network[0], bl.store(ban, network[0])
+}
+
+// addUser records a ban on a user account.
+func (bl *banList) addUser(name, reason string, duration time.Duration) (Ban, error) {
+	ban := newBan(reason, duration)
+	ban.User = name
+	return ban, bl.store(ban, nil)
+}
+
+func newBan(reason string, duration time.Duration) Ban {
+	now := time.Now().UTC()
+	ban := Ban{Reason: reason, CreatedAt: now}
+	if duration > 0 {
+		expires := now.Add(duration)
+		ban.ExpiresAt = &expires
+	}
+	return ban
+}
+
+func (bl *banList) store(ban Ban, network *net.IPNet) error {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	bl.ensureLoadedLocked()
+
+	bl.bans[ban.key()] = ban
+	if network != nil {
+		bl.nets[ban.key()] = network
+	}
+	bl.pruneLocked(ban.CreatedAt)
+	return bl.saveLocked()
+}
+
+// remove lifts a ban on an address range and reports whether one existed.
+func (bl *banList) remove(cidr string) (bool, error) {
+	network := parseCIDRs([]string{cidr}, "ban")
+	if len(network) == 0 {
+		return false, errInvalidCIDR
+	}
+	return bl.removeKey(network[0].String())
+}
+
+// removeUser lifts a ban on a user account and reports whether one existed.
+func (bl *banList) removeUser(name string) (bool, error) {
+	return bl.removeKey(userBanKey(name))
+}
+
+func (bl *banList) removeKey(key string) (bool, error) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	bl.ensureLoadedLocked()
+
+	if _, ok := bl.bans[key]; !ok {
+		return false, nil
+	}
+	delete(bl.bans, key)
+	delete(bl.nets, key)
+	bl.pruneLocked(time.Now())
+	return true, bl.saveLocked()
+}
+
+func (bl *banList) pruneLocked(now time.Time) {
+	for key, ban := range bl.bans {
+		if ban.expired(now) {
+			delete(bl.bans, key)
+			delete(bl.nets, key)
+		}
+	}
+}
+
+// setPath records the configured ban file and, if it changed, makes the
+// next use reload the list from it.
+func (bl *banList) setPath(path string) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+
+	if bl.path != path {
+		bl.path = path
+		bl.loaded = false
+	}
+}
+
+// ensureLoadedLocked loads the ban file if it has not been loaded since the
+// configured path last changed. A missing file is an empty ban list.
+func (bl *banList) ensureLoadedLocked() {
+	if bl.loaded {
+		return
+	}
+	path := bl.path
+	bl.loaded = true
+	bl.bans = make(map[string]Ban)
+	bl.nets = make(map[string]*net.IPNet)
+
+	if path == "" {
+		return
+	}
+
+	data, err := os.ReadFile(path) // #nosec G304 -- path comes from operator configuration
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Failed to read ban list %s: %v", path, err)
+		}
+		return
+	}
+
+	var stored []Ban
+	if err := json.Unmarshal(data, &stored); err != nil {
+		log.Printf("Failed to parse ban list %s: %v", path, err)
+		return
+	}
+
+	now := time.Now()
+	for _, ban := range stored {
+		if ban.expired(now) {
+			continue
+		}
+		if ban.User != "" {
+			ban.CIDR = ""
+			bl.bans[ban.key()] = ban
+			continue
+		}
+		network := parseCIDRs([]string{ban.CIDR}, "ban")
+		if len(network) == 0 {
+			continue
+		}
+		ban.CIDR = network[0].String()
+		bl.bans[ban.key()] = ban
+		bl.nets[ban.key()] = network[0]
+	}
+	log.Printf("Loaded %d bans from %s", len(bl.bans), path)
+}
+
+// saveLocked writes the ban list to the configured file, replacing it
+// atomically so that a crash cannot leave a truncated file behind.
+func (bl *banList) saveLocked() error {
+	if bl.path == "" {
+		return nil
+	}
+
+	stored := make([]Ban, 0, len(bl.bans))
+	for _, ban := range bl.bans {
+		stored = append(stored, ban)
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].CreatedAt.Before(stored[j].CreatedAt) })
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(bl.path), ".bans-*.json")
+	if err != nil {
+		return fmt.Errorf("create ban list: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write ban list: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write ban list: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), bl.path); err != nil {
+		return fmt.Errorf("replace ban list: %w", err)
+	}
+	return nil
+}
+
+// ipAddress returns the client's IP address as recorded at connect time.
+func (c *Client) ipAddress() net.IP {
+	host, _, err := net.SplitHostPort(c.addr)
+	if err != nil {
+		host = c.addr
+	}
+	return net.ParseIP(host)
+}
+
+// disconnectNetwork drops every client whose address is in network.
+func (h *Hub) disconnectNetwork(network *net.IPNet, reason string) int {
+	disconnected := h.disconnectWhere(func(client *Client) bool {
+		ip := client.ipAddress()
+		return ip != nil && network.Contains(ip)
+	}, websocket.ClosePolicyViolation, reason)
+	if disconnected > 0 {
+		log.Printf("Disconnected %d clients in %s: %s", disconnected, network, reason)
+	}
+	return disconnected
+}
+
+// disconnectWhere drops every client for which match returns true.
+// WebSocket clients get a close frame with code and reason; streaming
+// clients have their session closed.
+func (h *Hub) disconnectWhere(match func(*Client) bool, code int, reason string) int {
+	h.mutex.Lock()
+	var sockets, streams []*Client
+	for client := range h.clients {
+		if !match(client) {
+			continue
+		}
+		if client.transport == transportWebSocket {
+			// Disconnected clients may not resume their session.
+			client.evicted = true
+			sockets = append(sockets, client)
+			continue
+		}
+		h.forgetClientLocked(client)
+		streams = append(streams, client)
+	}
+	clientCount := len(h.clients)
+	h.mutex.Unlock()
+
+	for _, client := range streams {
+		close(client.send)
+		h.emitPresence(WebhookEventLeave, client, clientCount)
+	}
+	for _, client := range sockets {
+		client.closeWithReason(code, reason)
+	}
+	return len(sockets) + len(streams)
+}
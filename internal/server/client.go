@@ -3,27 +3,89 @@
 package server
 
 import (
+	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"io"
 	"log"
+	"strconv"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
 )
 
+// clientTransport identifies how a client is attached to the hub.
+type clientTransport int
+
+const (
+	// transportWebSocket clients are served by readPump and writePump.
+	transportWebSocket clientTransport = iota
+	// transportSSE clients receive through a text/event-stream response and
+	// send through POST /messages.
+	transportSSE
+	// transportLongPoll clients receive through GET /poll and send through
+	// POST /messages.
+	transportLongPoll
+)
+
+// name returns the label of the transport used in configuration and
+// webhook payloads.
+func (t clientTransport) name() string {
+	switch t {
+	case transportSSE:
+		return "sse"
+	case transportLongPoll:
+		return "longpoll"
+	default:
+		return "websocket"
+	}
+}
+
+// parseTransportName returns the transport labelled name.
+func parseTransportName(name string) (clientTransport, bool) {
+	for _, t := range []clientTransport{transportWebSocket, transportSSE, transportLongPoll} {
+		if t.name() == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
 // Client represents a WebSocket client connection in the chat system.
 // It manages the connection state, message sending channel, hub reference,
-// and client address information.
+// and client address information. Clients on fallback transports have no
+// conn; their HTTP handlers drain the send channel instead of writePump.
 type Client struct {
 	conn           *websocket.Conn
 	send           chan []byte
 	hub            *Hub
 	addr           string
+	ip             string
 	closed         bool
 	maxMessageSize int64
 	rateLimiter    *rateLimiter
 	rateLimit      RateLimitConfig
+	typingLimiter  *rateLimiter
+	transport      clientTransport
+	sessionID      string
+	resume         bool
+	resumeAfter    uint64
+	poll           *longPollSession
+	admissionKey   string
+	user           string
+	role           Role
+	reliable       bool
+	guestName      string
+	readDone       chan struct{}
+	writeDone      chan struct{}
+	unsent         []byte
+	resumeFrom     string
+	resumeToken    string
+	parked         bool
+	parkTimer      *time.Timer
+	evicted        bool
+	keepalive      KeepalivePolicy
 }
 
 // NewClient creates a new Client instance with the provided WebSocket connection,
@@ -45,6 +107,22 @@ func NewClient(conn *websocket.Conn, hub *Hub, addr string) *Client {
 		maxMessageSize: cfg.MaxMessageSize,
 		rateLimiter:    limiter,
 		rateLimit:      cfg.RateLimit,
+		typingLimiter:  newRateLimiter(cfg.Typing.RateLimit.Burst, cfg.Typing.RateLimit.RefillInterval),
+		role:           RoleGuest,
+		guestName:      guestNamePrefix + rand.Text(),
+		keepalive:      cfg.Keepalive.policy(transportWebSocket),
+	}
+}
+
+// frame encodes a broadcast entry in the wire format of the client's transport.
+func (c *Client) frame(entry historyEntry) []byte {
+	switch c.transport {
+	case transportSSE:
+		return encodeSSEEvent(entry)
+	case transportLongPoll:
+		return encodeLongPollEvent(entry)
+	default:
+		return entry.Payload
 	}
 }
 
@@ -59,11 +137,11 @@ func (c *Client) setupReadConnection() {
 	if c.conn == nil {
 		return
 	}
-	if err := c.conn.SetReadDeadline(time.Now().Add(60 * time.Second)); err != nil {
+	if err := c.conn.SetReadDeadline(time.Now().Add(c.keepalive.PongWait)); err != nil {
 		log.Printf("Error setting initial read deadline for %s: %v", c.addr, err)
 	}
 	c.conn.SetPongHandler(func(string) error {
-		if err := c.conn.SetReadDeadline(time.Now().Add(60 * time.Second)); err != nil {
+		if err := c.conn.SetReadDeadline(time.Now().Add(c.keepalive.PongWait)); err != nil {
 			log.Printf("Error setting read deadline in pong handler for %s: %v", c.addr, err)
 		}
 		return nil
@@ -80,6 +158,7 @@ func (c *Client) handleReadError(err error) bool {
 	// Check for rate limit violations
 	if errors.Is(err, websocket.ErrReadLimit) {
 		log.Printf("Message from %s exceeded maximum size of %d bytes", c.addr, c.maxMessageSize)
+		c.audit(auditMessageTooLarge, "limit "+strconv.FormatInt(c.maxMessageSize, 10)+" bytes")
 		return true
 	}
 
@@ -113,37 +192,49 @@ func (c *Client) handleReadError(err error) bool {
 }
 
 // checkRateLimit verifies if the client has exceeded rate limits
-// and returns true if the message should be processed
-func (c *Client) checkRateLimit() bool {
-	if c.rateLimiter != nil && !c.rateLimiter.allow() {
+// and returns true if the message should be processed. Typing
+// notifications are counted against their own limiter. started reports
+// whether a refused message starts a throttling episode, which lasts until
+// a message is allowed again.
+func (c *Client) checkRateLimit(rawMessage []byte) (allowed, started bool) {
+	if isTypingFrame(rawMessage) {
+		if c.typingLimiter != nil && !c.typingLimiter.allow() {
+			log.Printf("Typing rate limit exceeded for %s; discarding typing notification", c.addr)
+			return false, false
+		}
+		return true, false
+	}
+	if c.rateLimiter == nil {
+		return true, false
+	}
+	allowed, started = c.rateLimiter.throttle()
+	if !allowed {
 		log.Printf("Rate limit exceeded for %s (%d messages per %s); discarding message", c.addr, c.rateLimit.Burst, c.rateLimit.RefillInterval)
-		return false
+		// The audit log gets one record per throttling episode rather than
+		// one per dropped message, which a flood would turn into disk I/O.
+		if started {
+			c.audit(auditRateLimited, strconv.Itoa(c.rateLimit.Burst)+" messages per "+c.rateLimit.RefillInterval.String())
+		}
 	}
-	return true
+	return allowed, started
 }
 
-// processMessage unmarshals, normalizes, and broadcasts a raw message
-// and returns true if the message was processed successfully
-func (c *Client) processMessage(rawMessage []byte) bool {
+// normalizeMessage decodes a client-supplied message and keeps only the
+// fields clients may set, with the sender set to the given server-assigned
+// identity.
+func normalizeMessage(rawMessage []byte, sender string) (*Message, error) {
 	var msg Message
 	if err := json.Unmarshal(rawMessage, &msg); err != nil {
-		log.Printf("Invalid message from %s: %v", c.addr, err)
-		return false
+		return nil, err
 	}
-
-	normalizedMessage, err := json.Marshal(msg)
-	if err != nil {
-		log.Printf("Error normalizing message from %s: %v", c.addr, err)
-		return false
-	}
-
-	log.Printf("Received message from %s: %s", c.addr, string(normalizedMessage))
-	c.hub.broadcast <- BroadcastMessage{Sender: c, Payload: normalizedMessage}
-	return true
+	return &Message{Content: msg.Content, Sender: sender, InReplyTo: msg.InReplyTo}, nil
 }
 
 // cleanupReadPump handles cleanup tasks when readPump exits
 func (c *Client) cleanupReadPump() {
+	if c.readDone != nil {
+		close(c.readDone)
+	}
 	c.hub.unregister <- c
 	if c.conn != nil {
 		if err := c.conn.Close(); err != nil {
@@ -161,11 +252,20 @@ func (c *Client) handleReadMessage() bool {
 		return c.handleReadError(err)
 	}
 
-	if !c.checkRateLimit() {
+	if allowed, started := c.checkRateLimit(rawMessage); !allowed {
+		// The sender is told once per throttling episode that its messages
+		// are being dropped; an error per dropped frame would fill the send
+		// buffer of a flooding client. Typing notifications are dropped
+		// silently.
+		if started {
+			c.reportError(errRateLimited)
+		}
 		return false
 	}
 
-	c.processMessage(rawMessage)
+	if err := c.processMessage(rawMessage); err != nil {
+		c.reportError(err)
+	}
 	return false
 }
 
@@ -183,10 +283,13 @@ func (c *Client) readPump() {
 }
 
 func (c *Client) writePump() {
-	ticker := time.NewTicker(54 * time.Second)
+	ticker := time.NewTicker(c.keepalive.PingPeriod)
 	defer func() {
 		ticker.Stop()
 		c.closeConnection()
+		if c.writeDone != nil {
+			close(c.writeDone)
+		}
 	}()
 
 	for c.processWriteEvent(ticker) {
@@ -196,11 +299,33 @@ func (c *Client) writePump() {
 // processWriteEvent waits for the next write event and returns false when the
 // pump should stop processing.
 func (c *Client) processWriteEvent(ticker *time.Ticker) bool {
+	if c.readClosed() {
+		return false
+	}
 	select {
 	case message, ok := <-c.send:
+		if ok && c.readClosed() {
+			// The connection dropped while the frame was taken; keep it for
+			// a resumed session instead of writing it to a closed socket.
+			c.unsent = message
+			return false
+		}
 		return c.handleMessage(message, ok)
 	case <-ticker.C:
 		return c.handlePing()
+	case <-c.readDone:
+		// The connection is gone; leave queued messages for a resumed session.
+		return false
+	}
+}
+
+// readClosed reports whether readPump of a resumable client has stopped.
+func (c *Client) readClosed() bool {
+	select {
+	case <-c.readDone:
+		return true
+	default:
+		return false
 	}
 }
 
@@ -217,12 +342,37 @@ func (c *Client) closeConnection() {
 	}
 }
 
+// closeWithReason sends a close frame carrying code and reason, then closes
+// the connection; readPump notices and unregisters the client. It is safe to
+// call concurrently with writePump.
+func (c *Client) closeWithReason(code int, reason string) {
+	if c.conn == nil {
+		return
+	}
+	// Control frame payloads are limited to 125 bytes, two of which hold the
+	// code. Cut on a rune boundary so that the reason stays valid UTF-8.
+	if len(reason) > 123 {
+		cut := 123
+		for cut > 0 && !utf8.RuneStart(reason[cut]) {
+			cut--
+		}
+		reason = reason[:cut]
+	}
+	message := websocket.FormatCloseMessage(code, reason)
+	if err := c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second)); err != nil {
+		if !isExpectedCloseError(err) {
+			log.Printf("Error writing close message to %s: %v", c.addr, err)
+		}
+	}
+	c.closeConnection()
+}
+
 // handleMessage processes outgoing messages and returns false if the connection should be closed
 func (c *Client) handleMessage(message []byte, ok bool) bool {
 	if c.conn == nil {
 		return false
 	}
-	if err := c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second)); err != nil {
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.keepalive.WriteWait)); err != nil {
 		log.Printf("Error setting write deadline for %s: %v", c.addr, err)
 		return false
 	}
@@ -316,7 +466,7 @@ func (c *Client) handlePing() bool {
 	if c.conn == nil {
 		return false
 	}
-	if err := c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second)); err != nil {
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.keepalive.WriteWait)); err != nil {
 		log.Printf("Error setting write deadline for ping to %s: %v", c.addr, err)
 		return false
 	}
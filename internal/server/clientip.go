@@ -0,0 +1,175 @@
+// Package server resolves the real client address of a request. Requests
+// from trusted proxies are attributed to the client named in the Forwarded,
+// X-Forwarded-For or X-Real-IP header; all other requests to their TCP peer.
+package server
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseCIDRs parses a list of CIDR blocks or bare IP addresses. Invalid
+// entries are logged and skipped; kind names the setting in the log line.
+func parseCIDRs(entries []string, kind string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		trimmed := strings.TrimSpace(entry)
+		if trimmed == "" {
+			continue
+		}
+
+		if !strings.Contains(trimmed, "/") {
+			ip := net.ParseIP(trimmed)
+			if ip == nil {
+				log.Printf("Ignoring invalid %s entry in configuration: %q", kind, entry)
+				continue
+			}
+			bits := 128
+			if v4 := ip.To4(); v4 != nil {
+				ip, bits = v4, 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(trimmed)
+		if err != nil {
+			log.Printf("Ignoring invalid %s entry in configuration: %q", kind, entry)
+			continue
+		}
+		nets = append(nets, network)
+	}
+	return nets
+}
+
+// cidrStrings formats networks in canonical CIDR notation.
+func cidrStrings(nets []*net.IPNet) []string {
+	result := make([]string, 0, len(nets))
+	for _, network := range nets {
+		result = append(result, network.String())
+	}
+	return result
+}
+
+// ipInNets reports whether ip belongs to any of nets.
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+	for _, network := range nets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return ipInNets(ip, trustedProxies)
+}
+
+// clientIP returns the IP address of the client that made the request. When
+// the TCP peer is a trusted proxy, the address is taken from the forwarding
+// headers in order of preference: Forwarded, X-Forwarded-For, X-Real-IP.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	peer := net.ParseIP(host)
+	if peer == nil || !isTrustedProxy(peer) {
+		return host
+	}
+
+	if ip, ok := resolveForwardedChain(forwardedFor(r.Header.Values("Forwarded"))); ok {
+		return ip
+	}
+	if ip, ok := resolveForwardedChain(splitHeaderList(r.Header.Values("X-Forwarded-For"))); ok {
+		return ip
+	}
+	if ip := parseForwardedIP(r.Header.Get("X-Real-IP")); ip != nil {
+		return ip.String()
+	}
+	return host
+}
+
+// clientAddr returns the address used to identify a client in logs: the TCP
+// peer address, or the forwarded client IP for requests from trusted proxies.
+// Many clients can share a forwarded IP, so it is only used in logs and for
+// access control, never as a client identity.
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	if ip := clientIP(r); ip != host {
+		return ip
+	}
+	return r.RemoteAddr
+}
+
+// resolveForwardedChain picks the client from a list of hops, oldest first.
+// Walking from the nearest hop, trusted proxies are skipped and the first
+// untrusted address is the client, since anything to its left may have been
+// supplied by the client itself. An unparseable hop makes the chain unusable.
+func resolveForwardedChain(hops []string) (string, bool) {
+	if len(hops) == 0 {
+		return "", false
+	}
+
+	var ip net.IP
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip = parseForwardedIP(hops[i])
+		if ip == nil {
+			return "", false
+		}
+		if !isTrustedProxy(ip) {
+			return ip.String(), true
+		}
+	}
+	return ip.String(), true
+}
+
+// forwardedFor extracts the for= parameter of each element of RFC 7239
+// Forwarded headers, in order.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, element := range splitHeaderList(values) {
+		for _, pair := range strings.Split(element, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(key, "for") {
+				hops = append(hops, value)
+			}
+		}
+	}
+	return hops
+}
+
+// splitHeaderList splits comma-separated header values into trimmed items.
+func splitHeaderList(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// parseForwardedIP parses a hop as written by proxies: a bare address, an
+// address with a port, or a quoted and bracketed IPv6 address as used by
+// Forwarded. It returns nil for "unknown" and obfuscated identifiers.
+func parseForwardedIP(value string) net.IP {
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+	if ip := net.ParseIP(value); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		return net.ParseIP(host)
+	}
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(value, "["), "]"))
+}
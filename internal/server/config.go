@@ -3,7 +3,12 @@
 package server
 
 import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -16,12 +21,73 @@ type RateLimitConfig struct {
 	RefillInterval time.Duration
 }
 
+// APIKey identifies an integration allowed to post messages through the
+// HTTP ingestion API. Name is shown as the message sender.
+type APIKey struct {
+	Name string
+	Key  string
+}
+
 // Config holds the server configuration settings including security controls.
 type Config struct {
-	Port           string
-	AllowedOrigins []string
-	MaxMessageSize int64
-	RateLimit      RateLimitConfig
+	Port             string
+	AllowedOrigins   []string
+	MaxMessageSize   int64
+	RateLimit        RateLimitConfig
+	HistorySize      int
+	Webhooks         WebhookConfig
+	APIKeys          []APIKey
+	ConnectionLimits ConnectionLimitConfig
+	// TrustedProxies lists the CIDR blocks (or single addresses) of reverse
+	// proxies whose forwarding headers are believed.
+	TrustedProxies []string
+	// IPAllowList, if not empty, admits only clients in these CIDR blocks.
+	// IPDenyList refuses clients in its blocks and takes precedence.
+	IPAllowList []string
+	IPDenyList  []string
+	// BanListPath is the file where runtime bans are persisted.
+	BanListPath string
+	// AdminToken authorizes requests to the /admin API. The API is
+	// disabled when it is empty.
+	AdminToken string
+	// Users are the accounts clients authenticate as. Clients without a
+	// token join as guests.
+	Users []UserAccount
+	// AuditLogPath is the file moderation actions and security events are
+	// appended to. When empty, they are written to the server log.
+	AuditLogPath string
+	// MaxReactions caps the distinct emoji reactions on one message.
+	MaxReactions int
+	// Typing throttles and expires typing indicators.
+	Typing TypingConfig
+	// ReceiptLimit is the most connected clients of authenticated users a
+	// room may have for read receipts to be broadcast in it. Guests are not
+	// counted.
+	ReceiptLimit int
+	// ResumeGrace is how long the session of a resumable WebSocket client
+	// is kept after its connection drops.
+	ResumeGrace time.Duration
+	// Inbox bounds the offline inboxes of direct messages.
+	Inbox InboxConfig
+	// Retention limits how long messages are kept.
+	Retention RetentionConfig
+	// AuditMaxBytes is the size at which the audit log file is rotated,
+	// keeping AuditBackups rotated files.
+	AuditMaxBytes int64
+	AuditBackups  int
+	// Keepalive controls pings, pong waits and write deadlines of client
+	// connections.
+	Keepalive KeepaliveConfig
+	// ReadTimeout, WriteTimeout and IdleTimeout are applied to the HTTP
+	// server created by CreateServer.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// DrainTimeout is how long clients get to leave before shutdown closes
+	// their connections. ReconnectDelay is the shortest delay clients are
+	// told to wait before reconnecting; it must be shorter than DrainTimeout.
+	DrainTimeout   time.Duration
+	ReconnectDelay time.Duration
 }
 
 var (
@@ -29,6 +95,9 @@ var (
 	activeConfig    Config
 	allowedOrigins  map[string]struct{}
 	allowAllOrigins bool
+	trustedProxies  []*net.IPNet
+	allowedNets     []*net.IPNet
+	deniedNets      []*net.IPNet
 )
 
 func init() {
@@ -46,6 +115,33 @@ func defaultConfig() Config {
 			Burst:          5,
 			RefillInterval: time.Second,
 		},
+		HistorySize:      100,
+		Webhooks:         defaultWebhookConfig(),
+		ConnectionLimits: defaultConnectionLimitConfig(),
+		MaxReactions:     20,
+		Typing:           defaultTypingConfig(),
+		ReceiptLimit:     10,
+		ResumeGrace:      30 * time.Second,
+		Inbox:            defaultInboxConfig(),
+		Retention:        defaultRetentionConfig(),
+		AuditMaxBytes:    10 << 20,
+		AuditBackups:     5,
+		Keepalive:        defaultKeepaliveConfig(),
+		ReadTimeout:      15 * time.Second,
+		WriteTimeout:     15 * time.Second,
+		IdleTimeout:      60 * time.Second,
+		DrainTimeout:     15 * time.Second,
+		ReconnectDelay:   5 * time.Second,
+	}
+}
+
+func defaultWebhookConfig() WebhookConfig {
+	return WebhookConfig{
+		Workers:        4,
+		QueueSize:      1024,
+		MaxAttempts:    5,
+		InitialBackoff: time.Second,
+		Timeout:        5 * time.Second,
 	}
 }
 
@@ -66,14 +162,82 @@ func sanitizeConfig(cfg Config) Config {
 		cfg.RateLimit.RefillInterval = time.Second
 	}
 
+	if cfg.HistorySize <= 0 {
+		cfg.HistorySize = 100
+	}
+
+	if cfg.MaxReactions <= 0 {
+		cfg.MaxReactions = 20
+	}
+
+	if cfg.ReceiptLimit <= 0 {
+		cfg.ReceiptLimit = 10
+	}
+
+	if cfg.ResumeGrace <= 0 {
+		cfg.ResumeGrace = 30 * time.Second
+	}
+
+	if cfg.AuditMaxBytes <= 0 {
+		cfg.AuditMaxBytes = 10 << 20
+	}
+
+	if cfg.AuditBackups <= 0 {
+		cfg.AuditBackups = 5
+	}
+
+	if cfg.ReadTimeout <= 0 {
+		cfg.ReadTimeout = 15 * time.Second
+	}
+
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = 15 * time.Second
+	}
+
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = 60 * time.Second
+	}
+
+	if cfg.DrainTimeout <= 0 {
+		cfg.DrainTimeout = 15 * time.Second
+	}
+
+	if cfg.ReconnectDelay <= 0 {
+		cfg.ReconnectDelay = min(5*time.Second, cfg.DrainTimeout/2)
+	}
+	cfg.ReconnectDelay = validReconnectDelay(cfg.ReconnectDelay, cfg.DrainTimeout)
+
+	cfg.Webhooks = sanitizeWebhookConfig(cfg.Webhooks)
+	cfg.ConnectionLimits = sanitizeConnectionLimitConfig(cfg.ConnectionLimits)
+	cfg.Users = sanitizeUsers(cfg.Users)
+	cfg.APIKeys = sanitizeAPIKeys(cfg.APIKeys, cfg.Users)
+	cfg.Typing = sanitizeTypingConfig(cfg.Typing)
+	cfg.Inbox = sanitizeInboxConfig(cfg.Inbox)
+	cfg.Retention = sanitizeRetentionConfig(cfg.Retention)
+	cfg.Keepalive = sanitizeKeepaliveConfig(cfg.Keepalive)
+
 	normalizedOrigins, allowAll := normalizeOrigins(cfg.AllowedOrigins)
 	cfg.AllowedOrigins = normalizedOrigins
 
+	proxies := parseCIDRs(cfg.TrustedProxies, "trusted proxy")
+	cfg.TrustedProxies = cidrStrings(proxies)
+	allowed := parseCIDRs(cfg.IPAllowList, "IP allow list")
+	cfg.IPAllowList = cidrStrings(allowed)
+	denied := parseCIDRs(cfg.IPDenyList, "IP deny list")
+	cfg.IPDenyList = cidrStrings(denied)
+
 	configMu.Lock()
 	defer configMu.Unlock()
 
 	activeConfig = cfg
 	allowAllOrigins = allowAll
+	trustedProxies = proxies
+	allowedNets = allowed
+	deniedNets = denied
+	admission.resetLimiters()
+	resetRefusalAudits()
+	bans.setPath(cfg.BanListPath)
+	notifyRetentionChanged()
 	allowedOrigins = make(map[string]struct{}, len(normalizedOrigins))
 	for _, origin := range normalizedOrigins {
 		allowedOrigins[origin] = struct{}{}
@@ -98,6 +262,31 @@ func SetConfig(cfg *Config) {
 			Burst:          cfg.RateLimit.Burst,
 			RefillInterval: cfg.RateLimit.RefillInterval,
 		},
+		HistorySize:      cfg.HistorySize,
+		Webhooks:         copyWebhookConfig(cfg.Webhooks),
+		APIKeys:          append([]APIKey(nil), cfg.APIKeys...),
+		ConnectionLimits: cfg.ConnectionLimits,
+		TrustedProxies:   append([]string(nil), cfg.TrustedProxies...),
+		IPAllowList:      append([]string(nil), cfg.IPAllowList...),
+		IPDenyList:       append([]string(nil), cfg.IPDenyList...),
+		BanListPath:      cfg.BanListPath,
+		AdminToken:       cfg.AdminToken,
+		Users:            append([]UserAccount(nil), cfg.Users...),
+		AuditLogPath:     cfg.AuditLogPath,
+		MaxReactions:     cfg.MaxReactions,
+		Typing:           cfg.Typing,
+		ReceiptLimit:     cfg.ReceiptLimit,
+		ResumeGrace:      cfg.ResumeGrace,
+		Inbox:            cfg.Inbox,
+		Retention:        copyRetentionConfig(cfg.Retention),
+		AuditMaxBytes:    cfg.AuditMaxBytes,
+		AuditBackups:     cfg.AuditBackups,
+		Keepalive:        copyKeepaliveConfig(cfg.Keepalive),
+		ReadTimeout:      cfg.ReadTimeout,
+		WriteTimeout:     cfg.WriteTimeout,
+		IdleTimeout:      cfg.IdleTimeout,
+		DrainTimeout:     cfg.DrainTimeout,
+		ReconnectDelay:   cfg.ReconnectDelay,
 	}
 	sanitizeConfig(sanitized)
 }
@@ -108,6 +297,13 @@ func currentConfig() Config {
 
 	cfg := activeConfig
 	cfg.AllowedOrigins = append([]string(nil), cfg.AllowedOrigins...)
+	cfg.Webhooks = copyWebhookConfig(cfg.Webhooks)
+	cfg.APIKeys = append([]APIKey(nil), cfg.APIKeys...)
+	cfg.TrustedProxies = append([]string(nil), cfg.TrustedProxies...)
+	cfg.IPAllowList = append([]string(nil), cfg.IPAllowList...)
+	cfg.IPDenyList = append([]string(nil), cfg.IPDenyList...)
+	cfg.Users = append([]UserAccount(nil), cfg.Users...)
+	cfg.Retention = copyRetentionConfig(cfg.Retention)
 	return cfg
 }
 
@@ -144,7 +340,97 @@ func NewConfigFromEnv() *Config {
 
 	// Load RATE_LIMIT_REFILL_INTERVAL
 	if interval := os.Getenv("RATE_LIMIT_REFILL_INTERVAL"); interval != "" {
-		cfg.RateLimit.RefillInterval = parseRefillInterval(interval, cfg.RateLimit.RefillInterval)
+		cfg.RateLimit.RefillInterval = parseDurationSeconds("RATE_LIMIT_REFILL_INTERVAL", interval, cfg.RateLimit.RefillInterval)
+	}
+
+	// Load HISTORY_SIZE
+	if size := os.Getenv("HISTORY_SIZE"); size != "" {
+		cfg.HistorySize = parseIntValue(size, cfg.HistorySize)
+	}
+
+	// Load MAX_REACTIONS_PER_MESSAGE
+	if limit := os.Getenv("MAX_REACTIONS_PER_MESSAGE"); limit != "" {
+		cfg.MaxReactions = parseIntValue(limit, cfg.MaxReactions)
+	}
+
+	loadWebhookEnv(&cfg.Webhooks)
+
+	loadConnectionLimitEnv(&cfg.ConnectionLimits)
+
+	loadTypingEnv(&cfg.Typing)
+
+	loadRetentionEnv(&cfg.Retention)
+
+	loadKeepaliveEnv(&cfg)
+
+	// Load READ_RECEIPT_LIMIT
+	if limit := os.Getenv("READ_RECEIPT_LIMIT"); limit != "" {
+		cfg.ReceiptLimit = parseIntValue(limit, cfg.ReceiptLimit)
+	}
+
+	// Load RESUME_GRACE
+	if grace := os.Getenv("RESUME_GRACE"); grace != "" {
+		cfg.ResumeGrace = parseDurationSeconds("RESUME_GRACE", grace, cfg.ResumeGrace)
+	}
+
+	// Load DRAIN_TIMEOUT and RECONNECT_DELAY
+	if timeout := os.Getenv("DRAIN_TIMEOUT"); timeout != "" {
+		cfg.DrainTimeout = parseDurationSeconds("DRAIN_TIMEOUT", timeout, cfg.DrainTimeout)
+	}
+	if delay := os.Getenv("RECONNECT_DELAY"); delay != "" {
+		cfg.ReconnectDelay = parseDurationSeconds("RECONNECT_DELAY", delay, cfg.ReconnectDelay)
+	}
+
+	// Load INBOX_MAX_SIZE and INBOX_MAX_AGE
+	if size := os.Getenv("INBOX_MAX_SIZE"); size != "" {
+		cfg.Inbox.MaxSize = parseIntValue(size, cfg.Inbox.MaxSize)
+	}
+	if age := os.Getenv("INBOX_MAX_AGE"); age != "" {
+		cfg.Inbox.MaxAge = parseDurationSeconds("INBOX_MAX_AGE", age, cfg.Inbox.MaxAge)
+	}
+
+	// Load TRUSTED_PROXIES
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		cfg.TrustedProxies = strings.Split(proxies, ",")
+	}
+
+	// Load IP_ALLOW_LIST and IP_DENY_LIST
+	if list := os.Getenv("IP_ALLOW_LIST"); list != "" {
+		cfg.IPAllowList = strings.Split(list, ",")
+	}
+	if list := os.Getenv("IP_DENY_LIST"); list != "" {
+		cfg.IPDenyList = strings.Split(list, ",")
+	}
+
+	// Load BAN_LIST_PATH
+	if path := os.Getenv("BAN_LIST_PATH"); path != "" {
+		cfg.BanListPath = path
+	}
+
+	// Load ADMIN_TOKEN
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		cfg.AdminToken = token
+	}
+
+	// Load API_KEYS
+	if keys := os.Getenv("API_KEYS"); keys != "" {
+		cfg.APIKeys = parseAPIKeys(keys)
+	}
+
+	// Load AUTH_USERS
+	if users := os.Getenv("AUTH_USERS"); users != "" {
+		cfg.Users = parseUsers(users)
+	}
+
+	// Load AUDIT_LOG_PATH, AUDIT_LOG_MAX_SIZE and AUDIT_LOG_BACKUPS
+	if path := os.Getenv("AUDIT_LOG_PATH"); path != "" {
+		cfg.AuditLogPath = path
+	}
+	if size := os.Getenv("AUDIT_LOG_MAX_SIZE"); size != "" {
+		cfg.AuditMaxBytes = parseMaxMessageSize(size, cfg.AuditMaxBytes)
+	}
+	if backups := os.Getenv("AUDIT_LOG_BACKUPS"); backups != "" {
+		cfg.AuditBackups = parseIntValue(backups, cfg.AuditBackups)
 	}
 
 	return &cfg
@@ -172,9 +458,224 @@ func parseIntValue(value string, defaultValue int) int {
 	return defaultValue
 }
 
-func parseRefillInterval(value string, defaultValue time.Duration) time.Duration {
+// parseDurationSeconds parses a positive duration setting written in Go
+// syntax, such as "90s" or "5m", or as a whole number of seconds. An invalid
+// value is logged, naming the setting, and leaves defaultValue in place.
+func parseDurationSeconds(name, value string, defaultValue time.Duration) time.Duration {
 	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
 		return time.Duration(seconds) * time.Second
 	}
+	if duration, err := time.ParseDuration(value); err == nil && duration > 0 {
+		return duration
+	}
+	log.Printf("Ignoring invalid %s %q: use a duration such as 30s or a number of seconds", name, value)
 	return defaultValue
 }
+
+func sanitizeWebhookConfig(cfg WebhookConfig) WebhookConfig {
+	defaults := defaultWebhookConfig()
+	if cfg.Workers <= 0 {
+		cfg.Workers = defaults.Workers
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaults.QueueSize
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaults.MaxAttempts
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = defaults.InitialBackoff
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaults.Timeout
+	}
+
+	valid := cfg.Subscriptions[:0]
+	for _, subscription := range cfg.Subscriptions {
+		parsed, err := url.Parse(subscription.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			log.Printf("Ignoring webhook subscription with invalid URL: %q", subscription.URL)
+			continue
+		}
+		if subscription.Secret == "" {
+			log.Printf("Webhook subscription for %s has no secret; deliveries will be signed with an empty key", parsed.Host)
+		}
+		valid = append(valid, subscription)
+	}
+	cfg.Subscriptions = valid
+	return cfg
+}
+
+func copyWebhookConfig(cfg WebhookConfig) WebhookConfig {
+	subscriptions := make([]WebhookSubscription, 0, len(cfg.Subscriptions))
+	for _, subscription := range cfg.Subscriptions {
+		subscription.Events = append([]string(nil), subscription.Events...)
+		subscriptions = append(subscriptions, subscription)
+	}
+	cfg.Subscriptions = subscriptions
+	return cfg
+}
+
+// loadWebhookEnv reads webhook settings. WEBHOOKS holds a JSON array of
+// subscriptions, for example:
+//
+//	[{"url":"https://hooks.example.com/chat","secret":"s3cret","events":["message","join"]}]
+func loadWebhookEnv(cfg *WebhookConfig) {
+	if raw := os.Getenv("WEBHOOKS"); raw != "" {
+		var subscriptions []WebhookSubscription
+		if err := json.Unmarshal([]byte(raw), &subscriptions); err != nil {
+			log.Printf("Ignoring invalid WEBHOOKS value: %v", err)
+		} else {
+			cfg.Subscriptions = subscriptions
+		}
+	}
+
+	if workers := os.Getenv("WEBHOOK_WORKERS"); workers != "" {
+		cfg.Workers = parseIntValue(workers, cfg.Workers)
+	}
+
+	if size := os.Getenv("WEBHOOK_QUEUE_SIZE"); size != "" {
+		cfg.QueueSize = parseIntValue(size, cfg.QueueSize)
+	}
+
+	if attempts := os.Getenv("WEBHOOK_MAX_ATTEMPTS"); attempts != "" {
+		cfg.MaxAttempts = parseIntValue(attempts, cfg.MaxAttempts)
+	}
+
+	if timeout := os.Getenv("WEBHOOK_TIMEOUT"); timeout != "" {
+		cfg.Timeout = parseDurationSeconds("WEBHOOK_TIMEOUT", timeout, cfg.Timeout)
+	}
+
+	if path := os.Getenv("WEBHOOK_DEAD_LETTER_PATH"); path != "" {
+		cfg.DeadLetterPath = path
+	}
+}
+
+// parseAPIKeys parses a comma-separated list of name:key pairs.
+func parseAPIKeys(value string) []APIKey {
+	var keys []APIKey
+	for _, part := range strings.Split(value, ",") {
+		name, key, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			log.Printf("Ignoring API key entry without a name: expected name:key")
+			continue
+		}
+		keys = append(keys, APIKey{Name: strings.TrimSpace(name), Key: strings.TrimSpace(key)})
+	}
+	return keys
+}
+
+// sanitizeAPIKeys drops keys without a name or key, and keys named after a
+// configured user or with the guest label prefix.
+func sanitizeAPIKeys(keys []APIKey, users []UserAccount) []APIKey {
+	valid := make([]APIKey, 0, len(keys))
+	for _, key := range keys {
+		if key.Name == "" || key.Key == "" {
+			log.Printf("Ignoring API key with an empty name or key")
+			continue
+		}
+		// Messages are attributed to the integration's name, so a name
+		// shared with a user or guest would let the integration pass author
+		// checks as them.
+		if strings.HasPrefix(key.Name, guestNamePrefix) || slices.ContainsFunc(users, func(user UserAccount) bool {
+			return user.Name == key.Name
+		}) {
+			log.Printf("Ignoring API key %q: the name belongs to a user or guest", key.Name)
+			continue
+		}
+		valid = append(valid, key)
+	}
+	return valid
+}
+
+// loadConnectionLimitEnv reads admission control settings.
+func loadConnectionLimitEnv(cfg *ConnectionLimitConfig) {
+	if limit := os.Getenv("MAX_CONNECTIONS"); limit != "" {
+		cfg.MaxConnections = parseIntValue(limit, cfg.MaxConnections)
+	}
+
+	if limit := os.Getenv("MAX_CONNECTIONS_PER_IP"); limit != "" {
+		cfg.MaxConnectionsPerIP = parseIntValue(limit, cfg.MaxConnectionsPerIP)
+	}
+
+	if prefix := os.Getenv("CONNECTION_LIMIT_IPV4_PREFIX"); prefix != "" {
+		cfg.IPv4Prefix = parseIntValue(prefix, cfg.IPv4Prefix)
+	}
+
+	if prefix := os.Getenv("CONNECTION_LIMIT_IPV6_PREFIX"); prefix != "" {
+		cfg.IPv6Prefix = parseIntValue(prefix, cfg.IPv6Prefix)
+	}
+
+	if burst := os.Getenv("UPGRADE_RATE_BURST"); burst != "" {
+		cfg.UpgradeBurst = parseIntValue(burst, cfg.UpgradeBurst)
+	}
+
+	if interval := os.Getenv("UPGRADE_RATE_INTERVAL"); interval != "" {
+		cfg.UpgradeInterval = parseDurationSeconds("UPGRADE_RATE_INTERVAL", interval, cfg.UpgradeInterval)
+	}
+}
+
+// loadTypingEnv reads typing indicator settings.
+func loadTypingEnv(cfg *TypingConfig) {
+	if timeout := os.Getenv("TYPING_TIMEOUT"); timeout != "" {
+		cfg.Timeout = parseDurationSeconds("TYPING_TIMEOUT", timeout, cfg.Timeout)
+	}
+
+	if burst := os.Getenv("TYPING_RATE_BURST"); burst != "" {
+		cfg.RateLimit.Burst = parseIntValue(burst, cfg.RateLimit.Burst)
+	}
+
+	if interval := os.Getenv("TYPING_RATE_INTERVAL"); interval != "" {
+		cfg.RateLimit.RefillInterval = parseDurationSeconds("TYPING_RATE_INTERVAL", interval, cfg.RateLimit.RefillInterval)
+	}
+}
+
+// loadRetentionEnv reads retention settings.
+func loadRetentionEnv(cfg *RetentionConfig) {
+	if age := os.Getenv("RETENTION_MAX_AGE"); age != "" {
+		cfg.MaxAge = parseDurationSeconds("RETENTION_MAX_AGE", age, cfg.MaxAge)
+	}
+
+	if count := os.Getenv("RETENTION_MAX_COUNT"); count != "" {
+		cfg.MaxCount = parseIntValue(count, cfg.MaxCount)
+	}
+
+	if rooms := os.Getenv("RETENTION_ROOMS"); rooms != "" {
+		cfg.Rooms = parseRetentionRooms(rooms)
+	}
+
+	if interval := os.Getenv("RETENTION_INTERVAL"); interval != "" {
+		cfg.Interval = parseDurationSeconds("RETENTION_INTERVAL", interval, cfg.Interval)
+	}
+}
+
+// loadKeepaliveEnv reads keepalive settings and HTTP server timeouts.
+func loadKeepaliveEnv(cfg *Config) {
+	if wait := os.Getenv("KEEPALIVE_PONG_WAIT"); wait != "" {
+		cfg.Keepalive.PongWait = parseDurationSeconds("KEEPALIVE_PONG_WAIT", wait, cfg.Keepalive.PongWait)
+	}
+
+	if period := os.Getenv("KEEPALIVE_PING_PERIOD"); period != "" {
+		cfg.Keepalive.PingPeriod = parseDurationSeconds("KEEPALIVE_PING_PERIOD", period, cfg.Keepalive.PingPeriod)
+	}
+
+	if wait := os.Getenv("KEEPALIVE_WRITE_WAIT"); wait != "" {
+		cfg.Keepalive.WriteWait = parseDurationSeconds("KEEPALIVE_WRITE_WAIT", wait, cfg.Keepalive.WriteWait)
+	}
+
+	if transports := os.Getenv("KEEPALIVE_TRANSPORTS"); transports != "" {
+		cfg.Keepalive.Transports = parseKeepaliveTransports(transports)
+	}
+
+	if timeout := os.Getenv("HTTP_READ_TIMEOUT"); timeout != "" {
+		cfg.ReadTimeout = parseDurationSeconds("HTTP_READ_TIMEOUT", timeout, cfg.ReadTimeout)
+	}
+
+	if timeout := os.Getenv("HTTP_WRITE_TIMEOUT"); timeout != "" {
+		cfg.WriteTimeout = parseDurationSeconds("HTTP_WRITE_TIMEOUT", timeout, cfg.WriteTimeout)
+	}
+
+	if timeout := os.Getenv("HTTP_IDLE_TIMEOUT"); timeout != "" {
+		cfg.IdleTimeout = parseDurationSeconds("HTTP_IDLE_TIMEOUT", timeout, cfg.IdleTimeout)
+	}
+}
@@ -0,0 +1,322 @@
+// Package server implements direct messages between users. A direct message
+// goes to every connection of its target; if the target has none, it waits
+// in the target's inbox, which is capped in size and age, and is delivered
+// in order when the target next connects. The sender is told when a direct
+// message has been delivered to a live connection. Direct messages take
+// their ids from the same sequence as room messages but are not part of the
+// room history.
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Direct message frame and event types.
+const (
+	frameDirect    = "dm"
+	eventDMSent    = "dm_sent"
+	eventDelivered = "delivered"
+)
+
+// InboxConfig bounds the offline inbox of each user. When an inbox holds
+// MaxSize messages, the oldest is dropped to make room; messages older than
+// MaxAge are dropped undelivered.
+type InboxConfig struct {
+	MaxSize int
+	MaxAge  time.Duration
+}
+
+func defaultInboxConfig() InboxConfig {
+	return InboxConfig{
+		MaxSize: 100,
+		MaxAge:  7 * 24 * time.Hour,
+	}
+}
+
+func sanitizeInboxConfig(cfg InboxConfig) InboxConfig {
+	defaults := defaultInboxConfig()
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = defaults.MaxSize
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = defaults.MaxAge
+	}
+	return cfg
+}
+
+// directMessage is a direct message as delivered to its target.
+type directMessage struct {
+	Type    string    `json:"type"`
+	ID      uint64    `json:"id"`
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Content string    `json:"content"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+// dmSentEvent tells the sender the id of its direct message and whether it
+// was queued because the target is offline.
+type dmSentEvent struct {
+	Type     string `json:"type"`
+	ID       uint64 `json:"id"`
+	To       string `json:"to"`
+	Queued   bool   `json:"queued"`
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// deliveredEvent tells the sender that a direct message reached its target.
+type deliveredEvent struct {
+	Type string `json:"type"`
+	ID   uint64 `json:"id"`
+	To   string `json:"to"`
+}
+
+// InboxSize describes one user's offline inbox.
+type InboxSize struct {
+	User   string    `json:"user"`
+	Size   int       `json:"size"`
+	Oldest time.Time `json:"oldest"`
+}
+
+// inboxStore holds the offline inboxes. Its lock is held while a message is
+// either delivered or queued, and while an inbox is drained, so that a
+// target connecting at the same time cannot miss a message or receive them
+// out of order. It also remembers the id of the latest message in each
+// conversation, which bounds the read markers of its participants. Ids come
+// from nextID, so that they never collide with room message ids.
+type inboxStore struct {
+	mu            sync.Mutex
+	nextID        func() uint64
+	inboxes       map[string][]directMessage
+	conversations map[string]uint64
+}
+
+func newInboxStore(nextID func() uint64) *inboxStore {
+	return &inboxStore{
+		nextID:        nextID,
+		inboxes:       make(map[string][]directMessage),
+		conversations: make(map[string]uint64),
+	}
+}
+
+// conversationKey identifies the conversation between two users regardless
+// of who sent the message.
+func conversationKey(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return a + "\x00" + b
+}
+
+// latestInConversation returns the id of the latest direct message between
+// a and b, or 0 if they have exchanged none.
+func (s *inboxStore) latestInConversation(a, b string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conversations[conversationKey(a, b)]
+}
+
+// send assigns the message an id and hands it to deliver; if deliver fails,
+// the message is queued in the target's inbox. It returns the message and
+// whether it was queued.
+func (s *inboxStore) send(msg directMessage, cfg InboxConfig, deliver func(directMessage) bool) (directMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg.ID = s.nextID()
+	s.conversations[conversationKey(msg.From, msg.To)] = msg.ID
+	if deliver(msg) {
+		return msg, false
+	}
+
+	inbox := append(s.pruneLocked(msg.To, cfg.MaxAge), msg)
+	if excess := len(inbox) - cfg.MaxSize; excess > 0 {
+		log.Printf("Inbox of %s is full; dropped %d oldest messages", msg.To, excess)
+		inbox = append([]directMessage(nil), inbox[excess:]...)
+	}
+	s.inboxes[msg.To] = inbox
+	return msg, true
+}
+
+// drain hands the queued messages of user to deliver in order, stopping at
+// the first that cannot be delivered, and returns the delivered messages.
+func (s *inboxStore) drain(user string, cfg InboxConfig, deliver func(directMessage) bool) []directMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inbox := s.pruneLocked(user, cfg.MaxAge)
+	delivered := 0
+	for delivered < len(inbox) && deliver(inbox[delivered]) {
+		delivered++
+	}
+	if delivered == len(inbox) {
+		delete(s.inboxes, user)
+	} else {
+		s.inboxes[user] = inbox[delivered:]
+	}
+	return inbox[:delivered]
+}
+
+// sizes lists the non-empty inboxes by user name.
+func (s *inboxStore) sizes(cfg InboxConfig) []InboxSize {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sizes := make([]InboxSize, 0, len(s.inboxes))
+	for user := range s.inboxes {
+		inbox := s.pruneLocked(user, cfg.MaxAge)
+		if len(inbox) == 0 {
+			delete(s.inboxes, user)
+			continue
+		}
+		sizes = append(sizes, InboxSize{User: user, Size: len(inbox), Oldest: inbox[0].SentAt})
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i].User < sizes[j].User })
+	return sizes
+}
+
+// pruneLocked drops the expired messages of an inbox and returns the rest.
+func (s *inboxStore) pruneLocked(user string, maxAge time.Duration) []directMessage {
+	inbox := s.inboxes[user]
+	cutoff := time.Now().Add(-maxAge)
+	expired := 0
+	for expired < len(inbox) && inbox[expired].SentAt.Before(cutoff) {
+		expired++
+	}
+	if expired > 0 {
+		log.Printf("Dropped %d expired messages from the inbox of %s", expired, user)
+		inbox = inbox[expired:]
+		s.inboxes[user] = inbox
+	}
+	return inbox
+}
+
+// sendDirect handles a dm frame from an authenticated client.
+func (h *Hub) sendDirect(c *Client, cmd clientEnvelope) error {
+	if c.user == "" {
+		return errForbidden
+	}
+	if cmd.Target == "" || userRole(cmd.Target) == RoleGuest {
+		return errUnknownUser
+	}
+	if err := h.moderation.checkMuted(c); err != nil {
+		return err
+	}
+	if cmd.Content == "" {
+		return errInvalidMessage
+	}
+
+	msg := directMessage{Type: frameDirect, From: c.user, To: cmd.Target, Content: cmd.Content, SentAt: time.Now().UTC()}
+	msg, queued := h.inboxes.send(msg, currentConfig().Inbox, h.deliverDirect)
+	if queued {
+		log.Printf("Queued direct message %d from %s for offline user %s", msg.ID, msg.From, msg.To)
+	}
+
+	payload, err := json.Marshal(dmSentEvent{Type: eventDMSent, ID: msg.ID, To: msg.To, Queued: queued, ClientID: cmd.ClientID})
+	if err != nil {
+		log.Printf("Error encoding dm_sent event for %s: %v", c.addr, err)
+	} else {
+		h.sendEvent(c, payload)
+	}
+	if !queued {
+		h.notifyDelivered(msg)
+	}
+	return nil
+}
+
+// deliverDirect queues a direct message for every connection of its target
+// and reports whether a live one accepted it. If the target has no live
+// connection, nothing is queued and the message stays in the inbox, since a
+// parked session or an idle long-poll session may never collect it; they
+// receive it from the inbox when they resume or next poll.
+func (h *Hub) deliverDirect(msg directMessage) bool {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error encoding direct message %d: %v", msg.ID, err)
+		return false
+	}
+	clients := h.userClients(msg.To)
+	live := make([]bool, len(clients))
+	anyLive := false
+	for i, client := range clients {
+		live[i] = h.isLive(client)
+		anyLive = anyLive || live[i]
+	}
+	if !anyLive {
+		return false
+	}
+	delivered := false
+	for i, client := range clients {
+		if h.safeSend(client, client.frame(historyEntry{Payload: payload})) && live[i] {
+			delivered = true
+		}
+	}
+	return delivered
+}
+
+// isLive reports whether a client is attached to a connection that reads
+// its send buffer now: a WebSocket or SSE client whose session is not
+// parked, or a long-poll session with a poll in progress.
+func (h *Hub) isLive(client *Client) bool {
+	if client.poll != nil {
+		return client.poll.inProgress()
+	}
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return !client.parked
+}
+
+// deliverInbox sends a live user client its queued direct messages and tells
+// their senders. It runs when a client registers, on the hub goroutine, and
+// when a long-poll session starts a poll.
+func (h *Hub) deliverInbox(client *Client) {
+	if client.user == "" || !h.isLive(client) {
+		return
+	}
+	delivered := h.inboxes.drain(client.user, currentConfig().Inbox, func(msg directMessage) bool {
+		payload, err := json.Marshal(msg)
+		if err != nil {
+			log.Printf("Error encoding direct message %d: %v", msg.ID, err)
+			return false
+		}
+		return h.safeSend(client, client.frame(historyEntry{Payload: payload}))
+	})
+	if len(delivered) == 0 {
+		return
+	}
+	log.Printf("Delivered %d queued direct messages to %s", len(delivered), client.user)
+	for _, msg := range delivered {
+		h.notifyDelivered(msg)
+	}
+}
+
+// notifyDelivered sends a delivered event to every connection of the sender.
+// Senders that are offline are not told.
+func (h *Hub) notifyDelivered(msg directMessage) {
+	payload, err := json.Marshal(deliveredEvent{Type: eventDelivered, ID: msg.ID, To: msg.To})
+	if err != nil {
+		log.Printf("Error encoding delivered event for message %d: %v", msg.ID, err)
+		return
+	}
+	for _, client := range h.userClients(msg.From) {
+		h.sendEvent(client, payload)
+	}
+}
+
+// userClients returns the registered clients of a user.
+func (h *Hub) userClients(name string) []*Client {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	var clients []*Client
+	for client := range h.clients {
+		if client.user == name {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
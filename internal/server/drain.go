@@ -0,0 +1,114 @@
+// Package server drains the server before it shuts down. Draining makes
+// /readyz fail and refuses new connections, so that load balancers move new
+// clients to other instances. Connected clients are told to reconnect after
+// a delay, spread across the drain timeout so that they do not all reconnect
+// at once. Clients still connected when the timeout ends are closed with
+// 1001 Going Away.
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// eventShuttingDown is the type of the event that tells clients to
+// reconnect elsewhere.
+const eventShuttingDown = "server_shutting_down"
+
+// drainPollInterval is how often Drain checks whether every client has left.
+const drainPollInterval = 100 * time.Millisecond
+
+// shutdownEvent tells a client that the server is going away and how many
+// seconds to wait before reconnecting.
+type shutdownEvent struct {
+	Type           string `json:"type"`
+	ReconnectAfter int    `json:"reconnect_after"`
+}
+
+// validReconnectDelay returns delay, or half of timeout when delay is not
+// shorter than it.
+func validReconnectDelay(delay, timeout time.Duration) time.Duration {
+	if delay < timeout {
+		return delay
+	}
+	log.Printf("Reconnect delay %s is not shorter than the drain timeout %s; using %s", delay, timeout, timeout/2)
+	return timeout / 2
+}
+
+// refuseWhileDraining answers a new connection with 503 Service Unavailable
+// while the server drains and reports whether it did.
+func refuseWhileDraining(w http.ResponseWriter) bool {
+	if !hub.draining.Load() {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(currentConfig().ReconnectDelay)))
+	http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+	return true
+}
+
+// Drain prepares the hub for shutdown. It marks the server as draining,
+// sends every client a server_shutting_down event, and waits until the
+// clients have left or the drain timeout has passed. Sessions parked for
+// resumption and long-poll sessions without a poll in progress are not
+// waited for. The clients still registered then are closed with 1001 Going
+// Away. Drain returns at once if
+// the hub is already draining.
+func (h *Hub) Drain() {
+	if !h.draining.CompareAndSwap(false, true) {
+		return
+	}
+	cfg := currentConfig()
+	clients := h.getClientSnapshot()
+	log.Printf("Draining %d clients for up to %s", len(clients), cfg.DrainTimeout)
+
+	for _, client := range clients {
+		payload, err := json.Marshal(shutdownEvent{
+			Type:           eventShuttingDown,
+			ReconnectAfter: retryAfterSeconds(reconnectDelay(cfg.ReconnectDelay, cfg.DrainTimeout)),
+		})
+		if err != nil {
+			log.Printf("Error encoding shutdown event: %v", err)
+			break
+		}
+		h.sendEvent(client, payload)
+	}
+
+	deadline := time.Now().Add(cfg.DrainTimeout)
+	for h.liveClientCount() > 0 && time.Now().Before(deadline) {
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-time.After(min(drainPollInterval, time.Until(deadline))):
+		}
+	}
+
+	closed := h.disconnectWhere(func(*Client) bool { return true }, websocket.CloseGoingAway, "server shutting down")
+	log.Printf("Drain completed; closed %d remaining clients", closed)
+}
+
+// liveClientCount returns how many registered clients are attached to a
+// live connection.
+func (h *Hub) liveClientCount() int {
+	live := 0
+	for _, client := range h.getClientSnapshot() {
+		if h.isLive(client) {
+			live++
+		}
+	}
+	return live
+}
+
+// reconnectDelay picks a delay between delay and timeout, so that clients
+// reconnect before the drain ends but not all at the same moment.
+func reconnectDelay(delay, timeout time.Duration) time.Duration {
+	if timeout <= delay {
+		return delay
+	}
+	return delay + rand.N(timeout-delay) // #nosec G404 -- jitter, not a secret
+}
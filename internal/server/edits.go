@@ -0,0 +1,127 @@
+// Package server implements message edits and deletions. Both refer to a
+// message by its server-assigned id, may only be made by the message's
+// author or a moderator, rewrite the entry in the message history, and are
+// fanned out to every client as change events. Deleted messages become
+// tombstones so that history replay no longer carries their content.
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+)
+
+// Message change event types.
+const (
+	eventEdit   = "edit"
+	eventDelete = "delete"
+)
+
+// messageChangeEvent tells clients that a message was edited or deleted.
+// Edits carry the updated message.
+type messageChangeEvent struct {
+	Type    string   `json:"type"`
+	ID      uint64   `json:"id"`
+	Actor   string   `json:"actor,omitempty"`
+	Message *Message `json:"message,omitempty"`
+}
+
+// editMessage replaces the content of a retained message. Blank content is
+// refused: removing a message's content is a delete, which leaves a
+// tombstone and drops its reactions.
+func (h *Hub) editMessage(actor *Client, id uint64, content string) error {
+	if strings.TrimSpace(content) == "" {
+		return errEmptyEdit
+	}
+	if err := h.moderation.checkMuted(actor); err != nil {
+		return err
+	}
+
+	var edited Message
+	entry, err := h.changeMessage(actor, id, func(msg *Message) {
+		now := time.Now().UTC()
+		msg.Content = content
+		msg.EditedAt = &now
+		edited = *msg
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Message %d edited by %s", id, actor.identity())
+	if entry.Author != actor.identity() {
+		recordAudit(auditRecord{Action: "edit_message", Actor: actor.identity(), IP: actor.ip, Target: entry.Author})
+	}
+	h.announceChange(messageChangeEvent{Type: eventEdit, ID: id, Actor: actor.user, Message: &edited})
+	return nil
+}
+
+// deleteMessage turns a retained message into a tombstone.
+func (h *Hub) deleteMessage(actor *Client, id uint64) error {
+	if err := h.moderation.checkMuted(actor); err != nil {
+		return err
+	}
+
+	var inReplyTo uint64
+	entry, err := h.changeMessage(actor, id, func(msg *Message) {
+		inReplyTo = msg.InReplyTo
+		*msg = Message{ID: msg.ID, InReplyTo: msg.InReplyTo, Deleted: true}
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Message %d deleted by %s", id, actor.identity())
+	if entry.Author != actor.identity() {
+		recordAudit(auditRecord{Action: "delete_message", Actor: actor.identity(), IP: actor.ip, Target: entry.Author})
+	}
+	h.announceChange(messageChangeEvent{Type: eventDelete, ID: id, Actor: actor.user})
+	if inReplyTo != 0 {
+		h.announceThreadSummary(inReplyTo)
+	}
+	return nil
+}
+
+// changeMessage applies change to a retained chat message after checking
+// that actor may modify it: authors may change their own messages, and
+// moderators those of users with a lower role. A guest's messages belong to
+// its random per-connection identity, so only that connection, or a session
+// resumed from it, can change them; guests sharing an address cannot change
+// each other's messages. Tombstones cannot be changed, and lose their
+// reactions.
+func (h *Hub) changeMessage(actor *Client, id uint64, change func(*Message)) (historyEntry, error) {
+	entry, found, err := h.history.update(id, func(entry *historyEntry) error {
+		msg, ok := decodeEntry(*entry)
+		if !ok || msg.Deleted {
+			return errMessageNotFound
+		}
+		if entry.Author != actor.identity() &&
+			(!actor.role.canModerate() || actor.role.rank() <= userRole(entry.Author).rank()) {
+			return errForbidden
+		}
+		change(&msg)
+		if msg.Deleted {
+			entry.Reactions = nil
+		}
+		payload, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		entry.Payload = payload
+		return nil
+	})
+	if !found {
+		return historyEntry{}, errMessageNotFound
+	}
+	return entry, err
+}
+
+func (h *Hub) announceChange(event messageChangeEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding %s event for message %d: %v", event.Type, event.ID, err)
+		return
+	}
+	h.broadcastEvent(payload)
+}
@@ -19,19 +19,59 @@ var upgrader = websocket.Upgrader{
 // WebSocketHandler handles WebSocket upgrade requests and manages client connections.
 // It validates that the request uses the GET method, upgrades the HTTP connection
 // to WebSocket, creates a new Client instance, and starts the client's read/write pumps.
+// Requests that are not upgrades, come from a disallowed origin or banned
+// address, exceed the connection limits or present an invalid token are
+// refused before the upgrade. The origin is checked first so that rejected
+// requests do not use up the connection limits.
 func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed. WebSocket endpoint only accepts GET requests.", http.StatusMethodNotAllowed)
 		return
 	}
 
+	if !websocket.IsWebSocketUpgrade(r) {
+		http.Error(w, "Bad Request. WebSocket endpoint expects a WebSocket upgrade.", http.StatusBadRequest)
+		return
+	}
+
+	if !checkOrigin(r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	admissionKey, ok := admit(w, r)
+	if !ok {
+		return
+	}
+
+	user, role, ok := authenticateClient(w, r)
+	if !ok {
+		admission.release(admissionKey)
+		return
+	}
+	if !checkReliable(w, r, user) {
+		admission.release(admissionKey)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		admission.release(admissionKey)
 		log.Printf("WebSocket upgrade failed: %v", err)
 		return
 	}
 
-	client := NewClient(conn, hub, r.RemoteAddr)
+	client := NewClient(conn, hub, clientAddr(r))
+	client.ip = clientIP(r)
+	client.admissionKey = admissionKey
+	client.user = user
+	client.role = role
+	client.reliable = reliableRequested(r)
+	if resumable, token := resumeRequested(r); resumable {
+		client.readDone = make(chan struct{})
+		client.writeDone = make(chan struct{})
+		client.resumeFrom = token
+	}
 
 	// Register the client with the hub; the hub will launch the pump goroutines.
 	client.hub.register <- client
@@ -83,6 +123,12 @@ func TestPageHandler(w http.ResponseWriter, _ *http.Request) {
         }
         .connected { background-color: #d4edda; color: #155724; }
         .disconnected { background-color: #f8d7da; color: #721c24; }
+        .reply { margin-left: 30px !important; border-left: 3px solid #ccc; padding-left: 8px !important; }
+        .quote { color: #666; font-size: 0.9em; }
+        .thread-count { color: #007cba; font-size: 0.9em; margin-left: 8px; }
+        .reply-link { color: #007cba; cursor: pointer; font-size: 0.9em; margin-left: 8px; }
+        #replyBar { display: none; margin: 5px 0; color: #555; }
+        #typing, #receipts { color: #666; font-size: 0.9em; min-height: 1.2em; }
     </style>
 </head>
 <body>
@@ -96,7 +142,11 @@ func TestPageHandler(w http.ResponseWriter, _ *http.Request) {
         <button id="connectButton" onclick="toggleConnection()">Connect</button>
     </div>
     
+    <div id="replyBar">Replying to <span id="replyTarget"></span> <span class="reply-link" onclick="cancelReply()">cancel</span></div>
+
     <div id="messages"></div>
+    <div id="typing"></div>
+    <div id="receipts"></div>
 
     <script>
         let ws = null;
@@ -105,6 +155,179 @@ func TestPageHandler(w http.ResponseWriter, _ *http.Request) {
         const sendButton = document.getElementById('sendButton');
         const connectButton = document.getElementById('connectButton');
         const statusDiv = document.getElementById('status');
+        const replyBar = document.getElementById('replyBar');
+        const replyTarget = document.getElementById('replyTarget');
+        const typingDiv = document.getElementById('typing');
+        const chatMessages = {};
+        const typers = {};
+        const receipts = {};
+        const receiptsDiv = document.getElementById('receipts');
+        let authenticated = false;
+        let replyTo = 0;
+        let typingSentAt = 0;
+
+        function describe(msg) {
+            if (msg.deleted) {
+                return '(deleted)';
+            }
+            return (msg.sender || 'guest') + ': ' + msg.content + (msg.edited_at ? ' (edited)' : '');
+        }
+
+        function renderChat(msg) {
+            let element = document.getElementById('msg-' + msg.id);
+            if (!element) {
+                element = document.createElement('div');
+                element.id = 'msg-' + msg.id;
+                element.style.margin = '5px 0';
+                element.style.padding = '3px';
+                element.style.color = 'green';
+                const root = msg.in_reply_to && document.getElementById('msg-' + msg.in_reply_to);
+                if (root) {
+                    element.className = 'reply';
+                    let last = root;
+                    while (last.nextSibling && last.nextSibling.dataset && last.nextSibling.dataset.root === String(msg.in_reply_to)) {
+                        last = last.nextSibling;
+                    }
+                    element.dataset.root = String(msg.in_reply_to);
+                    messagesDiv.insertBefore(element, last.nextSibling);
+                } else {
+                    messagesDiv.appendChild(element);
+                }
+            }
+            chatMessages[msg.id] = msg;
+            element.textContent = '';
+
+            if (msg.in_reply_to) {
+                const quote = document.createElement('div');
+                quote.className = 'quote';
+                const parent = chatMessages[msg.in_reply_to];
+                quote.textContent = '\u21aa #' + msg.in_reply_to + (parent ? ' ' + describe(parent) : '');
+                element.appendChild(quote);
+            }
+
+            const text = document.createElement('span');
+            text.textContent = '#' + msg.id + ' ' + describe(msg);
+            element.appendChild(text);
+
+            const count = document.createElement('span');
+            count.className = 'thread-count';
+            count.id = 'count-' + msg.id;
+            element.appendChild(count);
+
+            if (!msg.deleted) {
+                (msg.reactions || []).forEach(function(reaction) {
+                    const badge = document.createElement('span');
+                    badge.className = 'reply-link';
+                    badge.textContent = reaction.emoji + ' ' + reaction.count;
+                    badge.onclick = function() { react(msg.id, reaction.emoji); };
+                    element.appendChild(badge);
+                });
+
+                const like = document.createElement('span');
+                like.className = 'reply-link';
+                like.textContent = '+\u{1F44D}';
+                like.onclick = function() { react(msg.id, '\u{1F44D}'); };
+                element.appendChild(like);
+
+                const link = document.createElement('span');
+                link.className = 'reply-link';
+                link.textContent = 'Reply';
+                link.onclick = function() { startReply(msg.in_reply_to || msg.id); };
+                element.appendChild(link);
+            }
+            messagesDiv.scrollTop = messagesDiv.scrollHeight;
+        }
+
+        function handleFrame(data) {
+            let frame;
+            try {
+                frame = JSON.parse(data);
+            } catch (e) {
+                addMessage(data, 'received');
+                return;
+            }
+
+            switch (frame.type) {
+            case undefined:
+                renderChat(frame);
+                if (authenticated) {
+                    ws.send(JSON.stringify({ type: 'read', id: frame.id }));
+                }
+                break;
+            case 'presence':
+                authenticated = true;
+                addMessage('Online: ' + frame.users.join(', ') + (frame.guests ? ' and ' + frame.guests + ' guests' : '') +
+                    '. Unread: ' + (frame.unread.general || 0));
+                break;
+            case 'read_receipt':
+                receipts[frame.user] = frame.id;
+                receiptsDiv.textContent = 'Seen by ' + Object.keys(receipts).map(function(user) {
+                    return user + ' (#' + receipts[user] + ')';
+                }).join(', ');
+                break;
+            case 'edit':
+                renderChat(frame.message);
+                break;
+            case 'delete':
+                if (chatMessages[frame.id]) {
+                    renderChat({ id: frame.id, in_reply_to: chatMessages[frame.id].in_reply_to, deleted: true });
+                }
+                break;
+            case 'reaction': {
+                const msg = chatMessages[frame.id];
+                if (msg) {
+                    const others = (msg.reactions || []).filter(function(r) { return r.emoji !== frame.emoji; });
+                    const index = (msg.reactions || []).findIndex(function(r) { return r.emoji === frame.emoji; });
+                    if (frame.count > 0) {
+                        others.splice(index < 0 ? others.length : index, 0, { emoji: frame.emoji, count: frame.count });
+                    }
+                    msg.reactions = others;
+                    renderChat(msg);
+                }
+                break;
+            }
+            case 'typing': {
+                const name = frame.user || 'A guest';
+                typers[name] = (typers[name] || 0) + (frame.typing ? 1 : -1);
+                if (typers[name] <= 0) {
+                    delete typers[name];
+                }
+                const names = Object.keys(typers);
+                typingDiv.textContent = names.length ? names.join(', ') + (names.length === 1 ? ' is' : ' are') + ' typing\u2026' : '';
+                break;
+            }
+            case 'thread_summary': {
+                const count = document.getElementById('count-' + frame.id);
+                if (count) {
+                    count.textContent = frame.reply_count === 1 ? '1 reply' : frame.reply_count + ' replies';
+                }
+                break;
+            }
+            case 'error':
+                addMessage('Error: ' + frame.error);
+                break;
+            default:
+                addMessage(data);
+            }
+        }
+
+        function react(id, emoji) {
+            if (ws && ws.readyState === WebSocket.OPEN) {
+                ws.send(JSON.stringify({ type: 'react', id: id, emoji: emoji }));
+            }
+        }
+
+        function startReply(id) {
+            replyTo = id;
+            replyTarget.textContent = '#' + id;
+            replyBar.style.display = 'block';
+            messageInput.focus();
+        }
+
+        function cancelReply() {
+            replyTo = 0;
+            replyBar.style.display = 'none';
+        }
 
         function addMessage(message, type = 'info') {
             const messageElement = document.createElement('div');
@@ -113,13 +336,14 @@ func TestPageHandler(w http.ResponseWriter, _ *http.Request) {
             
             if (type === 'sent') {
                 messageElement.style.color = 'blue';
-                messageElement.innerHTML = '<strong>You:</strong> ' + message;
+                messageElement.textContent = 'You: ' + message;
             } else if (type === 'received') {
                 messageElement.style.color = 'green';
-                messageElement.innerHTML = '<strong>Other:</strong> ' + message;
+                messageElement.textContent = 'Other: ' + message;
             } else {
                 messageElement.style.color = 'gray';
-                messageElement.innerHTML = '<em>' + message + '</em>';
+                messageElement.style.fontStyle = 'italic';
+                messageElement.textContent = message;
             }
             
             messagesDiv.appendChild(messageElement);
@@ -143,7 +367,10 @@ func TestPageHandler(w http.ResponseWriter, _ *http.Request) {
         }
 
         function connect() {
-            ws = new WebSocket('ws://localhost:8080/ws');
+            // Open the page as /test?token=... to connect as a configured user.
+            const token = new URLSearchParams(location.search).get('token');
+            ws = new WebSocket('ws://localhost:8080/ws' + (token ? '?token=' + encodeURIComponent(token) : ''));
+            authenticated = false;
             
             ws.onopen = function(event) {
                 addMessage('Connected to GoChat server');
@@ -151,7 +378,8 @@ func TestPageHandler(w http.ResponseWriter, _ *http.Request) {
             };
             
             ws.onmessage = function(event) {
-                addMessage(event.data, 'received');
+                // Queued frames may arrive batched, one per line.
+                event.data.split('\n').forEach(handleFrame);
             };
             
             ws.onclose = function(event) {
@@ -183,12 +411,37 @@ func TestPageHandler(w http.ResponseWriter, _ *http.Request) {
         function sendMessage() {
             const message = messageInput.value.trim();
             if (message && ws && ws.readyState === WebSocket.OPEN) {
-                ws.send(message);
-                addMessage(message, 'sent');
+                const payload = { content: message };
+                if (replyTo) {
+                    payload.in_reply_to = replyTo;
+                }
+                ws.send(JSON.stringify(payload));
+                addMessage((replyTo ? '\u21aa #' + replyTo + ' ' : '') + message, 'sent');
                 messageInput.value = '';
+                typingSentAt = 0;
+                cancelReply();
             }
         }
 
+        function notifyTyping() {
+            if (!ws || ws.readyState !== WebSocket.OPEN) {
+                return;
+            }
+            if (!messageInput.value) {
+                if (typingSentAt) {
+                    ws.send(JSON.stringify({ type: 'typing_stop' }));
+                    typingSentAt = 0;
+                }
+                return;
+            }
+            if (Date.now() - typingSentAt > 3000) {
+                ws.send(JSON.stringify({ type: 'typing_start' }));
+                typingSentAt = Date.now();
+            }
+        }
+
+        messageInput.addEventListener('input', notifyTyping);
+
         messageInput.addEventListener('keypress', function(e) {
             if (e.key === 'Enter') {
                 sendMessage();
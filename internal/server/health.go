@@ -0,0 +1,151 @@
+// Package server reports the health of the process for orchestrators such
+// as Kubernetes. GET /healthz is the liveness probe: it succeeds whenever
+// the server can answer. GET /readyz is the readiness probe: it fails before
+// the hub has started, while the server drains, and while a storage backend
+// is unreachable, so that no new clients are routed to the instance.
+package server
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Health and component states.
+const (
+	healthOK          = "ok"
+	healthUnavailable = "unavailable"
+	healthDraining    = "draining"
+)
+
+var (
+	processStart = time.Now()
+
+	buildMu      sync.RWMutex
+	buildVersion = "dev"
+	buildCommit  = ""
+)
+
+// SetVersion records the version and commit reported by the health
+// endpoints. It is called by main with values set at link time.
+func SetVersion(version, commit string) {
+	buildMu.Lock()
+	defer buildMu.Unlock()
+	if version != "" {
+		buildVersion = version
+	}
+	buildCommit = commit
+}
+
+// componentHealth is the state of one dependency of the server.
+type componentHealth struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// healthResponse is the body of GET /healthz and GET /readyz.
+type healthResponse struct {
+	Status     string                     `json:"status"`
+	Version    string                     `json:"version"`
+	Commit     string                     `json:"commit,omitempty"`
+	Uptime     int64                      `json:"uptime_seconds"`
+	Clients    int                        `json:"clients"`
+	Components map[string]componentHealth `json:"components"`
+}
+
+// LivenessHandler serves GET /healthz. It answers 200 as long as the process
+// can serve requests; the components are reported for information only, so
+// that an unreachable backend does not get the process restarted.
+func LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowProbeMethod(w, r) {
+		return
+	}
+	response, _ := checkHealth()
+	response.Status = healthOK
+	writeJSON(w, http.StatusOK, response)
+}
+
+// ReadinessHandler serves GET /readyz. It answers 503 Service Unavailable
+// before StartHub has run, while the server drains, and while any component
+// is unavailable.
+func ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	if !allowProbeMethod(w, r) {
+		return
+	}
+	response, ready := checkHealth()
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, response)
+}
+
+func allowProbeMethod(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+	w.Header().Set("Allow", "GET, HEAD")
+	http.Error(w, "Method not allowed. Health endpoints only accept GET and HEAD requests.", http.StatusMethodNotAllowed)
+	return false
+}
+
+// checkHealth checks every component and reports whether the server is
+// ready for new clients.
+func checkHealth() (healthResponse, bool) {
+	cfg := currentConfig()
+	components := map[string]componentHealth{"hub": hubHealth()}
+	if cfg.BanListPath != "" {
+		components["ban_list"] = storageHealth(cfg.BanListPath)
+	}
+	if cfg.AuditLogPath != "" {
+		components["audit_log"] = storageHealth(cfg.AuditLogPath)
+	}
+
+	status := healthOK
+	for _, component := range components {
+		if component.Status != healthOK {
+			status = healthUnavailable
+		}
+	}
+	if hub.draining.Load() {
+		status = healthDraining
+	}
+
+	buildMu.RLock()
+	defer buildMu.RUnlock()
+	return healthResponse{
+		Status:     status,
+		Version:    buildVersion,
+		Commit:     buildCommit,
+		Uptime:     int64(time.Since(processStart).Seconds()),
+		Clients:    hub.ClientCount(),
+		Components: components,
+	}, status == healthOK
+}
+
+func hubHealth() componentHealth {
+	switch {
+	case !hub.started.Load():
+		return componentHealth{Status: healthUnavailable, Error: "hub not started"}
+	case hub.ctx.Err() != nil:
+		return componentHealth{Status: healthUnavailable, Error: "hub shut down"}
+	default:
+		return componentHealth{Status: healthOK}
+	}
+}
+
+// storageHealth checks that the directory holding a file the server writes
+// is still reachable, for example that its volume is mounted.
+func storageHealth(path string) componentHealth {
+	info, err := os.Stat(filepath.Dir(path))
+	if err == nil && !info.IsDir() {
+		err = errors.New("not a directory")
+	}
+	if err != nil {
+		return componentHealth{Status: healthUnavailable, Error: err.Error()}
+	}
+	return componentHealth{Status: healthOK}
+}
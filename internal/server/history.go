@@ -0,0 +1,238 @@
+// Package server keeps a bounded in-memory history of broadcast messages so
+// that reconnecting clients can resume from the last message they saw.
+package server
+
+import (
+	"iter"
+	"sort"
+	"sync"
+	"time"
+)
+
+// historyEntry is a broadcast payload together with its server-assigned id,
+// the time the server stored it, and the identity of its author, which
+// decides who may edit it. Reactions records who reacted with what; only the
+// counts are part of the payload.
+type historyEntry struct {
+	ID        uint64
+	Payload   []byte
+	Author    string
+	Time      time.Time
+	Reactions []reaction
+}
+
+// reaction is one emoji on a message and the identities that added it.
+type reaction struct {
+	emoji string
+	users []string
+}
+
+// messageHistory is a log of recent broadcasts. Entries are only rewritten by
+// edits and deletions. Ids increase monotonically and are never reused, even
+// after old entries are evicted. search is updated while mu is held, so that
+// changes and evictions reach the index in the order they were made.
+type messageHistory struct {
+	mu      sync.RWMutex
+	entries []historyEntry
+	lastID  uint64
+	search  *searchIndex
+}
+
+func newMessageHistory(search *searchIndex) *messageHistory {
+	return &messageHistory{search: search}
+}
+
+// append records a payload built by encode from the newly assigned id,
+// evicting the oldest entries so that at most limit entries are retained,
+// and returns the stored entry.
+func (mh *messageHistory) append(author string, encode func(id uint64) []byte, limit int) historyEntry {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	mh.lastID++
+	entry := historyEntry{ID: mh.lastID, Payload: encode(mh.lastID), Author: author, Time: time.Now().UTC()}
+	mh.entries = append(mh.entries, entry)
+
+	if limit < 0 {
+		limit = 0
+	}
+	if excess := len(mh.entries) - limit; excess > 0 {
+		mh.entries = append([]historyEntry(nil), mh.entries[excess:]...)
+	}
+
+	mh.search.index(entry)
+	mh.search.evictBefore(mh.oldestIDLocked())
+	return entry
+}
+
+// prune drops the oldest entries that were stored before cutoff, if it is
+// not zero, and those beyond the newest maxCount, if it is not zero. It
+// returns how many entries were dropped.
+func (mh *messageHistory) prune(cutoff time.Time, maxCount int) int {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	pruned := 0
+	if !cutoff.IsZero() {
+		for pruned < len(mh.entries) && mh.entries[pruned].Time.Before(cutoff) {
+			pruned++
+		}
+	}
+	if maxCount > 0 {
+		pruned = max(pruned, len(mh.entries)-maxCount)
+	}
+	if pruned > 0 {
+		mh.entries = append([]historyEntry(nil), mh.entries[pruned:]...)
+		mh.search.evictBefore(mh.oldestIDLocked())
+	}
+	return pruned
+}
+
+// restore appends entries that keep their ids, such as imported messages,
+// and evicts the oldest entries beyond limit. The ids must increase and be
+// greater than any id assigned so far. It returns how many entries were
+// evicted.
+func (mh *messageHistory) restore(entries []historyEntry, limit int) (int, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	last := mh.lastID
+	for _, entry := range entries {
+		if entry.ID <= last {
+			return 0, errIDConflict
+		}
+		last = entry.ID
+	}
+	mh.entries = append(mh.entries, entries...)
+	mh.lastID = last
+
+	evicted := max(len(mh.entries)-max(limit, 0), 0)
+	if evicted > 0 {
+		mh.entries = append([]historyEntry(nil), mh.entries[evicted:]...)
+	}
+	// The restored ids are newer than every indexed message, so they are
+	// indexed one by one.
+	for _, entry := range entries {
+		mh.search.index(entry)
+	}
+	mh.search.evictBefore(mh.oldestIDLocked())
+	return evicted, nil
+}
+
+// since returns the retained entries with an id greater than afterID, oldest
+// first.
+func (mh *messageHistory) since(afterID uint64) []historyEntry {
+	mh.mu.RLock()
+	defer mh.mu.RUnlock()
+
+	for i, entry := range mh.entries {
+		if entry.ID > afterID {
+			return append([]historyEntry(nil), mh.entries[i:]...)
+		}
+	}
+	return nil
+}
+
+// windowLocked returns the positions of the first retained entry with an id
+// greater than afterID and of the first with an id of at least beforeID, or
+// the end of the history if beforeID is zero. mh.mu must be held.
+func (mh *messageHistory) windowLocked(afterID, beforeID uint64) (int, int) {
+	start := sort.Search(len(mh.entries), func(i int) bool { return mh.entries[i].ID > afterID })
+	end := len(mh.entries)
+	if beforeID != 0 {
+		end = sort.Search(len(mh.entries), func(i int) bool { return mh.entries[i].ID >= beforeID })
+	}
+	return start, end
+}
+
+// all iterates over the retained entries, oldest first. It copies them in
+// batches of batchSize and holds the lock only while copying, so the caller
+// may block between entries without holding up broadcasts. Entries stored
+// during the iteration are included.
+func (mh *messageHistory) all(batchSize int) iter.Seq[historyEntry] {
+	return func(yield func(historyEntry) bool) {
+		var afterID uint64
+		for {
+			mh.mu.RLock()
+			start, end := mh.windowLocked(afterID, 0)
+			batch := append([]historyEntry(nil), mh.entries[start:min(end, start+batchSize)]...)
+			mh.mu.RUnlock()
+			if len(batch) == 0 {
+				return
+			}
+			for _, entry := range batch {
+				if !yield(entry) {
+					return
+				}
+			}
+			afterID = batch[len(batch)-1].ID
+		}
+	}
+}
+
+// get returns the retained entry with the given id.
+func (mh *messageHistory) get(id uint64) (historyEntry, bool) {
+	mh.mu.RLock()
+	defer mh.mu.RUnlock()
+
+	i := mh.indexLocked(id)
+	if i < 0 {
+		return historyEntry{}, false
+	}
+	return mh.entries[i], true
+}
+
+// indexLocked returns the position of the entry with the given id, or -1.
+func (mh *messageHistory) indexLocked(id uint64) int {
+	i := sort.Search(len(mh.entries), func(i int) bool { return mh.entries[i].ID >= id })
+	if i == len(mh.entries) || mh.entries[i].ID != id {
+		return -1
+	}
+	return i
+}
+
+// update applies change to a copy of a retained entry and stores the copy
+// unless change fails. It reports false if the entry is no longer retained.
+// change must not modify slices shared with the current entry in place.
+func (mh *messageHistory) update(id uint64, change func(*historyEntry) error) (historyEntry, bool, error) {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+
+	i := mh.indexLocked(id)
+	if i < 0 {
+		return historyEntry{}, false, nil
+	}
+
+	entry := mh.entries[i]
+	if err := change(&entry); err != nil {
+		return mh.entries[i], true, err
+	}
+	mh.entries[i] = entry
+	mh.search.index(entry)
+	return entry, true, nil
+}
+
+// reserveID assigns the next id without storing an entry, for messages such
+// as direct messages that share the id sequence but not the history.
+func (mh *messageHistory) reserveID() uint64 {
+	mh.mu.Lock()
+	defer mh.mu.Unlock()
+	mh.lastID++
+	return mh.lastID
+}
+
+// latestID returns the most recently assigned id.
+func (mh *messageHistory) latestID() uint64 {
+	mh.mu.RLock()
+	defer mh.mu.RUnlock()
+	return mh.lastID
+}
+
+// oldestIDLocked returns the id of the oldest retained entry, or the id the
+// next entry will get if none is retained. mh.mu must be held.
+func (mh *messageHistory) oldestIDLocked() uint64 {
+	if len(mh.entries) == 0 {
+		return mh.lastID + 1
+	}
+	return mh.entries[0].ID
+}
@@ -0,0 +1,181 @@
+// Package server serves the message history over HTTP so that clients can
+// page back through a room, for example to implement infinite scroll,
+// without relying on the replay they get when they connect.
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+)
+
+// History page size limits.
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 100
+)
+
+var (
+	errBothCursors   = &messageError{status: http.StatusBadRequest, code: "invalid_cursor", message: "Use either before or after, not both"}
+	errInvalidCursor = &messageError{status: http.StatusBadRequest, code: "invalid_cursor", message: "Invalid cursor"}
+	errInvalidLimit  = &messageError{status: http.StatusBadRequest, code: "invalid_limit", message: "Invalid limit; use 1 to 100"}
+)
+
+// historyPage selects the messages of one page: the newest limit messages
+// older than before, or the oldest limit messages newer than after. With
+// neither set, the page holds the latest messages.
+type historyPage struct {
+	before uint64
+	after  uint64
+	limit  int
+}
+
+// historyResponse is the body of GET /api/v1/rooms/{room}/messages.
+// Messages are ordered oldest first; HasMore reports whether the history
+// holds more messages beyond the page in the direction it was requested.
+type historyResponse struct {
+	Room     string            `json:"room"`
+	Messages []json.RawMessage `json:"messages"`
+	HasMore  bool              `json:"has_more"`
+}
+
+// RoomMessagesHandler serves /api/v1/rooms/{room}/messages:
+//
+//	GET    pages through the room's message history (see HistoryHandler)
+//	POST   posts a message from an integration (see IngestMessageHandler)
+func RoomMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		HistoryHandler(w, r)
+	case http.MethodPost:
+		IngestMessageHandler(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed. Messages endpoint accepts GET and POST requests.", http.StatusMethodNotAllowed)
+	}
+}
+
+// HistoryHandler serves GET /api/v1/rooms/{room}/messages. Authenticated
+// members of the room page through its retained messages with the before
+// and after cursors, which are message ids. Messages are returned in the
+// format clients receive them in, ordered by id. The server assigns ids in
+// increasing order as it stores messages, so for messages sent to this
+// server id order is also the order of their server timestamps, and the id
+// alone is a stable cursor. Imported messages keep their original ids and
+// times.
+func HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed. History endpoint only accepts GET requests.", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkIPAccess(w, r) {
+		return
+	}
+	user, _, ok := authenticateClient(w, r)
+	if !ok {
+		return
+	}
+	if user == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gochat"`)
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+	// Every user belongs to the default room, the only one there is.
+	room := r.PathValue("room")
+	if room != DefaultRoom {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	page, err := parseHistoryPage(r.URL.Query())
+	if err != nil {
+		writeMessageError(w, err)
+		return
+	}
+
+	messages, more := hub.history.page(page)
+	writeJSON(w, http.StatusOK, historyResponse{Room: room, Messages: messages, HasMore: more})
+}
+
+// page returns the chat message payloads selected by p, oldest first, and
+// whether more messages follow in the direction of the page. It walks the
+// history from the cursor and stops after limit+1 messages, which is enough
+// to know whether there are more.
+func (mh *messageHistory) page(p historyPage) ([]json.RawMessage, bool) {
+	mh.mu.RLock()
+	defer mh.mu.RUnlock()
+
+	start, end := mh.windowLocked(p.after, p.before)
+	messages := make([]json.RawMessage, 0, p.limit+1)
+	collect := func(entry historyEntry) {
+		if _, ok := decodeEntry(entry); ok {
+			messages = append(messages, json.RawMessage(entry.Payload))
+		}
+	}
+	if p.after != 0 {
+		for i := start; i < end && len(messages) <= p.limit; i++ {
+			collect(mh.entries[i])
+		}
+	} else {
+		for i := end - 1; i >= start && len(messages) <= p.limit; i-- {
+			collect(mh.entries[i])
+		}
+		slices.Reverse(messages)
+	}
+
+	more := len(messages) > p.limit
+	if more {
+		if p.after != 0 {
+			messages = messages[:p.limit]
+		} else {
+			messages = messages[1:]
+		}
+	}
+	return messages, more
+}
+
+// parseHistoryPage validates the query parameters of a history request.
+func parseHistoryPage(values url.Values) (historyPage, error) {
+	page := historyPage{limit: defaultPageLimit}
+	if values.Get("before") != "" && values.Get("after") != "" {
+		return page, errBothCursors
+	}
+	var err error
+	if page.before, err = parseCursor(values.Get("before")); err != nil {
+		return page, err
+	}
+	if page.after, err = parseCursor(values.Get("after")); err != nil {
+		return page, err
+	}
+	page.limit, err = parseLimit(values.Get("limit"), defaultPageLimit)
+	return page, err
+}
+
+// parseCursor parses a message id used as a paging cursor. An empty value
+// is no cursor.
+func parseCursor(value string) (uint64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || id == 0 {
+		return 0, errInvalidCursor
+	}
+	return id, nil
+}
+
+// parseLimit parses a page size of 1 to 100. An empty value selects
+// fallback.
+func parseLimit(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 || n > maxPageLimit {
+		return 0, errInvalidLimit
+	}
+	return n, nil
+}
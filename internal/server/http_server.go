@@ -7,26 +7,43 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 )
 
 // CreateServer creates and configures an HTTP server with the specified port and handler.
-// It sets reasonable timeout values for production use.
+// Its read, write and idle timeouts come from the current configuration.
+// Streaming fallback clients are disconnected when the server shuts down so
+// that their open responses do not hold up Shutdown.
 func CreateServer(port string, handler http.Handler) *http.Server {
-	return &http.Server{
+	cfg := currentConfig()
+	server := &http.Server{
 		Addr:         port,
 		Handler:      handler,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
 	}
+	server.RegisterOnShutdown(hub.disconnectStreams)
+	return server
 }
 
+var startHubOnce sync.Once
+
 // StartHub initializes and starts the global hub in a separate goroutine.
-// This should be called before starting the HTTP server.
+// This should be called before starting the HTTP server. Repeated calls are
+// no-ops: the hub must run a single event loop so that history replay and
+// broadcasts are delivered in order. The search index is rebuilt from the
+// history before the hub starts, and the retention janitor starts with it.
+// The server reports ready from then on.
 func StartHub() {
-	go hub.Run()
-	log.Println("Hub started and ready to manage WebSocket connections")
+	startHubOnce.Do(func() {
+		hub.search.rebuild(hub.history.since(0))
+		go hub.Run()
+		hub.startJanitor()
+		hub.started.Store(true)
+		log.Println("Hub started and ready to manage WebSocket connections")
+	})
 }
 
 // StartServer starts the HTTP server and begins listening for connections.
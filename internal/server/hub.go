@@ -6,6 +6,7 @@ import (
 	"context"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,7 +15,21 @@ import (
 // through mutex protection.
 type Hub struct {
 	clients    map[*Client]bool
+	sessions   map[string]*Client
+	history    *messageHistory
+	webhooks   *webhookDispatcher
+	moderation *moderationState
+	typing     *typingState
+	reads      *readMarkers
+	delivery   *deliveryState
+	parked     map[string]*Client
+	inboxes    *inboxStore
+	search     *searchIndex
+	janitor    *retentionJanitor
+	started    atomic.Bool
+	draining   atomic.Bool
 	broadcast  chan BroadcastMessage
+	restores   chan historyRestore
 	register   chan *Client
 	unregister chan *Client
 	mutex      sync.RWMutex
@@ -28,9 +43,23 @@ type Hub struct {
 // and client map. The returned Hub is ready to manage WebSocket connections.
 func NewHub() *Hub {
 	ctx, cancel := context.WithCancel(context.Background())
+	search := newSearchIndex()
+	history := newMessageHistory(search)
 	return &Hub{
 		clients:    make(map[*Client]bool),
+		sessions:   make(map[string]*Client),
+		history:    history,
+		webhooks:   newWebhookDispatcher(),
+		moderation: newModerationState(),
+		typing:     newTypingState(),
+		reads:      newReadMarkers(),
+		delivery:   newDeliveryState(),
+		parked:     make(map[string]*Client),
+		inboxes:    newInboxStore(history.reserveID),
+		search:     search,
+		janitor:    newRetentionJanitor(),
 		broadcast:  make(chan BroadcastMessage),
+		restores:   make(chan historyRestore),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 		ctx:        ctx,
@@ -39,6 +68,14 @@ func NewHub() *Hub {
 	}
 }
 
+// ClientCount returns the number of clients currently registered with the
+// hub across all transports.
+func (h *Hub) ClientCount() int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return len(h.clients)
+}
+
 // GetRegisterChan returns the channel used for registering new clients to the hub.
 // This channel is write-only from the caller's perspective.
 func (h *Hub) GetRegisterChan() chan<- *Client {
@@ -96,59 +133,187 @@ func (h *Hub) Run() {
 			return
 
 		case client := <-h.register:
-			if client == nil {
-				log.Printf("Received nil client registration; skipping")
-				continue
-			}
-
-			h.mutex.Lock()
-			client.closed = false
-			h.clients[client] = true
-			clientCount := len(h.clients)
-			h.mutex.Unlock()
-			log.Printf("Client registered from %s. Total clients: %d", client.addr, clientCount)
-
-			h.wg.Add(2)
-			go func() {
-				defer h.wg.Done()
-				client.writePump()
-			}()
-			go func() {
-				defer h.wg.Done()
-				client.readPump()
-			}()
+			h.registerClient(client)
 
 		case client := <-h.unregister:
-			h.mutex.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				client.closed = true
-				clientCount := len(h.clients)
-				h.mutex.Unlock()
-				// Close the channel after releasing the lock
-				close(client.send)
-				log.Printf("Client unregistered from %s. Total clients: %d", client.addr, clientCount)
-			} else {
-				h.mutex.Unlock()
-			}
+			h.unregisterClient(client)
 
 		case broadcastMsg := <-h.broadcast:
 			h.handleBroadcast(broadcastMsg)
+
+		case restore := <-h.restores:
+			h.handleRestore(restore)
 		}
 	}
 }
 
 var hub = NewHub()
 
+// registerClient adds a client to the hub, replays any history it asked to
+// resume from, and starts the WebSocket pumps for WebSocket clients.
+func (h *Hub) registerClient(client *Client) {
+	if client == nil {
+		log.Printf("Received nil client registration; skipping")
+		return
+	}
+
+	parked := h.takeOverSession(client)
+
+	h.mutex.Lock()
+	client.closed = false
+	h.clients[client] = true
+	if client.sessionID != "" {
+		h.sessions[client.sessionID] = client
+	}
+	if parked != nil {
+		h.forgetClientLocked(parked)
+	}
+	clientCount := len(h.clients)
+	h.mutex.Unlock()
+	log.Printf("Client registered from %s. Total clients: %d", client.addr, clientCount)
+	if parked == nil {
+		h.emitPresence(WebhookEventJoin, client, clientCount)
+	}
+	h.sendPresence(client)
+	h.startSession(client, parked)
+	h.deliverInbox(client)
+
+	h.prepareRedelivery(client)
+	if client.resume {
+		h.replayHistory(client)
+	}
+
+	if client.transport != transportWebSocket {
+		return
+	}
+
+	h.wg.Add(2)
+	go func() {
+		defer h.wg.Done()
+		client.writePump()
+	}()
+	go func() {
+		defer h.wg.Done()
+		client.readPump()
+	}()
+}
+
+// unregisterClient removes a client from the hub and closes its send
+// channel, unless the client's session is kept for it to resume.
+func (h *Hub) unregisterClient(client *Client) {
+	if h.parkSession(client) {
+		return
+	}
+	h.forgetParked(client)
+
+	h.mutex.Lock()
+	if _, ok := h.clients[client]; ok {
+		h.forgetClientLocked(client)
+		clientCount := len(h.clients)
+		h.mutex.Unlock()
+		// Close the channel after releasing the lock
+		close(client.send)
+		log.Printf("Client unregistered from %s. Total clients: %d", client.addr, clientCount)
+		h.emitPresence(WebhookEventLeave, client, clientCount)
+		h.clearTyping(client)
+	} else {
+		h.mutex.Unlock()
+	}
+}
+
+// leave unregisters a client unless the hub has already stopped, in which
+// case nobody is left to receive from the unregister channel.
+func (h *Hub) leave(client *Client) {
+	select {
+	case h.unregister <- client:
+	case <-h.ctx.Done():
+	}
+}
+
+// forgetClientLocked removes a client from the hub's indexes and frees its
+// connection slot. The caller must hold h.mutex for writing and is
+// responsible for closing client.send.
+func (h *Hub) forgetClientLocked(client *Client) {
+	delete(h.clients, client)
+	if client.sessionID != "" && h.sessions[client.sessionID] == client {
+		delete(h.sessions, client.sessionID)
+	}
+	client.closed = true
+	admission.release(client.admissionKey)
+	client.admissionKey = ""
+}
+
+// replayHistory queues every retained message newer than client.resumeAfter.
+// It runs on the hub goroutine, so no broadcast can interleave with the replay.
+func (h *Hub) replayHistory(client *Client) {
+	entries := h.history.since(client.resumeAfter)
+	for i, entry := range entries {
+		if !h.safeSend(client, client.frame(entry)) {
+			log.Printf("Stopped history replay for %s after %d of %d messages", client.addr, i, len(entries))
+			return
+		}
+	}
+	if len(entries) > 0 {
+		log.Printf("Replayed %d messages to %s", len(entries), client.addr)
+	}
+}
+
+// sessionClient returns the registered client with the given session id.
+func (h *Hub) sessionClient(sessionID string) (*Client, bool) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	client, ok := h.sessions[sessionID]
+	return client, ok
+}
+
+// disconnectStreams removes every client that is served by a streaming HTTP
+// response so that their handlers return and the HTTP server can shut down.
+func (h *Hub) disconnectStreams() {
+	h.mutex.Lock()
+	var removed []*Client
+	for client := range h.clients {
+		if client.transport == transportWebSocket {
+			continue
+		}
+		h.forgetClientLocked(client)
+		removed = append(removed, client)
+	}
+	clientCount := len(h.clients)
+	h.mutex.Unlock()
+
+	for _, client := range removed {
+		close(client.send)
+		h.emitPresence(WebhookEventLeave, client, clientCount)
+	}
+	if len(removed) > 0 {
+		log.Printf("Disconnected %d streaming clients", len(removed))
+	}
+}
+
 // handleBroadcast processes a broadcast message and sends it to all clients except the sender
 func (h *Hub) handleBroadcast(broadcastMsg BroadcastMessage) {
 	clients := h.getClientSnapshot()
+	if broadcastMsg.Event {
+		h.removeFailedClients(h.broadcastToClients(clients, broadcastMsg.Sender, historyEntry{Payload: broadcastMsg.Payload}))
+		return
+	}
+
 	targetCount := h.calculateTargetCount(len(clients), broadcastMsg.Sender)
 
 	log.Printf("Broadcasting message to %d clients", targetCount)
 
-	clientsToRemove := h.broadcastToClients(clients, broadcastMsg)
+	entry := h.history.append(broadcastMsg.author(), broadcastMsg.encode, currentConfig().HistorySize)
+	clientsToRemove := h.broadcastToClients(clients, broadcastMsg.Sender, entry)
+	h.emitMessage(broadcastMsg, entry)
+	if broadcastMsg.Result != nil {
+		broadcastMsg.Result <- entry.ID
+	}
 	h.removeFailedClients(clientsToRemove)
+
+	if broadcastMsg.Message != nil && broadcastMsg.Message.InReplyTo != 0 {
+		h.pushThreadSummary(broadcastMsg.Message.InReplyTo)
+	}
 }
 
 // getClientSnapshot returns a thread-safe snapshot of all current clients
@@ -176,14 +341,14 @@ func (h *Hub) calculateTargetCount(clientCount int, sender *Client) int {
 }
 
 // broadcastToClients sends the message to all clients except the sender and returns failed clients
-func (h *Hub) broadcastToClients(clients []*Client, broadcastMsg BroadcastMessage) []*Client {
+func (h *Hub) broadcastToClients(clients []*Client, sender *Client, entry historyEntry) []*Client {
 	var clientsToRemove []*Client
 
 	for _, client := range clients {
-		if broadcastMsg.Sender != nil && client == broadcastMsg.Sender {
+		if sender != nil && client == sender {
 			continue
 		}
-		if !h.safeSend(client, broadcastMsg.Payload) {
+		if !h.safeSend(client, client.frame(entry)) {
 			clientsToRemove = append(clientsToRemove, client)
 		}
 	}
@@ -198,20 +363,21 @@ func (h *Hub) removeFailedClients(clientsToRemove []*Client) {
 	}
 
 	h.mutex.Lock()
-	var channelsToClose []chan []byte
+	var removed []*Client
 	for _, client := range clientsToRemove {
 		if _, exists := h.clients[client]; exists {
-			delete(h.clients, client)
-			client.closed = true
-			channelsToClose = append(channelsToClose, client.send)
+			h.forgetClientLocked(client)
+			removed = append(removed, client)
 			log.Printf("Client from %s removed due to full send buffer", client.addr)
 		}
 	}
+	clientCount := len(h.clients)
 	h.mutex.Unlock()
 
 	// Close channels after releasing the lock
-	for _, ch := range channelsToClose {
-		close(ch)
+	for _, client := range removed {
+		close(client.send)
+		h.emitPresence(WebhookEventLeave, client, clientCount)
 	}
 }
 
@@ -252,6 +418,12 @@ func (h *Hub) Shutdown(timeout time.Duration) error {
 	// Wait for Run() to complete
 	<-h.done
 
+	// Wait for the retention janitor to exit
+	h.stopJanitor()
+
+	// Stop webhook workers; undelivered events go to the dead-letter log
+	h.webhooks.shutdown()
+
 	// Wait for all client goroutines to finish with timeout
 	done := make(chan struct{})
 	go func() {
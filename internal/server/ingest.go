@@ -0,0 +1,171 @@
+// Package server implements the HTTP ingestion API that lets bots and
+// integrations post chat messages with a single request. Integrations
+// authenticate with API keys from the server configuration and appear as the
+// message sender.
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultRoom is the chat room every client belongs to.
+const DefaultRoom = "general"
+
+// apiKeyHeader is an alternative to the Authorization header for API keys.
+const apiKeyHeader = "X-API-Key"
+
+// integrationLimiters holds one rate limiter per integration name so that a
+// misbehaving integration is throttled like a chat client.
+var (
+	integrationLimitersMu sync.Mutex
+	integrationLimiters   = make(map[string]*rateLimiter)
+)
+
+// ingestResponse is the body returned for an accepted message.
+type ingestResponse struct {
+	ID   uint64 `json:"id"`
+	Room string `json:"room"`
+}
+
+// IngestMessageHandler serves POST /api/v1/rooms/{room}/messages. The request
+// carries an API key as "Authorization: Bearer <key>" or in X-API-Key, and a
+// body in the same JSON format clients send over WebSocket. The message is
+// validated and size-limited like client messages, broadcast with the
+// integration's name as its sender, and the assigned message id is returned.
+func IngestMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed. Messages endpoint only accepts POST requests.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkIPAccess(w, r) {
+		return
+	}
+
+	cfg := currentConfig()
+	integration, ok := authenticateIntegration(r, cfg.APIKeys)
+	if !ok {
+		auditRefusal(r, auditAuthFailed, "", "invalid or missing API key")
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gochat"`)
+		http.Error(w, "Invalid or missing API key", http.StatusUnauthorized)
+		return
+	}
+
+	room := r.PathValue("room")
+	if room != DefaultRoom {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, cfg.MaxMessageSize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			log.Printf("Message from integration %s exceeded maximum size of %d bytes", integration, cfg.MaxMessageSize)
+			auditRequest(r, auditMessageTooLarge, integration, "limit "+strconv.FormatInt(cfg.MaxMessageSize, 10)+" bytes")
+			http.Error(w, "Message too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Failed to read message", http.StatusBadRequest)
+		return
+	}
+
+	if allowed, started := integrationLimiter(integration, cfg.RateLimit).throttle(); !allowed {
+		log.Printf("Rate limit exceeded for integration %s", integration)
+		if started {
+			auditRequest(r, auditRateLimited, integration, "")
+		}
+		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	msg, err := normalizeMessage(body, integration)
+	if err != nil {
+		log.Printf("Invalid message from integration %s: %v", integration, err)
+		http.Error(w, "Invalid message", http.StatusBadRequest)
+		return
+	}
+	if err := hub.resolveReply(msg); err != nil {
+		writeMessageError(w, err)
+		return
+	}
+
+	id, ok := hub.submit(r.Context(), BroadcastMessage{Identity: integration, Message: msg})
+	if !ok {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	log.Printf("Received message %d from integration %s: %q", id, integration, msg.Content)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(ingestResponse{ID: id, Room: room}); err != nil {
+		log.Printf("Error writing ingestion response: %v", err)
+	}
+}
+
+// authenticateIntegration returns the name of the integration whose key was
+// presented. Every configured key is compared in constant time.
+func authenticateIntegration(r *http.Request, keys []APIKey) (string, bool) {
+	presented := r.Header.Get(apiKeyHeader)
+	if auth := r.Header.Get("Authorization"); presented == "" && auth != "" {
+		scheme, token, found := strings.Cut(auth, " ")
+		if found && strings.EqualFold(scheme, "Bearer") {
+			presented = strings.TrimSpace(token)
+		}
+	}
+	if presented == "" {
+		return "", false
+	}
+
+	name := ""
+	for _, key := range keys {
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(key.Key)) == 1 && name == "" {
+			name = key.Name
+		}
+	}
+	return name, name != ""
+}
+
+func integrationLimiter(name string, cfg RateLimitConfig) *rateLimiter {
+	integrationLimitersMu.Lock()
+	defer integrationLimitersMu.Unlock()
+
+	limiter, ok := integrationLimiters[name]
+	if !ok {
+		limiter = newRateLimiter(cfg.Burst, cfg.RefillInterval)
+		integrationLimiters[name] = limiter
+	}
+	return limiter
+}
+
+// submit hands a message to the hub and waits for its assigned id. It
+// reports false if the hub stopped or ctx was cancelled first.
+func (h *Hub) submit(ctx context.Context, msg BroadcastMessage) (uint64, bool) {
+	result := make(chan uint64, 1)
+	msg.Result = result
+
+	select {
+	case h.broadcast <- msg:
+	case <-h.ctx.Done():
+		return 0, false
+	case <-ctx.Done():
+		return 0, false
+	}
+
+	select {
+	case id := <-result:
+		return id, true
+	case <-h.ctx.Done():
+		return 0, false
+	}
+}
@@ -0,0 +1,144 @@
+// Package server configures how connections are kept alive. WebSocket
+// clients are pinged every ping period and dropped when no pong arrives
+// within the pong wait. SSE streams write a keep-alive comment every ping
+// period, and long polls are answered after at most one ping period so that
+// idle connections are not closed by intermediaries. Each transport may
+// override the global settings, for example to suit mobile networks.
+package server
+
+import (
+	"log"
+	"maps"
+	"strings"
+	"time"
+)
+
+// KeepalivePolicy holds the keepalive settings of one transport:
+//
+//   - PongWait is how long a WebSocket connection may stay silent before it
+//     is closed, and how long a long-poll session survives without a poll.
+//   - PingPeriod is how often WebSocket clients are pinged, how often SSE
+//     streams get a keep-alive comment, and how long a poll is held open.
+//     It must be shorter than PongWait.
+//   - WriteWait bounds each write to the client.
+type KeepalivePolicy struct {
+	PongWait   time.Duration
+	PingPeriod time.Duration
+	WriteWait  time.Duration
+}
+
+// KeepaliveConfig holds the global keepalive settings and per-transport
+// overrides keyed by "websocket", "sse" or "longpoll". Zero fields of an
+// override inherit the global value.
+type KeepaliveConfig struct {
+	PongWait   time.Duration
+	PingPeriod time.Duration
+	WriteWait  time.Duration
+	Transports map[string]KeepalivePolicy
+}
+
+func defaultKeepaliveConfig() KeepaliveConfig {
+	return KeepaliveConfig{
+		PongWait:   60 * time.Second,
+		PingPeriod: 54 * time.Second,
+		WriteWait:  10 * time.Second,
+	}
+}
+
+// validPingPeriod returns period, or nine tenths of pongWait when period is
+// not shorter than it.
+func validPingPeriod(period, pongWait time.Duration) time.Duration {
+	if period < pongWait {
+		return period
+	}
+	log.Printf("Keepalive ping period %s is not shorter than the pong wait %s; using %s", period, pongWait, pongWait*9/10)
+	return pongWait * 9 / 10
+}
+
+func sanitizeKeepaliveConfig(cfg KeepaliveConfig) KeepaliveConfig {
+	defaults := defaultKeepaliveConfig()
+	if cfg.PongWait <= 0 {
+		cfg.PongWait = defaults.PongWait
+	}
+	if cfg.PingPeriod <= 0 {
+		cfg.PingPeriod = min(defaults.PingPeriod, cfg.PongWait*9/10)
+	}
+	if cfg.WriteWait <= 0 {
+		cfg.WriteWait = defaults.WriteWait
+	}
+	cfg.PingPeriod = validPingPeriod(cfg.PingPeriod, cfg.PongWait)
+
+	overrides := cfg.Transports
+	cfg.Transports = make(map[string]KeepalivePolicy, len(overrides))
+	for name, override := range overrides {
+		transport, ok := parseTransportName(name)
+		if !ok {
+			log.Printf("Ignoring keepalive settings for unknown transport %q", name)
+			continue
+		}
+		override = KeepalivePolicy{
+			PongWait:   max(override.PongWait, 0),
+			PingPeriod: max(override.PingPeriod, 0),
+			WriteWait:  max(override.WriteWait, 0),
+		}
+		cfg.Transports[name] = override
+		resolved := cfg.policy(transport)
+		if period := validPingPeriod(resolved.PingPeriod, resolved.PongWait); period != resolved.PingPeriod {
+			override.PingPeriod = period
+			cfg.Transports[name] = override
+		}
+	}
+	return cfg
+}
+
+func copyKeepaliveConfig(cfg KeepaliveConfig) KeepaliveConfig {
+	cfg.Transports = maps.Clone(cfg.Transports)
+	return cfg
+}
+
+// policy returns the keepalive settings in effect for transport. SSE
+// streams and long polls use the shorter of the global ping period and
+// their own default unless the transport sets its own.
+func (cfg KeepaliveConfig) policy(transport clientTransport) KeepalivePolicy {
+	policy := KeepalivePolicy{PongWait: cfg.PongWait, PingPeriod: cfg.PingPeriod, WriteWait: cfg.WriteWait}
+	switch transport {
+	case transportSSE:
+		policy.PingPeriod = min(policy.PingPeriod, sseKeepAliveInterval)
+	case transportLongPoll:
+		policy.PingPeriod = min(policy.PingPeriod, longPollTimeout)
+	}
+	if override, ok := cfg.Transports[transport.name()]; ok {
+		if override.PongWait > 0 {
+			policy.PongWait = override.PongWait
+		}
+		if override.PingPeriod > 0 {
+			policy.PingPeriod = override.PingPeriod
+		}
+		if override.WriteWait > 0 {
+			policy.WriteWait = override.WriteWait
+		}
+	}
+	return policy
+}
+
+// parseKeepaliveTransports parses a comma-separated list of
+// transport:pong_wait:ping_period:write_wait overrides, in seconds. Any
+// value may be left empty to inherit the global setting.
+func parseKeepaliveTransports(value string) map[string]KeepalivePolicy {
+	transports := make(map[string]KeepalivePolicy)
+	for _, part := range strings.Split(value, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ":")
+		if len(fields) != 4 {
+			log.Printf("Ignoring keepalive entry: expected transport:pong_wait:ping_period:write_wait")
+			continue
+		}
+		var policy KeepalivePolicy
+		for i, field := range []*time.Duration{&policy.PongWait, &policy.PingPeriod, &policy.WriteWait} {
+			if seconds := strings.TrimSpace(fields[i+1]); seconds != "" {
+				*field = parseDurationSeconds("KEEPALIVE_TRANSPORTS", seconds, 0)
+			}
+		}
+		transports[strings.TrimSpace(fields[0])] = policy
+	}
+	return transports
+}
@@ -0,0 +1,349 @@
+// Package server implements the HTTP long-polling fallback transport for
+// clients that support neither WebSockets nor Server-Sent Events. Each poller
+// holds a session registered with the hub; GET /poll waits for messages and
+// POST /messages sends them.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// longPollTimeout is the default for how long a poll is held open when
+	// no messages are waiting. It stays below the 30 second idle limit common
+	// to load balancers.
+	longPollTimeout = 25 * time.Second
+	// longPollMaxBatch caps how many messages a single poll returns.
+	longPollMaxBatch = 100
+)
+
+// longPollSession tracks the delivery cursor and idle expiry of one poller.
+type longPollSession struct {
+	mu      sync.Mutex
+	cursor  uint64
+	polling bool
+	idle    *time.Timer
+}
+
+// longPollEvent is a single message as delivered to a poller.
+type longPollEvent struct {
+	ID      uint64          `json:"id"`
+	Message json.RawMessage `json:"message"`
+}
+
+// longPollResponse is the body of every successful GET /poll.
+type longPollResponse struct {
+	SessionID string          `json:"session_id"`
+	Cursor    uint64          `json:"cursor"`
+	Messages  []longPollEvent `json:"messages"`
+}
+
+// LongPollHandler serves the long-polling transport.
+//
+// A request without a session parameter creates a new session, registers it
+// with the hub and returns immediately with its session_id and cursor. A
+// cursor parameter on that request replays retained history after it.
+//
+// A request with ?session=<id> waits up to 25 seconds (or ?timeout=<seconds>
+// if shorter) for messages and returns them along with the new cursor.
+// Passing back the cursor from the previous response lets the server resend
+// messages from a response that was lost in transit. Sessions expire after
+// 60 seconds without a poll.
+func LongPollHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed. Poll endpoint only accepts GET requests.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkStreamOrigin(r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	sessionID := r.URL.Query().Get("session")
+	if sessionID == "" {
+		startLongPollSession(w, r)
+		return
+	}
+
+	client, ok := hub.sessionClient(sessionID)
+	if !ok || client.transport != transportLongPoll {
+		http.Error(w, "Unknown or expired session", http.StatusUnauthorized)
+		return
+	}
+
+	servePoll(w, r, client)
+}
+
+// startLongPollSession creates and registers a new long-poll client.
+func startLongPollSession(w http.ResponseWriter, r *http.Request) {
+	admissionKey, ok := admit(w, r)
+	if !ok {
+		return
+	}
+
+	user, role, ok := authenticateClient(w, r)
+	if !ok {
+		admission.release(admissionKey)
+		return
+	}
+	if !checkReliable(w, r, user) {
+		admission.release(admissionKey)
+		return
+	}
+
+	sessionID, err := newSessionID()
+	if err != nil {
+		admission.release(admissionKey)
+		log.Printf("Failed to create long-poll session for %s: %v", clientAddr(r), err)
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	client := NewClient(nil, hub, clientAddr(r))
+	client.ip = clientIP(r)
+	client.transport = transportLongPoll
+	client.keepalive = currentConfig().Keepalive.policy(transportLongPoll)
+	client.sessionID = sessionID
+	client.poll = &longPollSession{cursor: hub.history.latestID()}
+	client.admissionKey = admissionKey
+	client.user = user
+	client.role = role
+	client.reliable = reliableRequested(r)
+
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		if id, err := strconv.ParseUint(cursor, 10, 64); err == nil {
+			client.resume = true
+			client.resumeAfter = id
+			client.poll.cursor = id
+		}
+	}
+	if !client.resume && (!client.reliable || user == "") {
+		// Replay whatever is broadcast after the cursor was read but before
+		// the hub registers the client. Reliable users are replayed from
+		// their ack cursor instead.
+		client.resume = true
+		client.resumeAfter = client.poll.cursor
+	}
+
+	client.hub.register <- client
+	client.poll.armIdleTimer(client)
+
+	writeLongPollResponse(w, longPollResponse{
+		SessionID: sessionID,
+		Cursor:    client.poll.cursor,
+		Messages:  []longPollEvent{},
+	})
+}
+
+// servePoll waits for messages for an existing session and writes them.
+func servePoll(w http.ResponseWriter, r *http.Request, client *Client) {
+	session := client.poll
+	if !session.begin() {
+		http.Error(w, "A poll is already in progress for this session", http.StatusConflict)
+		return
+	}
+	defer session.end(client)
+	client.hub.deliverInbox(client)
+
+	wait := pollTimeout(r, client.keepalive.PingPeriod)
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Now().Add(wait + client.keepalive.WriteWait)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("Error setting long-poll write deadline for %s: %v", client.addr, err)
+	}
+
+	events := session.resend(r, client)
+	events, open := appendQueued(events, r, client, wait)
+	if !open {
+		http.Error(w, "Session closed", http.StatusGone)
+		return
+	}
+
+	// Server events carry id 0, so the cursor follows the highest id seen.
+	for _, event := range events {
+		session.advance(event.ID)
+	}
+
+	writeLongPollResponse(w, longPollResponse{
+		SessionID: client.sessionID,
+		Cursor:    session.currentCursor(),
+		Messages:  events,
+	})
+}
+
+// pollTimeout returns how long to hold the poll open, at most limit,
+// honouring a shorter timeout requested by the client.
+func pollTimeout(r *http.Request, limit time.Duration) time.Duration {
+	seconds, err := strconv.Atoi(r.URL.Query().Get("timeout"))
+	if err != nil || seconds <= 0 {
+		return limit
+	}
+	if requested := time.Duration(seconds) * time.Second; requested < limit {
+		return requested
+	}
+	return limit
+}
+
+// resend returns retained messages after the cursor supplied by the client
+// when it is behind the server's cursor, which happens when the previous
+// response never reached the client.
+func (s *longPollSession) resend(r *http.Request, client *Client) []longPollEvent {
+	cursor := r.URL.Query().Get("cursor")
+	if cursor == "" {
+		return nil
+	}
+	clientCursor, err := strconv.ParseUint(cursor, 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	serverCursor := s.currentCursor()
+	if clientCursor >= serverCursor {
+		return nil
+	}
+
+	var events []longPollEvent
+	for _, entry := range client.hub.history.since(clientCursor) {
+		if entry.ID > serverCursor || len(events) == longPollMaxBatch {
+			break
+		}
+		events = append(events, newLongPollEvent(entry))
+	}
+	if len(events) > 0 {
+		log.Printf("Resent %d messages to long-poll session from %s", len(events), client.addr)
+	}
+	return events
+}
+
+// appendQueued waits for queued messages, or returns immediately if events
+// already holds some, and reports whether the session is still open.
+func appendQueued(events []longPollEvent, r *http.Request, client *Client, wait time.Duration) ([]longPollEvent, bool) {
+	if len(events) == 0 {
+		timeout := time.NewTimer(wait)
+		defer timeout.Stop()
+
+		select {
+		case <-r.Context().Done():
+			return events, true
+		case <-timeout.C:
+			return events, true
+		case frame, ok := <-client.send:
+			if !ok {
+				return events, false
+			}
+			events = appendLongPollFrame(events, frame)
+		}
+	}
+
+	for len(events) < longPollMaxBatch {
+		select {
+		case frame, ok := <-client.send:
+			if !ok {
+				return events, len(events) > 0
+			}
+			events = appendLongPollFrame(events, frame)
+		default:
+			return events, true
+		}
+	}
+	return events, true
+}
+
+func appendLongPollFrame(events []longPollEvent, frame []byte) []longPollEvent {
+	var event longPollEvent
+	if err := json.Unmarshal(frame, &event); err != nil {
+		log.Printf("Dropping malformed long-poll frame: %v", err)
+		return events
+	}
+	return append(events, event)
+}
+
+func writeLongPollResponse(w http.ResponseWriter, response longPollResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error writing long-poll response: %v", err)
+	}
+}
+
+// newLongPollEvent wraps a history entry for delivery to a poller.
+func newLongPollEvent(entry historyEntry) longPollEvent {
+	return longPollEvent{ID: entry.ID, Message: jsonPayload(entry.Payload)}
+}
+
+// encodeLongPollEvent is the send-channel frame format for long-poll clients.
+func encodeLongPollEvent(entry historyEntry) []byte {
+	frame, err := json.Marshal(newLongPollEvent(entry))
+	if err != nil {
+		log.Printf("Error encoding long-poll event %d: %v", entry.ID, err)
+		return nil
+	}
+	return frame
+}
+
+// begin marks a poll as in progress and pauses the idle timer. It returns
+// false if another poll is already waiting on this session.
+func (s *longPollSession) begin() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.polling {
+		return false
+	}
+	s.polling = true
+	if s.idle != nil {
+		s.idle.Stop()
+	}
+	return true
+}
+
+// inProgress reports whether a poll is waiting on this session.
+func (s *longPollSession) inProgress() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.polling
+}
+
+// end marks the poll as finished and restarts the idle timer.
+func (s *longPollSession) end(client *Client) {
+	s.mu.Lock()
+	s.polling = false
+	s.mu.Unlock()
+	s.armIdleTimer(client)
+}
+
+// armIdleTimer (re)starts the timer that expires the session when no poll
+// arrives in time.
+func (s *longPollSession) armIdleTimer(client *Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.idle != nil {
+		s.idle.Stop()
+	}
+	s.idle = time.AfterFunc(client.keepalive.PongWait, func() {
+		log.Printf("Long-poll session from %s expired", client.addr)
+		client.hub.leave(client)
+	})
+}
+
+func (s *longPollSession) advance(id uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id > s.cursor {
+		s.cursor = id
+	}
+}
+
+func (s *longPollSession) currentCursor() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cursor
+}
@@ -0,0 +1,231 @@
+// Package server dispatches the frames clients send. A frame without a type
+// is a chat message; typed frames are commands such as moderation actions.
+// Commands that fail are answered with an error event sent only to the
+// client that issued them.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// clientEnvelope holds the fields any client frame may carry. Type selects
+// how the frame is handled; the remaining fields are command arguments.
+type clientEnvelope struct {
+	Type     string `json:"type"`
+	ID       uint64 `json:"id"`
+	Content  string `json:"content"`
+	Target   string `json:"target"`
+	Reason   string `json:"reason"`
+	Duration string `json:"duration"`
+	Interval string `json:"interval"`
+	Emoji    string `json:"emoji"`
+	Room     string `json:"room"`
+	ClientID string `json:"client_id"`
+}
+
+// Frame types accepted from clients.
+const (
+	frameMessage  = "message"
+	frameMute     = "mute"
+	frameUnmute   = "unmute"
+	frameKick     = "kick"
+	frameBan      = "ban"
+	frameUnban    = "unban"
+	frameSlowMode = "slow_mode"
+	frameEdit     = "edit"
+	frameDelete   = "delete"
+	frameThread   = "thread"
+	frameReact    = "react"
+	frameUnreact  = "unreact"
+)
+
+// messageError is a rejected client frame. Status is the HTTP status used
+// when the frame arrived over POST /messages; Code identifies the error in
+// error events.
+type messageError struct {
+	status     int
+	code       string
+	message    string
+	retryAfter time.Duration
+}
+
+func (e *messageError) Error() string {
+	return e.message
+}
+
+// Is matches errors by code so that errors carrying a retry delay compare
+// equal to the sentinel they were derived from.
+func (e *messageError) Is(target error) bool {
+	t, ok := target.(*messageError)
+	return ok && t.code == e.code
+}
+
+var (
+	errInvalidMessage  = &messageError{status: http.StatusBadRequest, code: "invalid_message", message: "Invalid message"}
+	errUnknownCommand  = &messageError{status: http.StatusBadRequest, code: "unknown_command", message: "Unknown message type"}
+	errInvalidDuration = &messageError{status: http.StatusBadRequest, code: "invalid_duration", message: "Invalid duration"}
+	errForbidden       = &messageError{status: http.StatusForbidden, code: "forbidden", message: "Not permitted"}
+	errUnknownUser     = &messageError{status: http.StatusNotFound, code: "unknown_user", message: "Unknown user"}
+	errNotFound        = &messageError{status: http.StatusNotFound, code: "not_found", message: "No such mute or ban"}
+	errMuted           = &messageError{status: http.StatusForbidden, code: "muted", message: "You are muted"}
+	errSlowMode        = &messageError{status: http.StatusTooManyRequests, code: "slow_mode", message: "Slow mode is enabled"}
+	errRateLimited     = &messageError{status: http.StatusTooManyRequests, code: "rate_limited", message: "Rate limit exceeded"}
+	errMessageNotFound = &messageError{status: http.StatusNotFound, code: "message_not_found", message: "Message not found"}
+	errEmptyEdit       = &messageError{status: http.StatusBadRequest, code: "empty_edit", message: "Edited content cannot be empty"}
+	errInvalidEmoji    = &messageError{status: http.StatusBadRequest, code: "invalid_emoji", message: "Invalid emoji"}
+	errReactionLimit   = &messageError{status: http.StatusConflict, code: "reaction_limit", message: "Too many distinct reactions on this message"}
+	errNoReaction      = &messageError{status: http.StatusNotFound, code: "reaction_not_found", message: "You have not reacted with this emoji"}
+	errUnknownRoom     = &messageError{status: http.StatusNotFound, code: "unknown_room", message: "Room not found"}
+	errInvalidClientID = &messageError{status: http.StatusBadRequest, code: "invalid_client_id", message: "Client id is too long"}
+)
+
+// errorEvent tells a client why its frame was rejected.
+type errorEvent struct {
+	Type       string `json:"type"`
+	Code       string `json:"code"`
+	Error      string `json:"error"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+// processMessage validates a raw client frame and acts on it: chat messages
+// are normalized and broadcast, commands are executed.
+func (c *Client) processMessage(rawMessage []byte) error {
+	var envelope clientEnvelope
+	if err := json.Unmarshal(rawMessage, &envelope); err != nil {
+		log.Printf("Invalid message from %s: %v", c.addr, err)
+		return errInvalidMessage
+	}
+
+	switch envelope.Type {
+	case "", frameMessage:
+		return c.sendChat(rawMessage, envelope.ClientID)
+	case frameMute, frameUnmute, frameKick, frameBan, frameUnban, frameSlowMode:
+		return c.hub.moderate(c, envelope)
+	case frameEdit:
+		return c.hub.editMessage(c, envelope.ID, envelope.Content)
+	case frameDelete:
+		return c.hub.deleteMessage(c, envelope.ID)
+	case frameThread:
+		return c.hub.sendThread(c, envelope.ID)
+	case frameReact:
+		return c.hub.react(c, envelope.ID, envelope.Emoji)
+	case frameUnreact:
+		return c.hub.unreact(c, envelope.ID, envelope.Emoji)
+	case frameTypingStart:
+		return c.hub.startTyping(c)
+	case frameTypingStop:
+		c.hub.stopTyping(c)
+		return nil
+	case frameRead:
+		return c.hub.markRead(c, envelope.Room, envelope.Target, envelope.ID)
+	case frameAck:
+		return c.hub.acknowledge(c, envelope.ID)
+	case frameDirect:
+		return c.hub.sendDirect(c, envelope)
+	default:
+		return errUnknownCommand
+	}
+}
+
+// sendChat broadcasts a chat message, attributing it to the client's user
+// if it authenticated. Messages with a client id are acked to the sender,
+// and a retransmission of an acked client id is only acked again.
+func (c *Client) sendChat(rawMessage []byte, clientID string) error {
+	if len(clientID) > maxClientIDBytes {
+		return errInvalidClientID
+	}
+	if clientID != "" {
+		if id, seen := c.hub.delivery.lookup(c.identity(), clientID); seen {
+			log.Printf("Dropped duplicate message %q from %s", clientID, c.addr)
+			if id != 0 {
+				c.hub.sendAck(c, clientID, id, true)
+			}
+			return nil
+		}
+	}
+
+	if err := c.hub.moderation.checkSend(c); err != nil {
+		log.Printf("Message from %s refused: %v", c.addr, err)
+		return err
+	}
+
+	msg, err := normalizeMessage(rawMessage, c.user)
+	if err != nil {
+		log.Printf("Invalid message from %s: %v", c.addr, err)
+		return errInvalidMessage
+	}
+	if err := c.hub.resolveReply(msg); err != nil {
+		return err
+	}
+
+	log.Printf("Received message from %s: %q", c.addr, msg.Content)
+	if clientID != "" {
+		c.hub.submitChat(c, msg, clientID)
+	} else {
+		c.hub.broadcast <- BroadcastMessage{Sender: c, Message: msg}
+	}
+	c.hub.stopTyping(c)
+	return nil
+}
+
+// reportError sends an error event for a rejected frame to the client.
+// Malformed frames are dropped silently, as they always have been.
+func (c *Client) reportError(err error) {
+	var msgErr *messageError
+	if !errors.As(err, &msgErr) || errors.Is(err, errInvalidMessage) {
+		return
+	}
+
+	event := errorEvent{Type: "error", Code: msgErr.code, Error: msgErr.message}
+	if msgErr.retryAfter > 0 {
+		event.RetryAfter = retryAfterSeconds(msgErr.retryAfter)
+	}
+	payload, marshalErr := json.Marshal(event)
+	if marshalErr != nil {
+		log.Printf("Error encoding error event for %s: %v", c.addr, marshalErr)
+		return
+	}
+	c.hub.sendEvent(c, payload)
+}
+
+// writeMessageError answers a rejected POST /messages request.
+func writeMessageError(w http.ResponseWriter, err error) {
+	var msgErr *messageError
+	if !errors.As(err, &msgErr) {
+		http.Error(w, "Invalid message", http.StatusBadRequest)
+		return
+	}
+	if msgErr.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(msgErr.retryAfter)))
+	}
+	http.Error(w, msgErr.message, msgErr.status)
+}
+
+// retryAfterSeconds rounds a delay up to whole seconds, as used by the
+// Retry-After header.
+func retryAfterSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// sendEvent queues a server event for a single client. Events are not part
+// of the message history and carry no id.
+func (h *Hub) sendEvent(client *Client, payload []byte) {
+	if !h.safeSend(client, client.frame(historyEntry{Payload: payload})) {
+		log.Printf("Dropped event for %s: send buffer full or client gone", client.addr)
+	}
+}
+
+// broadcastEvent sends a server event to every client, including the one
+// that caused it. Events skip the history and webhooks.
+func (h *Hub) broadcastEvent(payload []byte) {
+	select {
+	case h.broadcast <- BroadcastMessage{Payload: payload, Event: true}:
+	case <-h.ctx.Done():
+	}
+}
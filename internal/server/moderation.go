@@ -0,0 +1,281 @@
+// Package server implements moderation commands. Moderators and owners can
+// mute, kick and ban users of a lower role, mute and kick guest connections,
+// and set slow mode for the room.
+// Every action is announced to the room and written to the audit log.
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// slowModePruneThreshold is how many send times are tracked before entries
+// older than the slow mode interval are dropped.
+const slowModePruneThreshold = 1024
+
+// moderationState holds the runtime mutes and slow mode setting of a hub.
+// A zero mute expiry means the mute lasts until it is lifted.
+type moderationState struct {
+	mu          sync.Mutex
+	mutes       map[string]time.Time
+	slowMode    time.Duration
+	lastMessage map[string]time.Time
+}
+
+func newModerationState() *moderationState {
+	return &moderationState{
+		mutes:       make(map[string]time.Time),
+		lastMessage: make(map[string]time.Time),
+	}
+}
+
+// moderationEvent announces a moderation action to every client.
+type moderationEvent struct {
+	Type      string     `json:"type"`
+	Action    string     `json:"action"`
+	Actor     string     `json:"actor"`
+	Target    string     `json:"target,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Interval  string     `json:"interval,omitempty"`
+}
+
+// checkSend refuses a chat message from a muted user or one sent sooner than
+// the slow mode interval allows. Moderators and owners are exempt from slow
+// mode. This is applied on top of the per-connection rate limiter.
+func (m *moderationState) checkSend(c *Client) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if m.mutedLocked(c, now) {
+		return errMuted
+	}
+
+	if m.slowMode <= 0 || c.role.canModerate() {
+		return nil
+	}
+
+	identity := c.identity()
+	if last, ok := m.lastMessage[identity]; ok {
+		if wait := m.slowMode - now.Sub(last); wait > 0 {
+			err := *errSlowMode
+			err.retryAfter = wait
+			return &err
+		}
+	}
+	m.lastMessage[identity] = now
+	if len(m.lastMessage) > slowModePruneThreshold {
+		for key, last := range m.lastMessage {
+			if now.Sub(last) >= m.slowMode {
+				delete(m.lastMessage, key)
+			}
+		}
+	}
+	return nil
+}
+
+// checkMuted refuses changes to existing messages from a muted user.
+func (m *moderationState) checkMuted(c *Client) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.mutedLocked(c, time.Now()) {
+		return errMuted
+	}
+	return nil
+}
+
+// mutedLocked reports whether the client's identity is muted. Guests are
+// muted by their per-connection label, so a mute ends when they reconnect.
+func (m *moderationState) mutedLocked(c *Client, now time.Time) bool {
+	identity := c.identity()
+	expires, muted := m.mutes[identity]
+	if !muted {
+		return false
+	}
+	if expires.IsZero() || now.Before(expires) {
+		return true
+	}
+	delete(m.mutes, identity)
+	return false
+}
+
+func (m *moderationState) mute(name string, expires time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mutes[name] = expires
+}
+
+func (m *moderationState) unmute(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, muted := m.mutes[name]
+	delete(m.mutes, name)
+	return muted
+}
+
+func (m *moderationState) setSlowMode(interval time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.slowMode = interval
+	m.lastMessage = make(map[string]time.Time)
+}
+
+// moderate executes a moderation command issued by actor.
+func (h *Hub) moderate(actor *Client, cmd clientEnvelope) error {
+	if !actor.role.canModerate() {
+		log.Printf("Refused %s command from %s (%s)", cmd.Type, actor.identity(), actor.role)
+		return errForbidden
+	}
+
+	if cmd.Type == frameSlowMode {
+		return h.applySlowMode(actor, cmd)
+	}
+
+	target, err := h.moderationTarget(cmd)
+	if err != nil {
+		return err
+	}
+	cmd.Target = target
+	if actor.role.rank() <= userRole(cmd.Target).rank() {
+		log.Printf("Refused %s of %s by %s: insufficient role", cmd.Type, cmd.Target, actor.identity())
+		return errForbidden
+	}
+
+	duration, err := parseCommandDuration(cmd.Duration)
+	if err != nil {
+		return err
+	}
+
+	event := moderationEvent{Type: "moderation", Action: cmd.Type, Actor: actor.identity(), Target: cmd.Target, Reason: cmd.Reason}
+	if duration > 0 && (cmd.Type == frameMute || cmd.Type == frameBan) {
+		expires := time.Now().Add(duration).UTC()
+		event.ExpiresAt = &expires
+	}
+
+	switch cmd.Type {
+	case frameMute:
+		var expires time.Time
+		if event.ExpiresAt != nil {
+			expires = *event.ExpiresAt
+		}
+		h.moderation.mute(cmd.Target, expires)
+	case frameUnmute:
+		if !h.moderation.unmute(cmd.Target) {
+			return errNotFound
+		}
+	case frameKick:
+		h.disconnectUser(cmd.Target, closeReason("kicked", cmd.Reason))
+	case frameBan:
+		ban, err := bans.addUser(cmd.Target, cmd.Reason, duration)
+		if err != nil {
+			// The ban is active in memory even if it could not be persisted.
+			log.Printf("Failed to persist ban on user %s: %v", ban.User, err)
+		}
+		h.disconnectUser(cmd.Target, closeReason("banned", cmd.Reason))
+	case frameUnban:
+		removed, err := bans.removeUser(cmd.Target)
+		if err != nil {
+			log.Printf("Failed to persist removal of ban on user %s: %v", cmd.Target, err)
+		}
+		if !removed {
+			return errNotFound
+		}
+	}
+
+	log.Printf("Moderation: %s %s %s (%s)", actor.identity(), cmd.Type, cmd.Target, cmd.Reason)
+	recordAudit(auditRecord{Action: cmd.Type, Actor: actor.identity(), IP: actor.ip, Target: cmd.Target, Reason: cmd.Reason, Duration: cmd.Duration})
+	h.announce(event)
+	return nil
+}
+
+// moderationTarget resolves the identity a command targets: the named user,
+// or the author of the retained message with the command's id. Guests have
+// no name that outlives their connection, so they can only be targeted
+// through a message they sent, and can only be muted, unmuted or kicked.
+func (h *Hub) moderationTarget(cmd clientEnvelope) (string, error) {
+	if cmd.Target != "" {
+		if userRole(cmd.Target) == RoleGuest {
+			return "", errUnknownUser
+		}
+		return cmd.Target, nil
+	}
+	if cmd.ID == 0 {
+		return "", errUnknownUser
+	}
+	entry, ok := h.history.get(cmd.ID)
+	if !ok {
+		return "", errMessageNotFound
+	}
+	if userRole(entry.Author) != RoleGuest {
+		return entry.Author, nil
+	}
+	if !strings.HasPrefix(entry.Author, guestNamePrefix) || cmd.Type == frameBan || cmd.Type == frameUnban {
+		return "", errUnknownUser
+	}
+	return entry.Author, nil
+}
+
+// applySlowMode sets or, with an empty or zero interval, clears slow mode.
+func (h *Hub) applySlowMode(actor *Client, cmd clientEnvelope) error {
+	interval, err := parseCommandDuration(cmd.Interval)
+	if err != nil {
+		return err
+	}
+	h.moderation.setSlowMode(interval)
+
+	event := moderationEvent{Type: "moderation", Action: frameSlowMode, Actor: actor.identity(), Reason: cmd.Reason}
+	if interval > 0 {
+		event.Interval = interval.String()
+	}
+	log.Printf("Moderation: %s set slow mode to %s", actor.identity(), interval)
+	recordAudit(auditRecord{Action: frameSlowMode, Actor: actor.identity(), IP: actor.ip, Reason: cmd.Reason, Duration: interval.String()})
+	h.announce(event)
+	return nil
+}
+
+// disconnectUser closes every connection of a user, or the guest connection
+// with that label, with the given reason.
+func (h *Hub) disconnectUser(name, reason string) int {
+	disconnected := h.disconnectWhere(func(client *Client) bool {
+		return client.identity() == name
+	}, websocket.ClosePolicyViolation, reason)
+	if disconnected > 0 {
+		log.Printf("Disconnected %d clients of user %s: %s", disconnected, name, reason)
+	}
+	return disconnected
+}
+
+func (h *Hub) announce(event moderationEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding moderation event: %v", err)
+		return
+	}
+	h.broadcastEvent(payload)
+}
+
+// closeReason formats the reason sent in a policy-violation close frame.
+func closeReason(action, reason string) string {
+	if reason == "" {
+		return action
+	}
+	return action + ": " + reason
+}
+
+// parseCommandDuration parses an optional Go duration argument.
+func parseCommandDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return 0, errInvalidDuration
+	}
+	return duration, nil
+}
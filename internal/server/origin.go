@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -82,5 +83,26 @@ func checkOrigin(r *http.Request) bool {
 	}
 
 	log.Printf("Blocked WebSocket connection from disallowed origin: %q", r.Header.Get("Origin"))
+	auditRefusal(r, auditOriginRejected, "", "origin "+strconv.Quote(r.Header.Get("Origin")))
 	return false
 }
+
+// checkStreamOrigin is checkOrigin for streaming GET requests. Browsers do
+// not send an Origin header on a same-origin EventSource or fetch GET, so a
+// missing Origin, or one naming the requested host, is accepted as well.
+func checkStreamOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || isSameOrigin(r, origin) {
+		return true
+	}
+	return checkOrigin(r)
+}
+
+// isSameOrigin reports whether origin names the host the request was sent to.
+func isSameOrigin(r *http.Request, origin string) bool {
+	parsed, err := url.Parse(origin)
+	if err != nil || parsed.Host == "" {
+		return false
+	}
+	return strings.EqualFold(parsed.Host, r.Host)
+}
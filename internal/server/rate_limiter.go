@@ -13,6 +13,7 @@ type rateLimiter struct {
 	capacity  float64
 	rate      float64
 	lastCheck time.Time
+	throttled bool
 }
 
 func newRateLimiter(capacity int, interval time.Duration) *rateLimiter {
@@ -39,7 +40,24 @@ func newRateLimiter(capacity int, interval time.Duration) *rateLimiter {
 func (rl *rateLimiter) allow() bool {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
+	return rl.allowLocked()
+}
+
+// throttle is allow for callers that report refusals. A throttling episode
+// starts with the first refusal and lasts until a message is allowed again;
+// started is true only for the refusal that starts one, so that each episode
+// is reported once however many messages it drops.
+func (rl *rateLimiter) throttle() (allowed, started bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	allowed = rl.allowLocked()
+	started = !allowed && !rl.throttled
+	rl.throttled = !allowed
+	return allowed, started
+}
 
+func (rl *rateLimiter) allowLocked() bool {
 	now := time.Now()
 	elapsed := now.Sub(rl.lastCheck).Seconds()
 	rl.lastCheck = now
@@ -58,3 +76,14 @@ func (rl *rateLimiter) allow() bool {
 	rl.tokens--
 	return true
 }
+
+// retryAfter returns how long until the next token is available.
+func (rl *rateLimiter) retryAfter() time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - rl.tokens) / rl.rate * float64(time.Second))
+}
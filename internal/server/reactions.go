@@ -0,0 +1,184 @@
+// Package server implements emoji reactions. Clients react to and unreact
+// from messages by id; the history keeps who reacted with what, the message
+// payload carries the aggregate counts so that replay includes them, and
+// every change is broadcast to the room as a delta.
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxEmojiBytes bounds the length of a reaction, which leaves room for
+// multi-codepoint emoji such as flags and skin-tone sequences.
+const maxEmojiBytes = 32
+
+// eventReaction is the type of reaction delta events.
+const eventReaction = "reaction"
+
+// reactionEvent tells clients that a reaction was added (delta 1) or
+// removed (delta -1) and what the emoji's count is now.
+type reactionEvent struct {
+	Type  string `json:"type"`
+	ID    uint64 `json:"id"`
+	Emoji string `json:"emoji"`
+	Delta int    `json:"delta"`
+	Count int    `json:"count"`
+	User  string `json:"user,omitempty"`
+}
+
+// react adds the client's reaction to a message. Reacting twice with the
+// same emoji has no effect.
+func (h *Hub) react(c *Client, id uint64, emoji string) error {
+	return h.changeReaction(c, id, emoji, 1)
+}
+
+// unreact removes the client's reaction from a message.
+func (h *Hub) unreact(c *Client, id uint64, emoji string) error {
+	return h.changeReaction(c, id, emoji, -1)
+}
+
+func (h *Hub) changeReaction(c *Client, id uint64, emoji string, delta int) error {
+	if !validEmoji(emoji) {
+		return errInvalidEmoji
+	}
+	if err := h.moderation.checkMuted(c); err != nil {
+		return err
+	}
+
+	identity := c.identity()
+	limit := currentConfig().MaxReactions
+	changed := false
+	count := 0
+	_, found, err := h.history.update(id, func(entry *historyEntry) error {
+		msg, ok := decodeEntry(*entry)
+		if !ok || msg.Deleted {
+			return errMessageNotFound
+		}
+
+		reactions, n, err := applyReaction(entry.Reactions, emoji, identity, delta, limit)
+		if err != nil || n == reactionCount(entry.Reactions, emoji) {
+			count = n
+			return err
+		}
+
+		msg.Reactions = reactionCounts(reactions)
+		payload, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		entry.Reactions = reactions
+		entry.Payload = payload
+		changed = true
+		count = n
+		return nil
+	})
+	if !found {
+		return errMessageNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+
+	log.Printf("Reaction %q on message %d by %s: %+d", emoji, id, identity, delta)
+	payload, err := json.Marshal(reactionEvent{Type: eventReaction, ID: id, Emoji: emoji, Delta: delta, Count: count, User: c.user})
+	if err != nil {
+		log.Printf("Error encoding reaction event for message %d: %v", id, err)
+		return nil
+	}
+	h.broadcastEvent(payload)
+	return nil
+}
+
+// applyReaction returns a copy of reactions with identity's reaction added
+// or removed, and the resulting count for emoji. Adding an existing reaction
+// leaves the count unchanged. The input slice is never modified, as it is
+// shared with readers of the history.
+func applyReaction(reactions []reaction, emoji, identity string, delta, limit int) ([]reaction, int, error) {
+	i := -1
+	for j, r := range reactions {
+		if r.emoji == emoji {
+			i = j
+			break
+		}
+	}
+
+	if delta > 0 {
+		if i < 0 {
+			if len(reactions) >= limit {
+				return nil, 0, errReactionLimit
+			}
+			updated := append(append([]reaction(nil), reactions...), reaction{emoji: emoji, users: []string{identity}})
+			return updated, 1, nil
+		}
+		for _, user := range reactions[i].users {
+			if user == identity {
+				return reactions, len(reactions[i].users), nil
+			}
+		}
+		updated := append([]reaction(nil), reactions...)
+		updated[i].users = append(append([]string(nil), reactions[i].users...), identity)
+		return updated, len(updated[i].users), nil
+	}
+
+	if i < 0 {
+		return nil, 0, errNoReaction
+	}
+	users := make([]string, 0, len(reactions[i].users))
+	for _, user := range reactions[i].users {
+		if user != identity {
+			users = append(users, user)
+		}
+	}
+	if len(users) == len(reactions[i].users) {
+		return nil, 0, errNoReaction
+	}
+
+	updated := append([]reaction(nil), reactions[:i]...)
+	if len(users) > 0 {
+		updated = append(updated, reaction{emoji: emoji, users: users})
+	}
+	updated = append(updated, reactions[i+1:]...)
+	return updated, len(users), nil
+}
+
+// reactionCount returns how many clients reacted with emoji.
+func reactionCount(reactions []reaction, emoji string) int {
+	for _, r := range reactions {
+		if r.emoji == emoji {
+			return len(r.users)
+		}
+	}
+	return 0
+}
+
+// reactionCounts aggregates reactions for the message payload.
+func reactionCounts(reactions []reaction) []ReactionCount {
+	if len(reactions) == 0 {
+		return nil
+	}
+	counts := make([]ReactionCount, len(reactions))
+	for i, r := range reactions {
+		counts[i] = ReactionCount{Emoji: r.emoji, Count: len(r.users)}
+	}
+	return counts
+}
+
+// validEmoji accepts a short printable string. Reactions are not checked
+// against an emoji list, so clients may use custom shortcodes.
+func validEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > maxEmojiBytes || !utf8.ValidString(emoji) {
+		return false
+	}
+	for _, r := range emoji {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,214 @@
+// Package server implements read markers and read receipts. Each user has a
+// marker per room and per direct message conversation holding the id of the
+// last message they read. Markers only move forward; when one moves in a
+// small room, the other clients get a read receipt, and when one moves in a
+// conversation, the other participant does. Unread counts derived from the
+// room markers are part of the presence snapshot sent to authenticated
+// clients when they connect.
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+)
+
+// Read frame and event types.
+const (
+	frameRead        = "read"
+	eventReadReceipt = "read_receipt"
+	eventPresence    = "presence"
+)
+
+// readMarkers holds the last read message id of each user in each room.
+type readMarkers struct {
+	mu      sync.Mutex
+	markers map[string]map[string]uint64
+}
+
+func newReadMarkers() *readMarkers {
+	return &readMarkers{markers: make(map[string]map[string]uint64)}
+}
+
+// advance moves the marker of user in room to id and reports whether it
+// moved. Markers never move backwards.
+func (m *readMarkers) advance(room, user string, id uint64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	users, ok := m.markers[room]
+	if !ok {
+		users = make(map[string]uint64)
+		m.markers[room] = users
+	}
+	if users[user] >= id {
+		return false
+	}
+	users[user] = id
+	return true
+}
+
+// get returns the marker of user in room, or 0 if they have read nothing.
+func (m *readMarkers) get(room, user string) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.markers[room][user]
+}
+
+// readReceiptEvent tells the other clients in a room, or the other
+// participant of a direct message conversation, how far a user has read.
+type readReceiptEvent struct {
+	Type string `json:"type"`
+	Room string `json:"room,omitempty"`
+	DM   bool   `json:"dm,omitempty"`
+	User string `json:"user"`
+	ID   uint64 `json:"id"`
+}
+
+// presenceEvent is the snapshot an authenticated client receives when it
+// connects: who is online, and how many messages it has not read in each
+// room along with its read marker there.
+type presenceEvent struct {
+	Type     string            `json:"type"`
+	Users    []string          `json:"users"`
+	Guests   int               `json:"guests"`
+	Unread   map[string]int    `json:"unread"`
+	LastRead map[string]uint64 `json:"last_read"`
+}
+
+// markRead handles a read frame, which moves the client's read marker in a
+// room, or in its direct message conversation with target, to a message id.
+// Guests have no markers.
+func (h *Hub) markRead(c *Client, room, target string, id uint64) error {
+	if c.user == "" {
+		return errForbidden
+	}
+	if target != "" {
+		return h.markDirectRead(c, target, id)
+	}
+	if room == "" {
+		room = DefaultRoom
+	}
+	if room != DefaultRoom {
+		return errUnknownRoom
+	}
+	if id == 0 || id > h.history.latestID() {
+		return errMessageNotFound
+	}
+
+	if !h.reads.advance(room, c.user, id) {
+		return nil
+	}
+	log.Printf("User %s read %s up to message %d", c.user, room, id)
+
+	if h.authenticatedCount() > currentConfig().ReceiptLimit {
+		return nil
+	}
+	payload, err := json.Marshal(readReceiptEvent{Type: eventReadReceipt, Room: room, User: c.user, ID: id})
+	if err != nil {
+		log.Printf("Error encoding read receipt for %s: %v", c.user, err)
+		return nil
+	}
+	select {
+	case h.broadcast <- BroadcastMessage{Sender: c, Payload: payload, Event: true}:
+	case <-h.ctx.Done():
+	}
+	return nil
+}
+
+// markDirectRead moves the client's marker in its conversation with target
+// and sends the read receipt to target's connections.
+func (h *Hub) markDirectRead(c *Client, target string, id uint64) error {
+	if target == c.user || userRole(target) == RoleGuest {
+		return errUnknownUser
+	}
+	if id == 0 || id > h.inboxes.latestInConversation(c.user, target) {
+		return errMessageNotFound
+	}
+
+	if !h.reads.advance(dmMarkerKey(target), c.user, id) {
+		return nil
+	}
+	log.Printf("User %s read direct messages with %s up to %d", c.user, target, id)
+
+	payload, err := json.Marshal(readReceiptEvent{Type: eventReadReceipt, DM: true, User: c.user, ID: id})
+	if err != nil {
+		log.Printf("Error encoding read receipt for %s: %v", c.user, err)
+		return nil
+	}
+	for _, client := range h.userClients(target) {
+		h.sendEvent(client, payload)
+	}
+	return nil
+}
+
+// dmMarkerKey is the marker key of a user's conversation with other. It
+// cannot collide with a room name, which never starts with "@".
+func dmMarkerKey(other string) string {
+	return "@" + other
+}
+
+// authenticatedCount counts the connected clients of authenticated users.
+// Guests receive read receipts but do not count towards ReceiptLimit.
+func (h *Hub) authenticatedCount() int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	count := 0
+	for client := range h.clients {
+		if client.user != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// unreadCount counts the retained messages in room after the user's read
+// marker, other than the user's own messages and tombstones.
+func (h *Hub) unreadCount(room, user string) int {
+	count := 0
+	for _, entry := range h.history.since(h.reads.get(room, user)) {
+		if entry.Author == user {
+			continue
+		}
+		if msg, ok := decodeEntry(entry); ok && msg.Deleted {
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+// sendPresence queues the presence snapshot for a newly registered client.
+// It runs on the hub goroutine before any history replay.
+func (h *Hub) sendPresence(client *Client) {
+	if client.user == "" {
+		return
+	}
+
+	event := presenceEvent{
+		Type:     eventPresence,
+		Users:    []string{},
+		Unread:   map[string]int{DefaultRoom: h.unreadCount(DefaultRoom, client.user)},
+		LastRead: map[string]uint64{DefaultRoom: h.reads.get(DefaultRoom, client.user)},
+	}
+	seen := make(map[string]bool)
+	for _, other := range h.getClientSnapshot() {
+		switch {
+		case other.user == "":
+			event.Guests++
+		case !seen[other.user]:
+			seen[other.user] = true
+			event.Users = append(event.Users, other.user)
+		}
+	}
+	sort.Strings(event.Users)
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding presence snapshot for %s: %v", client.addr, err)
+		return
+	}
+	h.sendEvent(client, payload)
+}
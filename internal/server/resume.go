@@ -0,0 +1,155 @@
+// Package server implements session resumption for WebSocket clients. A
+// client that connects with ?resumable=1 receives a resume token in a
+// session event. When its connection drops, the hub keeps the session
+// registered for a grace window: messages keep queuing for it and no leave
+// is announced. A connection that presents the token within the window takes
+// the session over, with its identity and the messages queued meanwhile.
+// Each token is good for one resumption; the new connection gets a fresh one.
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// eventSession is the type of the event that carries a resume token.
+const eventSession = "session"
+
+// sessionEvent gives a resumable client the token to resume with and the
+// grace window in seconds. Resumed reports whether the connection took over
+// a previous session.
+type sessionEvent struct {
+	Type        string `json:"type"`
+	ResumeToken string `json:"resume_token"`
+	Grace       int    `json:"grace"`
+	Resumed     bool   `json:"resumed"`
+}
+
+// resumeRequested reports whether a WebSocket connection asked for a
+// resumable session, and the token of the session it wants to resume.
+func resumeRequested(r *http.Request) (bool, string) {
+	query := r.URL.Query()
+	token := query.Get("resume_token")
+	value := query.Get("resumable")
+	return token != "" || value == "1" || value == "true", token
+}
+
+// takeOverSession returns the parked session a new client presented the
+// token of, after detaching it from the grace timer, or nil if there is none
+// the client may resume. The client inherits the session's identity. It runs
+// on the hub goroutine before the client is registered.
+func (h *Hub) takeOverSession(client *Client) *Client {
+	if client.resumeFrom == "" {
+		return nil
+	}
+	parked, ok := h.parked[client.resumeFrom]
+	if !ok {
+		log.Printf("Unknown or expired resume token from %s", client.addr)
+		return nil
+	}
+
+	h.mutex.RLock()
+	_, registered := h.clients[parked]
+	evicted := parked.evicted
+	h.mutex.RUnlock()
+	if !registered {
+		// The session overflowed its send buffer and was dropped.
+		log.Printf("Session of %s can no longer be resumed", parked.identity())
+		return nil
+	}
+	if evicted || parked.user != client.user {
+		log.Printf("Refused to resume session of %s for %s", parked.identity(), client.addr)
+		return nil
+	}
+
+	delete(h.parked, client.resumeFrom)
+	parked.parkTimer.Stop()
+	client.guestName = parked.guestName
+	return parked
+}
+
+// startSession issues a new resume token to a resumable client and, if it
+// took over a parked session, moves the messages queued for that session to
+// the client.
+func (h *Hub) startSession(client *Client, parked *Client) {
+	if client.readDone == nil {
+		return
+	}
+	token, err := newSessionID()
+	if err != nil {
+		log.Printf("Failed to create resume token for %s: %v", client.addr, err)
+		return
+	}
+	client.resumeToken = token
+
+	grace := currentConfig().ResumeGrace
+	payload, err := json.Marshal(sessionEvent{Type: eventSession, ResumeToken: token, Grace: retryAfterSeconds(grace), Resumed: parked != nil})
+	if err != nil {
+		log.Printf("Error encoding session event for %s: %v", client.addr, err)
+		return
+	}
+	h.sendEvent(client, payload)
+
+	if parked == nil {
+		return
+	}
+	h.typing.stop(parked)
+	// The old connection is closed, so its writePump stops promptly. Once it
+	// has, the frame it took but did not write, if any, goes first.
+	<-parked.writeDone
+	queued := 0
+	if parked.unsent != nil && h.safeSend(client, parked.unsent) {
+		queued++
+	}
+	for drained := false; !drained; {
+		select {
+		case message := <-parked.send:
+			if h.safeSend(client, message) {
+				queued++
+			}
+		default:
+			drained = true
+		}
+	}
+	close(parked.send)
+	log.Printf("Resumed session of %s from %s with %d queued messages", client.identity(), client.addr, queued)
+}
+
+// parkSession keeps the session of a resumable WebSocket client whose
+// connection dropped registered for the grace window, and reports whether
+// it did. Sessions of kicked or banned clients are not kept, nor are any
+// while the server drains or shuts down, since they could not be resumed
+// before it stops.
+func (h *Hub) parkSession(client *Client) bool {
+	if client == nil || client.resumeToken == "" || client.parked || h.draining.Load() || h.ctx.Err() != nil {
+		return false
+	}
+
+	h.mutex.RLock()
+	_, registered := h.clients[client]
+	evicted := client.evicted
+	h.mutex.RUnlock()
+	if !registered || evicted {
+		return false
+	}
+
+	grace := currentConfig().ResumeGrace
+	h.mutex.Lock()
+	client.parked = true
+	h.mutex.Unlock()
+	h.parked[client.resumeToken] = client
+	client.parkTimer = time.AfterFunc(grace, func() { h.leave(client) })
+	h.clearTyping(client)
+	log.Printf("Keeping session of %s from %s for %s", client.identity(), client.addr, grace)
+	return true
+}
+
+// forgetParked drops the parked entry of a session whose grace window ended.
+func (h *Hub) forgetParked(client *Client) {
+	if client != nil && client.parked && h.parked[client.resumeToken] == client {
+		delete(h.parked, client.resumeToken)
+		log.Printf("Resume window of %s expired", client.identity())
+	}
+}
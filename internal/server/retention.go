@@ -0,0 +1,177 @@
+// Package server enforces message retention. A janitor goroutine started
+// with the hub periodically prunes messages that are older than the maximum
+// age, or beyond the maximum count, of their room's retention policy. Rooms
+// may override the global policy, for example to keep their messages longer.
+package server
+
+import (
+	"log"
+	"maps"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RetentionPolicy limits how long messages are kept. A zero MaxAge or
+// MaxCount places no limit.
+type RetentionPolicy struct {
+	MaxAge   time.Duration
+	MaxCount int
+}
+
+// RetentionConfig holds the global retention policy, per-room overrides and
+// how often the janitor enforces them. Zero fields of an override inherit
+// the global value.
+type RetentionConfig struct {
+	MaxAge   time.Duration
+	MaxCount int
+	Rooms    map[string]RetentionPolicy
+	Interval time.Duration
+}
+
+func defaultRetentionConfig() RetentionConfig {
+	return RetentionConfig{Interval: time.Minute}
+}
+
+func sanitizeRetentionConfig(cfg RetentionConfig) RetentionConfig {
+	cfg.MaxAge = max(cfg.MaxAge, 0)
+	cfg.MaxCount = max(cfg.MaxCount, 0)
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultRetentionConfig().Interval
+	}
+	rooms := make(map[string]RetentionPolicy, len(cfg.Rooms))
+	for room, policy := range cfg.Rooms {
+		if room == "" {
+			log.Printf("Ignoring retention policy without a room name")
+			continue
+		}
+		rooms[room] = RetentionPolicy{MaxAge: max(policy.MaxAge, 0), MaxCount: max(policy.MaxCount, 0)}
+	}
+	cfg.Rooms = rooms
+	return cfg
+}
+
+func copyRetentionConfig(cfg RetentionConfig) RetentionConfig {
+	cfg.Rooms = maps.Clone(cfg.Rooms)
+	return cfg
+}
+
+// policy returns the retention policy in effect for room.
+func (cfg RetentionConfig) policy(room string) RetentionPolicy {
+	policy := RetentionPolicy{MaxAge: cfg.MaxAge, MaxCount: cfg.MaxCount}
+	if override, ok := cfg.Rooms[room]; ok {
+		if override.MaxAge > 0 {
+			policy.MaxAge = override.MaxAge
+		}
+		if override.MaxCount > 0 {
+			policy.MaxCount = override.MaxCount
+		}
+	}
+	return policy
+}
+
+// parseRetentionRooms parses a comma-separated list of room:max_age:max_count
+// overrides, with max_age in seconds. Either limit may be left empty to
+// inherit the global value.
+func parseRetentionRooms(value string) map[string]RetentionPolicy {
+	rooms := make(map[string]RetentionPolicy)
+	for _, part := range strings.Split(value, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ":")
+		if len(fields) != 3 {
+			log.Printf("Ignoring retention entry: expected room:max_age:max_count")
+			continue
+		}
+		var policy RetentionPolicy
+		if age := strings.TrimSpace(fields[1]); age != "" {
+			policy.MaxAge = parseDurationSeconds("RETENTION_ROOMS", age, 0)
+		}
+		if count := strings.TrimSpace(fields[2]); count != "" {
+			policy.MaxCount = parseIntValue(count, 0)
+		}
+		rooms[strings.TrimSpace(fields[0])] = policy
+	}
+	return rooms
+}
+
+// retentionChanged wakes the janitor when the configuration changes so that
+// a new interval takes effect immediately.
+var retentionChanged = make(chan struct{}, 1)
+
+func notifyRetentionChanged() {
+	select {
+	case retentionChanged <- struct{}{}:
+	default:
+	}
+}
+
+// retentionJanitor tracks the janitor goroutine of a hub and how many
+// messages it has pruned.
+type retentionJanitor struct {
+	once    sync.Once
+	started atomic.Bool
+	done    chan struct{}
+	pruned  atomic.Uint64
+}
+
+func newRetentionJanitor() *retentionJanitor {
+	return &retentionJanitor{done: make(chan struct{})}
+}
+
+// startJanitor starts the retention janitor. It runs until the hub shuts
+// down; later calls do nothing.
+func (h *Hub) startJanitor() {
+	h.janitor.once.Do(func() {
+		h.janitor.started.Store(true)
+		go h.runJanitor()
+	})
+}
+
+// stopJanitor waits for a started janitor to exit after the hub's context
+// was cancelled.
+func (h *Hub) stopJanitor() {
+	if !h.janitor.started.Load() {
+		return
+	}
+	<-h.janitor.done
+	log.Printf("Retention janitor stopped after pruning %d messages", h.janitor.pruned.Load())
+}
+
+func (h *Hub) runJanitor() {
+	defer close(h.janitor.done)
+
+	timer := time.NewTimer(currentConfig().Retention.Interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-retentionChanged:
+			timer.Reset(currentConfig().Retention.Interval)
+		case <-timer.C:
+			h.enforceRetention()
+			timer.Reset(currentConfig().Retention.Interval)
+		}
+	}
+}
+
+// enforceRetention prunes the messages that fall outside the retention
+// policy and returns how many were pruned.
+func (h *Hub) enforceRetention() int {
+	policy := currentConfig().Retention.policy(DefaultRoom)
+	if policy.MaxAge == 0 && policy.MaxCount == 0 {
+		return 0
+	}
+
+	var cutoff time.Time
+	if policy.MaxAge > 0 {
+		cutoff = time.Now().Add(-policy.MaxAge)
+	}
+	pruned := h.history.prune(cutoff, policy.MaxCount)
+	if pruned == 0 {
+		return 0
+	}
+	total := h.janitor.pruned.Add(uint64(pruned))
+	log.Printf("Retention pruned %d messages from %s (%d in total)", pruned, DefaultRoom, total)
+	return pruned
+}
@@ -5,11 +5,24 @@ package server
 import "net/http"
 
 // SetupRoutes configures and returns an HTTP ServeMux with all application routes.
-// It sets up handlers for health check, WebSocket endpoint, and test page.
+// It sets up handlers for health check, WebSocket endpoint, the SSE and
+// long-polling fallback transports, the integration ingestion, history and
+// search APIs, the admin API, and test page.
 func SetupRoutes() *http.ServeMux {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", HealthHandler)
+	mux.HandleFunc("/{$}", HealthHandler)
+	mux.HandleFunc("/healthz", LivenessHandler)
+	mux.HandleFunc("/readyz", ReadinessHandler)
 	mux.HandleFunc("/ws", WebSocketHandler)
+	mux.HandleFunc("/sse", SSEHandler)
+	mux.HandleFunc("/messages", PostMessageHandler)
+	mux.HandleFunc("/poll", LongPollHandler)
+	mux.HandleFunc("/api/v1/rooms/{room}/messages", RoomMessagesHandler)
+	mux.HandleFunc("/api/v1/search", SearchHandler)
+	mux.HandleFunc("/admin/bans", BansHandler)
+	mux.HandleFunc("/admin/inboxes", InboxesHandler)
+	mux.HandleFunc("/admin/export", ExportHandler)
+	mux.HandleFunc("/admin/import", ImportHandler)
 	mux.HandleFunc("/test", TestPageHandler)
 	return mux
 }
@@ -0,0 +1,398 @@
+// Package server implements full-text search over the message history. An
+// inverted index maps each word to the ids of the messages containing it; the
+// history updates it under its own lock as messages are broadcast, edited,
+// deleted and evicted, so it always covers exactly the in-memory history: at
+// most HistorySize messages (100 by default). Nothing older can be found, and
+// since the history is not persisted, the index starts empty after a restart.
+package server
+
+import (
+	"encoding/json"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// defaultSearchLimit is the number of search results per page unless the
+// request asks for another.
+const defaultSearchLimit = 20
+
+var (
+	errMissingQuery  = &messageError{status: http.StatusBadRequest, code: "invalid_query", message: "Missing search query"}
+	errInvalidBefore = &messageError{status: http.StatusBadRequest, code: "invalid_before", message: "Invalid before time; use RFC 3339"}
+	errInvalidFrom   = &messageError{status: http.StatusBadRequest, code: "invalid_from", message: "Invalid from time; use RFC 3339"}
+)
+
+// Snippet shape: at most snippetLength runes, starting up to snippetLead
+// runes before the first match.
+const (
+	snippetLength = 160
+	snippetLead   = 40
+)
+
+// searchDoc is the indexed form of a message.
+type searchDoc struct {
+	terms  []string
+	sender string
+	time   time.Time
+}
+
+// searchIndex is an inverted index of the chat messages in the history.
+// order lists the indexed ids in ascending order so that evicted messages
+// can be dropped from the front; it may still hold ids of deleted messages.
+type searchIndex struct {
+	mu       sync.RWMutex
+	postings map[string]map[uint64]struct{}
+	docs     map[uint64]searchDoc
+	order    []uint64
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[uint64]struct{}),
+		docs:     make(map[uint64]searchDoc),
+	}
+}
+
+// searchQuery selects messages that contain every term. Optional filters
+// restrict the sender, require the message to be sent at or after from and
+// before before, and continue after a cursor, the id of the last result of
+// the previous page.
+type searchQuery struct {
+	terms  []string
+	sender string
+	from   time.Time
+	before time.Time
+	cursor uint64
+	limit  int
+}
+
+// index adds entry to the index, replacing any earlier version of it.
+// Entries that are not chat messages, and tombstones, are removed instead.
+func (si *searchIndex) index(entry historyEntry) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	si.indexLocked(entry)
+}
+
+func (si *searchIndex) indexLocked(entry historyEntry) {
+	si.removeLocked(entry.ID)
+	msg, ok := decodeEntry(entry)
+	if !ok || msg.Deleted {
+		return
+	}
+
+	doc := searchDoc{terms: tokenize(msg.Content), sender: msg.Sender, time: entry.Time}
+	for _, term := range doc.terms {
+		ids, ok := si.postings[term]
+		if !ok {
+			ids = make(map[uint64]struct{})
+			si.postings[term] = ids
+		}
+		ids[entry.ID] = struct{}{}
+	}
+	si.docs[entry.ID] = doc
+	if n := len(si.order); n == 0 || si.order[n-1] < entry.ID {
+		si.order = append(si.order, entry.ID)
+	}
+}
+
+func (si *searchIndex) removeLocked(id uint64) {
+	doc, ok := si.docs[id]
+	if !ok {
+		return
+	}
+	for _, term := range doc.terms {
+		delete(si.postings[term], id)
+		if len(si.postings[term]) == 0 {
+			delete(si.postings, term)
+		}
+	}
+	delete(si.docs, id)
+}
+
+// evictBefore drops the messages with an id lower than oldest, which have
+// left the history.
+func (si *searchIndex) evictBefore(oldest uint64) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	evicted := 0
+	for evicted < len(si.order) && si.order[evicted] < oldest {
+		si.removeLocked(si.order[evicted])
+		evicted++
+	}
+	if evicted > 0 {
+		si.order = append([]uint64(nil), si.order[evicted:]...)
+	}
+}
+
+// rebuild replaces the index with one built from entries.
+func (si *searchIndex) rebuild(entries []historyEntry) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	si.postings = make(map[string]map[uint64]struct{})
+	si.docs = make(map[uint64]searchDoc)
+	si.order = nil
+	for _, entry := range entries {
+		si.indexLocked(entry)
+	}
+	log.Printf("Search index rebuilt with %d messages", len(si.docs))
+}
+
+// search returns the ids of up to q.limit matching messages, newest first,
+// and whether more results follow.
+func (si *searchIndex) search(q searchQuery) ([]uint64, bool) {
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+
+	if len(q.terms) == 0 {
+		return nil, false
+	}
+	// Walk the shortest posting list and check the others against it.
+	shortest := si.postings[q.terms[0]]
+	for _, term := range q.terms[1:] {
+		if len(si.postings[term]) < len(shortest) {
+			shortest = si.postings[term]
+		}
+	}
+
+	var ids []uint64
+	for id := range shortest {
+		if si.matchesLocked(id, q) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] > ids[j] })
+	if len(ids) > q.limit {
+		return ids[:q.limit], true
+	}
+	return ids, false
+}
+
+func (si *searchIndex) matchesLocked(id uint64, q searchQuery) bool {
+	if q.cursor != 0 && id >= q.cursor {
+		return false
+	}
+	doc := si.docs[id]
+	if q.sender != "" && doc.sender != q.sender {
+		return false
+	}
+	if !q.from.IsZero() && doc.time.Before(q.from) {
+		return false
+	}
+	if !q.before.IsZero() && !doc.time.Before(q.before) {
+		return false
+	}
+	for _, term := range q.terms {
+		if _, ok := si.postings[term][id]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// isWordRune reports whether r is part of a word. Everything else separates
+// words.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// wordSpans returns the start and end of every word in runes.
+func wordSpans(runes []rune) [][2]int {
+	var spans [][2]int
+	start := -1
+	for i, r := range runes {
+		switch {
+		case isWordRune(r) && start < 0:
+			start = i
+		case !isWordRune(r) && start >= 0:
+			spans = append(spans, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(runes)})
+	}
+	return spans
+}
+
+// tokenize returns the distinct lowercased words of text.
+func tokenize(text string) []string {
+	runes := []rune(text)
+	seen := make(map[string]bool)
+	var terms []string
+	for _, span := range wordSpans(runes) {
+		term := strings.ToLower(string(runes[span[0]:span[1]]))
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// snippet returns an HTML-escaped excerpt of content around the first word
+// matching one of terms, with every matching word wrapped in <mark>.
+func snippet(content string, terms []string) string {
+	runes := []rune(content)
+	wanted := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		wanted[term] = true
+	}
+	var matches [][2]int
+	for _, span := range wordSpans(runes) {
+		if wanted[strings.ToLower(string(runes[span[0]:span[1]]))] {
+			matches = append(matches, span)
+		}
+	}
+
+	start, end := 0, len(runes)
+	if len(runes) > snippetLength {
+		if len(matches) > 0 {
+			start = max(0, matches[0][0]-snippetLead)
+		}
+		start = min(start, len(runes)-snippetLength)
+		end = start + snippetLength
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for _, m := range matches {
+		if m[0] < start || m[1] > end {
+			continue
+		}
+		b.WriteString(html.EscapeString(string(runes[pos:m[0]])))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(string(runes[m[0]:m[1]])))
+		b.WriteString("</mark>")
+		pos = m[1]
+	}
+	b.WriteString(html.EscapeString(string(runes[pos:end])))
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// searchResult is one message found by GET /api/v1/search. Message is the
+// message as clients receive it.
+type searchResult struct {
+	ID      uint64          `json:"id"`
+	Room    string          `json:"room"`
+	Sender  string          `json:"sender,omitempty"`
+	SentAt  time.Time       `json:"sent_at"`
+	Snippet string          `json:"snippet"`
+	Message json.RawMessage `json:"message"`
+}
+
+// searchResponse is the body of GET /api/v1/search. NextCursor is set when
+// more results follow.
+type searchResponse struct {
+	Results    []searchResult `json:"results"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// SearchHandler serves GET /api/v1/search. Authenticated users search the
+// messages of the rooms they belong to; q is required, and room, sender,
+// from and before (RFC 3339 times), cursor and limit narrow the results.
+func SearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed. Search endpoint only accepts GET requests.", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkIPAccess(w, r) {
+		return
+	}
+	user, _, ok := authenticateClient(w, r)
+	if !ok {
+		return
+	}
+	if user == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gochat"`)
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	q, err := parseSearchQuery(query)
+	if err != nil {
+		writeMessageError(w, err)
+		return
+	}
+	// Every user belongs to the default room, the only one there is.
+	if room := query.Get("room"); room != "" && room != DefaultRoom {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	ids, more := hub.search.search(q)
+	response := searchResponse{Results: make([]searchResult, 0, len(ids))}
+	for _, id := range ids {
+		entry, ok := hub.history.get(id)
+		if !ok {
+			continue
+		}
+		msg, ok := decodeEntry(entry)
+		if !ok {
+			continue
+		}
+		response.Results = append(response.Results, searchResult{
+			ID:      id,
+			Room:    DefaultRoom,
+			Sender:  msg.Sender,
+			SentAt:  entry.Time,
+			Snippet: snippet(msg.Content, q.terms),
+			Message: jsonPayload(entry.Payload),
+		})
+	}
+	if more && len(ids) > 0 {
+		response.NextCursor = strconv.FormatUint(ids[len(ids)-1], 10)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// parseSearchQuery validates the query parameters of a search request.
+func parseSearchQuery(values url.Values) (searchQuery, error) {
+	q := searchQuery{terms: tokenize(values.Get("q")), sender: values.Get("sender")}
+	if len(q.terms) == 0 {
+		return q, errMissingQuery
+	}
+	var err error
+	if q.from, err = parseSearchTime(values.Get("from"), errInvalidFrom); err != nil {
+		return q, err
+	}
+	if q.before, err = parseSearchTime(values.Get("before"), errInvalidBefore); err != nil {
+		return q, err
+	}
+	if q.cursor, err = parseCursor(values.Get("cursor")); err != nil {
+		return q, err
+	}
+	q.limit, err = parseLimit(values.Get("limit"), defaultSearchLimit)
+	return q, err
+}
+
+// parseSearchTime parses an optional RFC 3339 time bound, returning invalid
+// if it is malformed.
+func parseSearchTime(value string, invalid error) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, invalid
+	}
+	return t, nil
+}
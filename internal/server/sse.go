@@ -0,0 +1,259 @@
+// Package server implements the Server-Sent Events fallback transport for
+// clients whose network path strips WebSocket upgrades. Clients receive on
+// GET /sse and send on POST /messages using the session id issued by /sse.
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// sseKeepAliveInterval is the default for how often an SSE comment is
+	// written so that intermediaries do not close an idle stream.
+	sseKeepAliveInterval = 30 * time.Second
+	// sessionHeader carries the fallback session id on POST /messages.
+	sessionHeader = "X-Session-ID"
+)
+
+// SSEHandler streams chat messages to the client as text/event-stream.
+// The client is registered with the hub like a WebSocket client, so it is
+// subject to the same origin check and receives the same broadcasts. The
+// first event is a "session" event whose data holds the session id to use
+// with POST /messages. A Last-Event-ID header (or lastEventId query
+// parameter) replays retained history newer than that id.
+func SSEHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed. SSE endpoint only accepts GET requests.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkStreamOrigin(r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	admissionKey, ok := admit(w, r)
+	if !ok {
+		return
+	}
+
+	user, role, ok := authenticateClient(w, r)
+	if !ok {
+		admission.release(admissionKey)
+		return
+	}
+	if !checkReliable(w, r, user) {
+		admission.release(admissionKey)
+		return
+	}
+
+	client, err := newSSEClient(hub, r)
+	if err != nil {
+		admission.release(admissionKey)
+		log.Printf("Failed to create SSE session for %s: %v", clientAddr(r), err)
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	client.admissionKey = admissionKey
+	client.user = user
+	client.role = role
+	client.reliable = reliableRequested(r)
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	client.hub.register <- client
+	defer client.hub.leave(client)
+
+	stream := &sseStream{w: w, flusher: flusher, rc: http.NewResponseController(w), addr: client.addr, writeWait: client.keepalive.WriteWait}
+	if !stream.write(encodeSSESession(client.sessionID)) {
+		return
+	}
+	stream.serve(r, client)
+}
+
+// newSSEClient builds an SSE client with a fresh session id and the resume
+// position requested by the browser, if any.
+func newSSEClient(h *Hub, r *http.Request) (*Client, error) {
+	sessionID, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	client := NewClient(nil, h, clientAddr(r))
+	client.ip = clientIP(r)
+	client.transport = transportSSE
+	client.keepalive = currentConfig().Keepalive.policy(transportSSE)
+	client.sessionID = sessionID
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	if lastEventID != "" {
+		if id, err := strconv.ParseUint(lastEventID, 10, 64); err == nil {
+			client.resume = true
+			client.resumeAfter = id
+		}
+	}
+
+	return client, nil
+}
+
+// newSessionID returns a random, URL-safe session identifier.
+func newSessionID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// sseStream writes events to a single SSE response.
+type sseStream struct {
+	w         http.ResponseWriter
+	flusher   http.Flusher
+	rc        *http.ResponseController
+	addr      string
+	writeWait time.Duration
+}
+
+// serve forwards queued events until the client disconnects or the hub
+// closes the client's send channel.
+func (s *sseStream) serve(r *http.Request, client *Client) {
+	keepAlive := time.NewTicker(client.keepalive.PingPeriod)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-client.send:
+			if !ok || !s.write(event) {
+				return
+			}
+		case <-keepAlive.C:
+			if !s.write([]byte(": keep-alive\n\n")) {
+				return
+			}
+		}
+	}
+}
+
+// write sends one chunk to the client and flushes it immediately. The
+// server-wide write timeout is replaced by a per-write deadline because the
+// response is long-lived.
+func (s *sseStream) write(chunk []byte) bool {
+	if err := s.rc.SetWriteDeadline(time.Now().Add(s.writeWait)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("Error setting SSE write deadline for %s: %v", s.addr, err)
+		return false
+	}
+	if _, err := s.w.Write(chunk); err != nil {
+		if !isExpectedCloseError(err) {
+			log.Printf("Error writing SSE event to %s: %v", s.addr, err)
+		}
+		return false
+	}
+	s.flusher.Flush()
+	return true
+}
+
+// encodeSSEEvent formats a broadcast entry as an SSE message event. The
+// history id becomes the event id so that browsers send it back as
+// Last-Event-ID when they reconnect. Server events, which have no history
+// id, are sent without one so that they do not move the resume position.
+func encodeSSEEvent(entry historyEntry) []byte {
+	var buf bytes.Buffer
+	if entry.ID != 0 {
+		fmt.Fprintf(&buf, "id: %d\n", entry.ID)
+	}
+	for _, line := range bytes.Split(entry.Payload, []byte{'\n'}) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// encodeSSESession formats the event that tells the client its session id.
+func encodeSSESession(sessionID string) []byte {
+	return []byte(fmt.Sprintf("event: session\ndata: {\"session_id\":%q}\n\n", sessionID))
+}
+
+// PostMessageHandler accepts a chat message from an SSE or long-poll client.
+// The session id issued by /sse or /poll must be supplied in the
+// X-Session-ID header or the session query parameter. The message goes
+// through the same rate limiter, size limit and validation as WebSocket
+// messages, and may be a command; rejected commands are answered with an
+// HTTP error instead of an error event.
+func PostMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed. Messages endpoint only accepts POST requests.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkOrigin(r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	if !checkIPAccess(w, r) {
+		return
+	}
+
+	sessionID := r.Header.Get(sessionHeader)
+	if sessionID == "" {
+		sessionID = r.URL.Query().Get("session")
+	}
+	client, ok := hub.sessionClient(sessionID)
+	if sessionID == "" || !ok {
+		http.Error(w, "Unknown or expired session", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, client.maxMessageSize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			log.Printf("Message from %s exceeded maximum size of %d bytes", client.addr, client.maxMessageSize)
+			client.audit(auditMessageTooLarge, "limit "+strconv.FormatInt(client.maxMessageSize, 10)+" bytes")
+			http.Error(w, "Message too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Failed to read message", http.StatusBadRequest)
+		return
+	}
+
+	if allowed, _ := client.checkRateLimit(body); !allowed {
+		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	if err := client.processMessage(body); err != nil {
+		writeMessageError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
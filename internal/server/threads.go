@@ -0,0 +1,140 @@
+// Package server implements reply threads. A message with in_reply_to joins
+// the thread of the message it answers; threads are flat, so a reply to a
+// reply joins the same root. Thread contents are served from the message
+// history, and a summary of the thread is pushed to the room whenever it
+// changes.
+package server
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// Thread event types.
+const (
+	eventThread        = "thread"
+	eventThreadSummary = "thread_summary"
+)
+
+// threadEvent answers a thread query with the root message, if it is still
+// retained, and its retained replies, oldest first.
+type threadEvent struct {
+	Type    string    `json:"type"`
+	ID      uint64    `json:"id"`
+	Root    *Message  `json:"root,omitempty"`
+	Replies []Message `json:"replies"`
+}
+
+// threadSummaryEvent tells the room how many live replies a thread has.
+type threadSummaryEvent struct {
+	Type         string   `json:"type"`
+	ID           uint64   `json:"id"`
+	ReplyCount   int      `json:"reply_count"`
+	LastReplyID  uint64   `json:"last_reply_id,omitempty"`
+	Participants []string `json:"participants"`
+}
+
+// resolveReply checks that the message a new message replies to is retained
+// and not deleted, and points the reply at the root of that message's thread.
+func (h *Hub) resolveReply(msg *Message) error {
+	if msg.InReplyTo == 0 {
+		return nil
+	}
+	entry, found := h.history.get(msg.InReplyTo)
+	if !found {
+		return errMessageNotFound
+	}
+	parent, ok := decodeEntry(entry)
+	if !ok || parent.Deleted {
+		return errMessageNotFound
+	}
+	if parent.InReplyTo != 0 {
+		msg.InReplyTo = parent.InReplyTo
+	}
+	return nil
+}
+
+// thread collects a thread from the history. id may name the root or any
+// reply in the thread.
+func (h *Hub) thread(id uint64) threadEvent {
+	rootID := id
+	if entry, found := h.history.get(id); found {
+		if msg, ok := decodeEntry(entry); ok && msg.InReplyTo != 0 {
+			rootID = msg.InReplyTo
+		}
+	}
+
+	event := threadEvent{Type: eventThread, ID: rootID, Replies: []Message{}}
+	for _, entry := range h.history.since(rootID - 1) {
+		msg, ok := decodeEntry(entry)
+		switch {
+		case !ok:
+		case msg.ID == rootID:
+			root := msg
+			event.Root = &root
+		case msg.InReplyTo == rootID:
+			event.Replies = append(event.Replies, msg)
+		}
+	}
+	return event
+}
+
+// sendThread answers a thread query from a client.
+func (h *Hub) sendThread(c *Client, id uint64) error {
+	if id == 0 {
+		return errMessageNotFound
+	}
+	event := h.thread(id)
+	if event.Root == nil && len(event.Replies) == 0 {
+		return errMessageNotFound
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding thread %d: %v", event.ID, err)
+		return err
+	}
+	h.sendEvent(c, payload)
+	return nil
+}
+
+// threadSummary counts the live replies of a thread.
+func (h *Hub) threadSummary(rootID uint64) threadSummaryEvent {
+	summary := threadSummaryEvent{Type: eventThreadSummary, ID: rootID, Participants: []string{}}
+	seen := make(map[string]bool)
+	for _, reply := range h.thread(rootID).Replies {
+		if reply.Deleted {
+			continue
+		}
+		summary.ReplyCount++
+		summary.LastReplyID = reply.ID
+		if reply.Sender != "" && !seen[reply.Sender] {
+			seen[reply.Sender] = true
+			summary.Participants = append(summary.Participants, reply.Sender)
+		}
+	}
+	return summary
+}
+
+// pushThreadSummary sends the summary of a thread to every client. It runs
+// on the hub goroutine right after a reply is broadcast.
+func (h *Hub) pushThreadSummary(rootID uint64) {
+	payload, err := json.Marshal(h.threadSummary(rootID))
+	if err != nil {
+		log.Printf("Error encoding summary of thread %d: %v", rootID, err)
+		return
+	}
+	failed := h.broadcastToClients(h.getClientSnapshot(), nil, historyEntry{Payload: payload})
+	h.removeFailedClients(failed)
+}
+
+// announceThreadSummary queues the summary of a thread for every client from
+// outside the hub goroutine.
+func (h *Hub) announceThreadSummary(rootID uint64) {
+	payload, err := json.Marshal(h.threadSummary(rootID))
+	if err != nil {
+		log.Printf("Error encoding summary of thread %d: %v", rootID, err)
+		return
+	}
+	h.broadcastEvent(payload)
+}
@@ -0,0 +1,377 @@
+// Package server exports and imports chat data so that rooms can be moved
+// between servers and handed over as transcripts. Exports are JSON Lines:
+// one record per room, per member and per message, in that order. Imported
+// messages keep their ids, so the target server must not have assigned
+// those ids yet; a freshly started server has assigned none. Imports are
+// applied on the hub goroutine, so they are ordered with broadcasts.
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Transfer record types.
+const (
+	recordRoom    = "room"
+	recordMember  = "member"
+	recordMessage = "message"
+)
+
+// maxImportBytes bounds the size of an import request body.
+const maxImportBytes = 256 << 20
+
+// exportBatchSize is how many history entries an export copies at a time.
+const exportBatchSize = 100
+
+var (
+	errIDConflict     = &messageError{status: http.StatusConflict, code: "id_conflict", message: "Imported message ids must be increasing and newer than every stored message"}
+	errInvalidRecord  = &messageError{status: http.StatusBadRequest, code: "invalid_record", message: "Invalid export record"}
+	errInvalidRange   = &messageError{status: http.StatusBadRequest, code: "invalid_range", message: "Invalid since or until; use RFC 3339 or YYYY-MM-DD"}
+	errUnknownFormat  = &messageError{status: http.StatusBadRequest, code: "invalid_format", message: "Unknown format; use jsonl or text"}
+	errImportTooLarge = &messageError{status: http.StatusRequestEntityTooLarge, code: "too_large", message: "Import is too large"}
+)
+
+// transferRecord is one line of an export. Room records carry only Room;
+// member records add User and Role; message records add the message as
+// clients receive it, together with the data the server keeps about it.
+type transferRecord struct {
+	Type      string           `json:"type"`
+	Room      string           `json:"room"`
+	User      string           `json:"user,omitempty"`
+	Role      Role             `json:"role,omitempty"`
+	ID        uint64           `json:"id,omitempty"`
+	Author    string           `json:"author,omitempty"`
+	SentAt    *time.Time       `json:"sent_at,omitempty"`
+	Message   json.RawMessage  `json:"message,omitempty"`
+	Reactions []reactionRecord `json:"reactions,omitempty"`
+}
+
+// reactionRecord lists who reacted to a message with one emoji.
+type reactionRecord struct {
+	Emoji string   `json:"emoji"`
+	Users []string `json:"users"`
+}
+
+// historyRestore asks the hub goroutine to add imported entries to the
+// history. The outcome is sent on result.
+type historyRestore struct {
+	entries []historyEntry
+	result  chan restoreResult
+}
+
+// restoreResult reports how many entries a restore evicted, or why it
+// failed.
+type restoreResult struct {
+	evicted int
+	err     error
+}
+
+// importResponse is the body returned for a completed import. Evicted
+// counts imported messages that did not fit in the history.
+type importResponse struct {
+	Rooms    int `json:"rooms"`
+	Members  int `json:"members"`
+	Messages int `json:"messages"`
+	Evicted  int `json:"evicted"`
+}
+
+// ExportHandler serves GET /admin/export. The room, since and until query
+// parameters filter the chat messages; since is inclusive and until
+// exclusive. format=text writes a plain-text transcript instead of JSON
+// Lines.
+func ExportHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed. Export endpoint only accepts GET requests.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	since, errSince := parseExportTime(query.Get("since"))
+	until, errUntil := parseExportTime(query.Get("until"))
+	if errSince != nil || errUntil != nil {
+		writeMessageError(w, errInvalidRange)
+		return
+	}
+	format := query.Get("format")
+	if format != "" && format != "jsonl" && format != "text" {
+		writeMessageError(w, errUnknownFormat)
+		return
+	}
+	// Every room can be exported; today the default room is the only one.
+	if room := query.Get("room"); room != "" && room != DefaultRoom {
+		writeMessageError(w, errUnknownRoom)
+		return
+	}
+	rooms := []string{DefaultRoom}
+
+	entries := exportEntries(hub.history, since, until)
+
+	recordAudit(auditRecord{Action: "export", Actor: "admin", IP: clientIP(r), Target: query.Get("room")})
+	w.Header().Set("Cache-Control", "no-store")
+	out := bufio.NewWriter(w)
+	var err error
+	if format == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		err = writeTranscript(out, rooms, entries)
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		err = writeExport(out, rooms, entries)
+	}
+	if err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		log.Printf("Error writing export: %v", err)
+	}
+}
+
+// exportEntries streams the retained chat messages sent in [since, until),
+// oldest first, without copying the whole history. Messages broadcast while
+// the export is written may be included.
+func exportEntries(history *messageHistory, since, until time.Time) iter.Seq[historyEntry] {
+	return func(yield func(historyEntry) bool) {
+		for entry := range history.all(exportBatchSize) {
+			if _, ok := decodeEntry(entry); !ok {
+				continue
+			}
+			if !since.IsZero() && entry.Time.Before(since) {
+				continue
+			}
+			if !until.IsZero() && !entry.Time.Before(until) {
+				continue
+			}
+			if !yield(entry) {
+				return
+			}
+		}
+	}
+}
+
+// parseExportTime parses an RFC 3339 time or a UTC date. An empty value is
+// the zero time.
+func parseExportTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+// writeExport writes rooms, their members and entries as JSON Lines.
+// Memberships come from the configured user accounts, who all belong to
+// every room; their tokens are not exported.
+func writeExport(w io.Writer, rooms []string, entries iter.Seq[historyEntry]) error {
+	encoder := json.NewEncoder(w)
+	users := currentConfig().Users
+	for _, room := range rooms {
+		if err := encoder.Encode(transferRecord{Type: recordRoom, Room: room}); err != nil {
+			return err
+		}
+		for _, user := range users {
+			if err := encoder.Encode(transferRecord{Type: recordMember, Room: room, User: user.Name, Role: user.Role}); err != nil {
+				return err
+			}
+		}
+	}
+	for entry := range entries {
+		if err := encoder.Encode(messageRecord(entry)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func messageRecord(entry historyEntry) transferRecord {
+	sentAt := entry.Time
+	record := transferRecord{
+		Type:    recordMessage,
+		Room:    DefaultRoom,
+		ID:      entry.ID,
+		Author:  entry.Author,
+		SentAt:  &sentAt,
+		Message: jsonPayload(entry.Payload),
+	}
+	for _, r := range entry.Reactions {
+		record.Reactions = append(record.Reactions, reactionRecord{Emoji: r.emoji, Users: r.users})
+	}
+	return record
+}
+
+// writeTranscript writes a plain-text transcript with one line per message.
+func writeTranscript(w io.Writer, rooms []string, entries iter.Seq[historyEntry]) error {
+	for _, room := range rooms {
+		if _, err := fmt.Fprintf(w, "# %s\n", room); err != nil {
+			return err
+		}
+	}
+	for entry := range entries {
+		if _, err := fmt.Fprintln(w, transcriptLine(entry)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func transcriptLine(entry historyEntry) string {
+	stamp := entry.Time.Format(time.DateTime)
+	msg, _ := decodeEntry(entry)
+	if msg.Deleted {
+		return fmt.Sprintf("[%s] #%d (deleted)", stamp, entry.ID)
+	}
+
+	sender := msg.Sender
+	if sender == "" {
+		sender = "guest"
+	}
+	line := fmt.Sprintf("[%s] #%d %s: %s", stamp, entry.ID, sender, msg.Content)
+	if msg.InReplyTo != 0 {
+		line += " (reply to #" + strconv.FormatUint(msg.InReplyTo, 10) + ")"
+	}
+	if msg.EditedAt != nil {
+		line += " (edited)"
+	}
+	return line
+}
+
+// ImportHandler serves POST /admin/import. The body is an export in JSON
+// Lines. Messages are added to the history with their ids and timestamps
+// and indexed for search, but are not broadcast: connected clients only see
+// them through history replay, paging and search. Nothing is imported if
+// any record is invalid.
+func ImportHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed. Import endpoint only accepts POST requests.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	response, entries, err := readImport(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if err != nil {
+		writeMessageError(w, err)
+		return
+	}
+	result, ok := hub.restore(r.Context(), entries)
+	if !ok {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if result.err != nil {
+		writeMessageError(w, result.err)
+		return
+	}
+	evicted := result.evicted
+	response.Evicted = evicted
+
+	log.Printf("Imported %d messages (%d evicted from history)", response.Messages, evicted)
+	recordAudit(auditRecord{Action: "import", Actor: "admin", IP: clientIP(r), Reason: strconv.Itoa(response.Messages) + " messages"})
+	writeJSON(w, http.StatusOK, response)
+}
+
+// restore hands imported entries to the hub and waits for the outcome. It
+// reports false if the hub stopped or ctx was cancelled first.
+func (h *Hub) restore(ctx context.Context, entries []historyEntry) (restoreResult, bool) {
+	result := make(chan restoreResult, 1)
+	select {
+	case h.restores <- historyRestore{entries: entries, result: result}:
+	case <-h.ctx.Done():
+		return restoreResult{}, false
+	case <-ctx.Done():
+		return restoreResult{}, false
+	}
+
+	select {
+	case outcome := <-result:
+		return outcome, true
+	case <-h.ctx.Done():
+		return restoreResult{}, false
+	}
+}
+
+// handleRestore adds imported entries to the history, which indexes them for
+// search. It runs on the hub goroutine, so no broadcast can take an id
+// between the check that the imported ids are unused and their insertion.
+func (h *Hub) handleRestore(restore historyRestore) {
+	evicted, err := h.history.restore(restore.entries, currentConfig().HistorySize)
+	restore.result <- restoreResult{evicted: evicted, err: err}
+}
+
+// readImport decodes and validates an export. Members are counted but not
+// created: accounts come from the server configuration, and members without
+// one are logged.
+func readImport(body io.Reader) (importResponse, []historyEntry, error) {
+	var response importResponse
+	var entries []historyEntry
+	decoder := json.NewDecoder(body)
+	for {
+		var record transferRecord
+		err := decoder.Decode(&record)
+		if errors.Is(err, io.EOF) {
+			return response, entries, nil
+		}
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return response, nil, errImportTooLarge
+		}
+		if err != nil {
+			return response, nil, errInvalidRecord
+		}
+		if record.Room != DefaultRoom {
+			return response, nil, errUnknownRoom
+		}
+
+		switch record.Type {
+		case recordRoom:
+			response.Rooms++
+		case recordMember:
+			if userRole(record.User) == RoleGuest {
+				log.Printf("Imported member %s has no account on this server", record.User)
+			}
+			response.Members++
+		case recordMessage:
+			entry, err := importEntry(record)
+			if err != nil {
+				return response, nil, err
+			}
+			entries = append(entries, entry)
+			response.Messages++
+		default:
+			return response, nil, errInvalidRecord
+		}
+	}
+}
+
+func importEntry(record transferRecord) (historyEntry, error) {
+	if record.SentAt == nil {
+		return historyEntry{}, errInvalidRecord
+	}
+	entry := historyEntry{ID: record.ID, Payload: []byte(record.Message), Author: record.Author, Time: record.SentAt.UTC()}
+	if _, ok := decodeEntry(entry); !ok {
+		return historyEntry{}, errInvalidRecord
+	}
+	for _, r := range record.Reactions {
+		if !validEmoji(r.Emoji) || len(r.Users) == 0 {
+			return historyEntry{}, errInvalidRecord
+		}
+		entry.Reactions = append(entry.Reactions, reaction{emoji: r.Emoji, users: r.Users})
+	}
+	return entry, nil
+}
@@ -2,18 +2,86 @@
 // are reused across client and hub logic.
 package server
 
-import "strings"
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+)
 
 // Message represents the V1 JSON message format exchanged between clients.
 type Message struct {
+	// ID is the server-assigned message id, used to refer to the message in
+	// edits and deletions.
+	ID      uint64 `json:"id,omitempty"`
 	Content string `json:"content"`
+	// Sender is set by the server for messages posted by integrations and
+	// authenticated users. Values supplied by clients are discarded.
+	Sender string `json:"sender,omitempty"`
+	// InReplyTo is the id of the thread root this message replies to.
+	InReplyTo uint64 `json:"in_reply_to,omitempty"`
+	// EditedAt is set once the message has been edited.
+	EditedAt *time.Time `json:"edited_at,omitempty"`
+	// Deleted marks a tombstone; its content and sender have been removed.
+	Deleted bool `json:"deleted,omitempty"`
+	// Reactions are the emoji added to the message and how many clients
+	// added each, in the order they were first added.
+	Reactions []ReactionCount `json:"reactions,omitempty"`
+}
+
+// ReactionCount is the aggregate count of one emoji on a message.
+type ReactionCount struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+}
+
+// decodeEntry decodes a history entry as a chat message. It reports false
+// for entries that are not chat messages, such as raw broadcast payloads.
+func decodeEntry(entry historyEntry) (Message, bool) {
+	var msg Message
+	if err := json.Unmarshal(entry.Payload, &msg); err != nil || msg.ID != entry.ID {
+		return Message{}, false
+	}
+	return msg, true
 }
 
 // BroadcastMessage encapsulates a message being broadcast by the hub,
 // including the originating client so it can be excluded from delivery.
+// Messages that do not come from a connected client identify their origin
+// with Identity instead. If Message is set, it is encoded with its assigned
+// id as the payload. If Result is set, the hub sends the assigned message id
+// on it; it must be buffered. Event payloads are server notifications: they
+// go to every client but Sender, if set, and are not recorded in history.
 type BroadcastMessage struct {
-	Sender  *Client
-	Payload []byte
+	Sender   *Client
+	Identity string
+	Payload  []byte
+	Message  *Message
+	Result   chan<- uint64
+	Event    bool
+}
+
+// author returns the identity recorded as the message's author.
+func (b BroadcastMessage) author() string {
+	if b.Sender != nil {
+		return b.Sender.identity()
+	}
+	return b.Identity
+}
+
+// encode returns the payload to store and deliver for the given id.
+func (b BroadcastMessage) encode(id uint64) []byte {
+	if b.Message == nil {
+		return b.Payload
+	}
+	msg := *b.Message
+	msg.ID = id
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error encoding message %d: %v", id, err)
+		return b.Payload
+	}
+	return payload
 }
 
 // isExpectedCloseError checks if an error is expected during connection closure.
@@ -26,3 +94,16 @@ func isExpectedCloseError(err error) bool {
 		strings.Contains(errStr, "websocket: close sent") ||
 		strings.Contains(errStr, "broken pipe")
 }
+
+// jsonPayload returns a broadcast payload for embedding in a JSON document.
+// Payloads that are not valid JSON are embedded as JSON strings.
+func jsonPayload(payload []byte) json.RawMessage {
+	if json.Valid(payload) {
+		return json.RawMessage(payload)
+	}
+	quoted, err := json.Marshal(string(payload))
+	if err != nil {
+		return json.RawMessage(`""`)
+	}
+	return quoted
+}
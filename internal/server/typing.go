@@ -0,0 +1,187 @@
+// Package server implements typing indicators. Clients send typing_start
+// while the user types and typing_stop when they stop; the room is told only
+// when a client starts or stops typing, so repeated starts merely keep the
+// indicator alive. An indicator expires on its own if no stop arrives.
+// Typing frames are ephemeral, are not kept in history, and are throttled
+// by their own limiter instead of the chat message rate limit.
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// Typing frame and event types.
+const (
+	frameTypingStart = "typing_start"
+	frameTypingStop  = "typing_stop"
+	eventTyping      = "typing"
+)
+
+// TypingConfig controls typing indicators. An indicator is cleared Timeout
+// after the last typing_start, and RateLimit bounds how many typing frames
+// a connection may send.
+type TypingConfig struct {
+	Timeout   time.Duration
+	RateLimit RateLimitConfig
+}
+
+func defaultTypingConfig() TypingConfig {
+	return TypingConfig{
+		Timeout: 5 * time.Second,
+		RateLimit: RateLimitConfig{
+			Burst:          5,
+			RefillInterval: 5 * time.Second,
+		},
+	}
+}
+
+func sanitizeTypingConfig(cfg TypingConfig) TypingConfig {
+	defaults := defaultTypingConfig()
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaults.Timeout
+	}
+	if cfg.RateLimit.Burst <= 0 {
+		cfg.RateLimit.Burst = defaults.RateLimit.Burst
+	}
+	if cfg.RateLimit.RefillInterval <= 0 {
+		cfg.RateLimit.RefillInterval = defaults.RateLimit.RefillInterval
+	}
+	return cfg
+}
+
+// typingEvent tells the other clients that someone started or stopped
+// typing. User is omitted for guests.
+type typingEvent struct {
+	Type   string `json:"type"`
+	User   string `json:"user,omitempty"`
+	Typing bool   `json:"typing"`
+}
+
+// typingState tracks which connections are typing. Each entry owns the
+// timer that expires it.
+type typingState struct {
+	mu      sync.Mutex
+	entries map[*Client]*typingEntry
+}
+
+type typingEntry struct {
+	timer *time.Timer
+}
+
+func newTypingState() *typingState {
+	return &typingState{entries: make(map[*Client]*typingEntry)}
+}
+
+// start marks c as typing until timeout passes, after which expire is
+// called. It reports whether c was not typing before.
+func (t *typingState) start(c *Client, timeout time.Duration, expire func()) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	previous, typing := t.entries[c]
+	if typing {
+		previous.timer.Stop()
+	}
+	entry := &typingEntry{}
+	entry.timer = time.AfterFunc(timeout, func() {
+		if t.remove(c, entry) {
+			expire()
+		}
+	})
+	t.entries[c] = entry
+	return !typing
+}
+
+// stop clears the typing state of c and reports whether it was typing.
+func (t *typingState) stop(c *Client) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, typing := t.entries[c]
+	if typing {
+		entry.timer.Stop()
+		delete(t.entries, c)
+	}
+	return typing
+}
+
+// remove clears the typing state of c if it is still the given entry, so
+// that a timer which fires as the state is renewed does not clear it.
+func (t *typingState) remove(c *Client, entry *typingEntry) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.entries[c] != entry {
+		return false
+	}
+	delete(t.entries, c)
+	return true
+}
+
+// startTyping handles a typing_start frame.
+func (h *Hub) startTyping(c *Client) error {
+	if err := h.moderation.checkMuted(c); err != nil {
+		return err
+	}
+	timeout := currentConfig().Typing.Timeout
+	if h.typing.start(c, timeout, func() { h.announceTyping(c, false) }) {
+		h.announceTyping(c, true)
+	}
+	return nil
+}
+
+// stopTyping handles a typing_stop frame. It is also called when a client
+// sends a chat message, which ends its typing.
+func (h *Hub) stopTyping(c *Client) {
+	if h.typing.stop(c) {
+		h.announceTyping(c, false)
+	}
+}
+
+// clearTyping drops the typing state of a client that left. It runs on the
+// hub goroutine, so it delivers the stop event directly.
+func (h *Hub) clearTyping(c *Client) {
+	if !h.typing.stop(c) {
+		return
+	}
+	payload, err := typingPayload(c, false)
+	if err != nil {
+		return
+	}
+	h.removeFailedClients(h.broadcastToClients(h.getClientSnapshot(), c, historyEntry{Payload: payload}))
+}
+
+// announceTyping sends a typing event to every client except c.
+func (h *Hub) announceTyping(c *Client, typing bool) {
+	payload, err := typingPayload(c, typing)
+	if err != nil {
+		return
+	}
+	select {
+	case h.broadcast <- BroadcastMessage{Sender: c, Payload: payload, Event: true}:
+	case <-h.ctx.Done():
+	}
+}
+
+func typingPayload(c *Client, typing bool) ([]byte, error) {
+	payload, err := json.Marshal(typingEvent{Type: eventTyping, User: c.user, Typing: typing})
+	if err != nil {
+		log.Printf("Error encoding typing event for %s: %v", c.addr, err)
+	}
+	return payload, err
+}
+
+// isTypingFrame reports whether a raw client frame is a typing notification,
+// which is throttled separately from chat messages.
+func isTypingFrame(rawMessage []byte) bool {
+	var envelope struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(rawMessage, &envelope); err != nil {
+		return false
+	}
+	return envelope.Type == frameTypingStart || envelope.Type == frameTypingStop
+}
@@ -0,0 +1,449 @@
+// Package server delivers chat events to outbound webhook subscriptions. The
+// hub hands events to a bounded worker pool without blocking; deliveries are
+// signed with HMAC-SHA256 over a timestamp and the body, retried with
+// exponential backoff, and written to a dead-letter log when they cannot be
+// delivered, including retries still pending at shutdown.
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Webhook event types.
+const (
+	WebhookEventMessage = "message"
+	WebhookEventJoin    = "join"
+	WebhookEventLeave   = "leave"
+)
+
+// Webhook request headers.
+const (
+	webhookSignatureHeader = "X-GoChat-Signature"
+	webhookTimestampHeader = "X-GoChat-Timestamp"
+	webhookEventHeader     = "X-GoChat-Event"
+	webhookDeliveryHeader  = "X-GoChat-Delivery"
+)
+
+// WebhookSubscription is an endpoint that receives chat events. An empty
+// Events list subscribes to every event type.
+type WebhookSubscription struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+// WebhookConfig controls outbound webhook delivery.
+type WebhookConfig struct {
+	Subscriptions  []WebhookSubscription
+	Workers        int
+	QueueSize      int
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	Timeout        time.Duration
+	DeadLetterPath string
+}
+
+func (s WebhookSubscription) wants(event string) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, candidate := range s.Events {
+		if candidate == event {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookEnvelope is the JSON body POSTed to subscribers.
+type webhookEnvelope struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// webhookMessageData describes a broadcast message.
+type webhookMessageData struct {
+	MessageID uint64          `json:"message_id"`
+	Sender    string          `json:"sender,omitempty"`
+	Message   json.RawMessage `json:"message"`
+}
+
+// webhookPresenceData describes a client joining or leaving. Client is the
+// user name or guest label, never the network address.
+type webhookPresenceData struct {
+	Client    string `json:"client"`
+	Transport string `json:"transport"`
+	Clients   int    `json:"clients"`
+}
+
+// webhookDelivery is one event bound for one subscription.
+type webhookDelivery struct {
+	id           string
+	event        string
+	subscription WebhookSubscription
+	body         []byte
+	attempts     int
+	lastErr      string
+}
+
+// webhookDispatcher owns the delivery queue and worker pool. Workers are
+// started on first use so that the pool size reflects the active config.
+// retries holds the timers of deliveries waiting for their next attempt.
+// deadLetters feeds a writer goroutine, so that the hub never waits on the
+// dead-letter log; dropped counts deliveries it had no room for.
+type webhookDispatcher struct {
+	queue       chan *webhookDelivery
+	deadLetters chan *webhookDelivery
+	dropped     atomic.Int64
+	stop        chan struct{}
+	client      *http.Client
+	start       sync.Once
+	stopped     sync.Once
+	wg          sync.WaitGroup
+	mu          sync.Mutex
+	closed      bool
+	retries     map[*webhookDelivery]*time.Timer
+}
+
+func newWebhookDispatcher() *webhookDispatcher {
+	return &webhookDispatcher{
+		stop:    make(chan struct{}),
+		retries: make(map[*webhookDelivery]*time.Timer),
+	}
+}
+
+// emit queues an event for every subscription that wants it. It never
+// blocks: when the queue is full the delivery goes to the dead-letter log,
+// or is counted and logged if the dead-letter writer is behind too.
+func (d *webhookDispatcher) emit(event string, data interface{}) {
+	cfg := currentConfig().Webhooks
+	if len(cfg.Subscriptions) == 0 {
+		return
+	}
+
+	d.start.Do(func() { d.startWorkers(cfg) })
+
+	id, err := newSessionID()
+	if err != nil {
+		log.Printf("Failed to generate webhook delivery id: %v", err)
+		return
+	}
+	body, err := json.Marshal(webhookEnvelope{ID: id, Type: event, Timestamp: time.Now().UTC(), Data: data})
+	if err != nil {
+		log.Printf("Failed to encode %s webhook event: %v", event, err)
+		return
+	}
+
+	for _, subscription := range cfg.Subscriptions {
+		if !subscription.wants(event) {
+			continue
+		}
+		d.enqueue(&webhookDelivery{id: id, event: event, subscription: subscription, body: body})
+	}
+}
+
+func (d *webhookDispatcher) startWorkers(cfg WebhookConfig) {
+	d.queue = make(chan *webhookDelivery, cfg.QueueSize)
+	d.deadLetters = make(chan *webhookDelivery, cfg.QueueSize)
+	d.client = &http.Client{Timeout: cfg.Timeout}
+
+	d.wg.Add(cfg.Workers + 1)
+	for i := 0; i < cfg.Workers; i++ {
+		go d.work()
+	}
+	go d.writeDeadLetters()
+	log.Printf("Started %d webhook delivery workers", cfg.Workers)
+}
+
+// enqueue hands a delivery to the workers without blocking. It runs on the
+// hub goroutine, so a delivery the queue has no room for is passed to the
+// dead-letter writer rather than written here.
+func (d *webhookDispatcher) enqueue(delivery *webhookDelivery) {
+	d.mu.Lock()
+	closed := d.closed
+	queued := false
+	if !closed {
+		select {
+		case d.queue <- delivery:
+			queued = true
+		default:
+		}
+	}
+	d.mu.Unlock()
+
+	switch {
+	case queued:
+	case closed:
+		// The writer has stopped, so the event is only counted and logged.
+		d.drop(delivery, "server shutting down")
+	default:
+		delivery.lastErr = "delivery queue full"
+		select {
+		case d.deadLetters <- delivery:
+		default:
+			d.drop(delivery, "dead-letter queue full")
+		}
+	}
+}
+
+// drop counts and logs a delivery that is neither sent nor dead-lettered.
+func (d *webhookDispatcher) drop(delivery *webhookDelivery, reason string) {
+	dropped := d.dropped.Add(1)
+	log.Printf("Dropped %s webhook %s to %s: %s (%d dropped so far)",
+		delivery.event, delivery.id, delivery.subscription.URL, reason, dropped)
+}
+
+// writeDeadLetters writes the deliveries enqueue could not queue until the
+// dispatcher stops. shutdown writes whatever is left.
+func (d *webhookDispatcher) writeDeadLetters() {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case delivery := <-d.deadLetters:
+			d.deadLetter(delivery)
+		}
+	}
+}
+
+func (d *webhookDispatcher) work() {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case delivery := <-d.queue:
+			d.attempt(delivery)
+		}
+	}
+}
+
+// attempt makes one delivery attempt and schedules a retry on failure.
+func (d *webhookDispatcher) attempt(delivery *webhookDelivery) {
+	cfg := currentConfig().Webhooks
+	delivery.attempts++
+
+	retryable, err := d.post(delivery)
+	if err == nil {
+		return
+	}
+	delivery.lastErr = err.Error()
+
+	if !retryable || delivery.attempts >= cfg.MaxAttempts {
+		d.deadLetter(delivery)
+		return
+	}
+
+	backoff := cfg.InitialBackoff << uint(delivery.attempts-1)
+	log.Printf("Webhook %s to %s failed (attempt %d/%d), retrying in %v: %v",
+		delivery.event, delivery.subscription.URL, delivery.attempts, cfg.MaxAttempts, backoff, err)
+	d.scheduleRetry(delivery, backoff)
+}
+
+// scheduleRetry enqueues the delivery again after backoff. A retry still
+// waiting at shutdown is dead-lettered by shutdown instead.
+func (d *webhookDispatcher) scheduleRetry(delivery *webhookDelivery, backoff time.Duration) {
+	d.mu.Lock()
+	closed := d.closed
+	if !closed {
+		d.retries[delivery] = time.AfterFunc(backoff, func() {
+			d.mu.Lock()
+			delete(d.retries, delivery)
+			d.mu.Unlock()
+			d.enqueue(delivery)
+		})
+	}
+	d.mu.Unlock()
+
+	if closed {
+		d.deadLetter(delivery)
+	}
+}
+
+// post sends the delivery and reports whether a failure is worth retrying.
+func (d *webhookDispatcher) post(delivery *webhookDelivery) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.client.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.subscription.URL, bytes.NewReader(delivery.body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "GoChat-Webhook")
+	req.Header.Set(webhookEventHeader, delivery.event)
+	req.Header.Set(webhookDeliveryHeader, delivery.id)
+	timestamp := time.Now().Unix()
+	req.Header.Set(webhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(webhookSignatureHeader, SignWebhookPayload(delivery.subscription.Secret, timestamp, delivery.body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	_ = resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("endpoint returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+}
+
+// deadLetterRecord is one line of the dead-letter log.
+type deadLetterRecord struct {
+	Delivery string          `json:"delivery"`
+	Event    string          `json:"event"`
+	URL      string          `json:"url"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	FailedAt time.Time       `json:"failed_at"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+var deadLetterMu sync.Mutex
+
+// deadLetter records a delivery that will not be attempted again. Records
+// are appended as JSON lines to the configured file, or logged if none is set.
+func (d *webhookDispatcher) deadLetter(delivery *webhookDelivery) {
+	record, err := json.Marshal(deadLetterRecord{
+		Delivery: delivery.id,
+		Event:    delivery.event,
+		URL:      delivery.subscription.URL,
+		Attempts: delivery.attempts,
+		Error:    delivery.lastErr,
+		FailedAt: time.Now().UTC(),
+		Payload:  delivery.body,
+	})
+	if err != nil {
+		log.Printf("Failed to encode dead-letter record for webhook %s: %v", delivery.id, err)
+		return
+	}
+
+	path := currentConfig().Webhooks.DeadLetterPath
+	if path == "" {
+		log.Printf("Webhook dead letter: %s", record)
+		return
+	}
+
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600) // #nosec G304 -- path comes from operator configuration
+	if err != nil {
+		log.Printf("Failed to open webhook dead-letter log %s: %v; record: %s", path, err, record)
+		return
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Printf("Error closing webhook dead-letter log: %v", err)
+		}
+	}()
+
+	if _, err := file.Write(append(record, '\n')); err != nil {
+		log.Printf("Failed to write webhook dead-letter record: %v; record: %s", err, record)
+	}
+}
+
+// shutdown stops the workers and dead-letters anything still queued or
+// waiting for a retry.
+func (d *webhookDispatcher) shutdown() {
+	d.stopped.Do(func() {
+		d.mu.Lock()
+		d.closed = true
+		var pending []*webhookDelivery
+		for delivery, timer := range d.retries {
+			// A timer that has already fired enqueues its delivery, which
+			// is dropped now that the dispatcher is closed.
+			if timer.Stop() {
+				pending = append(pending, delivery)
+			}
+			delete(d.retries, delivery)
+		}
+		d.mu.Unlock()
+
+		for _, delivery := range pending {
+			delivery.lastErr = "server shutting down"
+			d.deadLetter(delivery)
+		}
+
+		close(d.stop)
+		d.wg.Wait()
+
+		if d.queue == nil {
+			return
+		}
+		for {
+			select {
+			case delivery := <-d.deadLetters:
+				d.deadLetter(delivery)
+			case delivery := <-d.queue:
+				delivery.lastErr = "server shutting down"
+				d.deadLetter(delivery)
+			default:
+				return
+			}
+		}
+	})
+}
+
+// SignWebhookPayload returns the X-GoChat-Signature header value for a body
+// sent at timestamp, the Unix time in the X-GoChat-Timestamp header:
+// "sha256=" followed by the hex HMAC-SHA256 of the timestamp, a dot and the
+// body, keyed with secret. Receivers should compute the same value, compare
+// with hmac.Equal, and reject timestamps too far from their own clock so that
+// a captured delivery cannot be replayed.
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// transportName returns the transport label used in webhook payloads.
+func (c *Client) transportName() string {
+	return c.transport.name()
+}
+
+// emitPresence queues a join or leave event for the client.
+func (h *Hub) emitPresence(event string, client *Client, clientCount int) {
+	h.webhooks.emit(event, webhookPresenceData{
+		Client:    client.identity(),
+		Transport: client.transportName(),
+		Clients:   clientCount,
+	})
+}
+
+// emitMessage queues a message event for a broadcast entry.
+func (h *Hub) emitMessage(broadcastMsg BroadcastMessage, entry historyEntry) {
+	data := webhookMessageData{
+		MessageID: entry.ID,
+		Sender:    broadcastMsg.Identity,
+		Message:   jsonPayload(entry.Payload),
+	}
+	if broadcastMsg.Sender != nil {
+		data.Sender = broadcastMsg.Sender.identity()
+	}
+	h.webhooks.emit(WebhookEventMessage, data)
+}
@@ -0,0 +1,99 @@
+package integration
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ackFrame is the subset of an ack event the tests check.
+type ackFrame struct {
+	ClientID  string `json:"client_id"`
+	ID        uint64 `json:"id"`
+	Duplicate bool   `json:"duplicate"`
+}
+
+// TestMessageAcksAndDeduplication verifies that messages with a client id
+// are acked with their message id and that retransmissions are not
+// broadcast again.
+func TestMessageAcksAndDeduplication(t *testing.T) {
+	testServer := startSSETestServer(t, moderationUsers)
+	alice := dialAsUser(t, testServer.URL, "alice-token")
+	bob := dialAsUser(t, testServer.URL, "bob-token")
+
+	sendFrame(t, alice, `{"content":"hi","client_id":"c-1"}`)
+	ack := alice.next(t)
+	delivered := bob.next(t)
+	if ack.Type != "ack" || ack.ID == 0 || ack.ID != delivered.ID || !strings.Contains(string(alice.last), `"client_id":"c-1"`) {
+		t.Errorf("Expected an ack for message %d, got %s", delivered.ID, alice.last)
+	}
+
+	sendFrame(t, alice, `{"content":"hi","client_id":"c-1"}`)
+	if got := alice.next(t); got.Type != "ack" || got.ID != delivered.ID || !strings.Contains(string(alice.last), `"duplicate":true`) {
+		t.Errorf("Expected a duplicate ack for message %d, got %s", delivered.ID, alice.last)
+	}
+	sendFrame(t, alice, `{"content":"next"}`)
+	if got := bob.next(t); got.Content != "next" {
+		t.Errorf("Expected the retransmission to be dropped, got %s", bob.last)
+	}
+
+	sendFrame(t, alice, fmt.Sprintf(`{"content":"x","client_id":%q}`, strings.Repeat("a", 65)))
+	if got := alice.next(t); got.Code != "invalid_client_id" {
+		t.Errorf("Expected invalid_client_id, got %s", alice.last)
+	}
+}
+
+// TestReliableRedelivery verifies that a reliable client gets the messages
+// after its last ack redelivered when it reconnects.
+func TestReliableRedelivery(t *testing.T) {
+	testServer := startSSETestServer(t, moderationUsers)
+	reliable := url.Values{"token": {"bob-token"}, "reliable": {"1"}}
+	alice := dialAsUser(t, testServer.URL, "alice-token")
+	bob := dialWithQuery(t, testServer.URL, reliable)
+
+	sendFrame(t, alice, `{"content":"one"}`)
+	sendFrame(t, alice, `{"content":"two"}`)
+	first := bob.next(t)
+	second := bob.next(t)
+	sendFrame(t, bob, fmt.Sprintf(`{"type":"ack","id":%d}`, first.ID))
+	time.Sleep(50 * time.Millisecond)
+	_ = bob.conn.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	sendFrame(t, alice, `{"content":"three"}`)
+	time.Sleep(50 * time.Millisecond)
+
+	bob = dialWithQuery(t, testServer.URL, reliable)
+	if got := bob.next(t); got.ID != second.ID || got.Content != "two" {
+		t.Errorf("Expected unacked message %d to be redelivered, got %s", second.ID, bob.last)
+	}
+	if got := bob.next(t); got.Content != "three" {
+		t.Errorf("Expected the message sent while offline, got %s", bob.last)
+	}
+
+	sendFrame(t, bob, `{"type":"ack","id":999999999}`)
+	if got := bob.next(t); got.Code != "message_not_found" {
+		t.Errorf("Expected message_not_found acking an unknown id, got %s", bob.last)
+	}
+}
+
+// TestReliableModeRequiresUser verifies that a guest connection asking for
+// reliable mode is refused, since guests have no ack cursor.
+func TestReliableModeRequiresUser(t *testing.T) {
+	testServer := startSSETestServer(t, moderationUsers)
+
+	conn, resp, err := websocket.DefaultDialer.Dial(buildWebSocketURL(t, testServer.URL)+"?reliable=1", newOriginHeader(testServer.URL))
+	if err == nil {
+		_ = conn.Close()
+		t.Fatal("Expected a guest reliable connection to be refused")
+	}
+	if resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %v", http.StatusBadRequest, resp)
+	}
+	_ = resp.Body.Close()
+}
@@ -0,0 +1,157 @@
+package integration
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Tyrowin/gochat/internal/server"
+	"github.com/gorilla/websocket"
+)
+
+// tryDialWebSocket attempts a WebSocket connection and returns the
+// connection, or the HTTP response if the upgrade was refused.
+func tryDialWebSocket(t *testing.T, baseURL string) (*websocket.Conn, *http.Response) {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial(buildWebSocketURL(t, baseURL), newOriginHeader(baseURL))
+	if err != nil {
+		if resp == nil {
+			t.Fatalf("Failed to dial WebSocket: %v", err)
+		}
+		_ = resp.Body.Close()
+		return nil, resp
+	}
+	_ = resp.Body.Close()
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn, nil
+}
+
+// dialUntilRefused opens connections until one is refused, failing if more
+// than limit connections succeed.
+func dialUntilRefused(t *testing.T, baseURL string, limit int) ([]*websocket.Conn, *http.Response) {
+	t.Helper()
+	var conns []*websocket.Conn
+	for i := 0; i <= limit; i++ {
+		conn, refused := tryDialWebSocket(t, baseURL)
+		if refused != nil {
+			return conns, refused
+		}
+		conns = append(conns, conn)
+	}
+	t.Fatalf("Expected a connection to be refused within %d attempts", limit+1)
+	return nil, nil
+}
+
+func expectRetryAfter(t *testing.T, resp *http.Response, wantStatus int) {
+	t.Helper()
+	if resp.StatusCode != wantStatus {
+		t.Errorf("Expected status %d, got %d", wantStatus, resp.StatusCode)
+	}
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 1 {
+		t.Errorf("Expected a positive Retry-After header, got %q", resp.Header.Get("Retry-After"))
+	}
+}
+
+// baselineConnections returns an upper bound on the connections already
+// counted against the test client's address.
+func baselineConnections() int {
+	time.Sleep(100 * time.Millisecond)
+	return server.GetHub().ClientCount()
+}
+
+// TestAdmissionLimitsConnectionsPerIP verifies that connections beyond the
+// per-IP limit are refused with 429 and that closing one frees a slot.
+func TestAdmissionLimitsConnectionsPerIP(t *testing.T) {
+	limit := baselineConnections() + 2
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		cfg.ConnectionLimits.MaxConnectionsPerIP = limit
+	})
+
+	conns, refused := dialUntilRefused(t, testServer.URL, limit)
+	expectRetryAfter(t, refused, http.StatusTooManyRequests)
+	if len(conns) == 0 {
+		t.Fatalf("Expected at least one connection to be admitted")
+	}
+
+	_ = conns[0].Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, resp := tryDialWebSocket(t, testServer.URL)
+		if conn != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Connection still refused with status %d after closing one", resp.StatusCode)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// TestAdmissionLimitsTotalConnections verifies that the global cap refuses
+// connections with 503.
+func TestAdmissionLimitsTotalConnections(t *testing.T) {
+	limit := baselineConnections() + 1
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		cfg.ConnectionLimits.MaxConnections = limit
+	})
+
+	_, refused := dialUntilRefused(t, testServer.URL, limit)
+	expectRetryAfter(t, refused, http.StatusServiceUnavailable)
+}
+
+// TestAdmissionIgnoresRejectedOrigins verifies that upgrades refused for
+// their origin do not use up the upgrade rate of the address.
+func TestAdmissionIgnoresRejectedOrigins(t *testing.T) {
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		cfg.ConnectionLimits.UpgradeBurst = 1
+		cfg.ConnectionLimits.UpgradeInterval = time.Minute
+	})
+
+	for i := 0; i < 3; i++ {
+		_, resp, err := websocket.DefaultDialer.Dial(buildWebSocketURL(t, testServer.URL), newOriginHeader("http://evil.example"))
+		if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("Expected the disallowed origin to be refused with 403, got %v", err)
+		}
+		_ = resp.Body.Close()
+	}
+
+	if conn, resp := tryDialWebSocket(t, testServer.URL); conn == nil {
+		t.Fatalf("Expected an allowed connection to be admitted, got status %d", resp.StatusCode)
+	}
+}
+
+// TestAdmissionLimitsUpgradeRate verifies that rapid connection attempts from
+// one address are refused with 429 once the burst is used up, on every
+// transport.
+func TestAdmissionLimitsUpgradeRate(t *testing.T) {
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		cfg.ConnectionLimits.UpgradeBurst = 2
+		cfg.ConnectionLimits.UpgradeInterval = time.Minute
+	})
+
+	for i := 0; i < 2; i++ {
+		if conn, resp := tryDialWebSocket(t, testServer.URL); conn == nil {
+			t.Fatalf("Connection %d refused with status %d", i, resp.StatusCode)
+		}
+	}
+
+	_, refused := tryDialWebSocket(t, testServer.URL)
+	if refused == nil {
+		t.Fatalf("Expected the third connection attempt to be refused")
+	}
+	expectRetryAfter(t, refused, http.StatusTooManyRequests)
+
+	req, err := http.NewRequest(http.MethodGet, testServer.URL+"/poll", http.NoBody)
+	if err != nil {
+		t.Fatalf("Failed to create poll request: %v", err)
+	}
+	req.Header.Set("Origin", testServer.URL)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to poll: %v", err)
+	}
+	_ = resp.Body.Close()
+	expectRetryAfter(t, resp, http.StatusTooManyRequests)
+}
@@ -0,0 +1,218 @@
+package integration
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tyrowin/gochat/internal/server"
+	"github.com/gorilla/websocket"
+)
+
+// auditEntry is a decoded line of the audit log.
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Actor  string    `json:"actor"`
+	IP     string    `json:"ip"`
+	Reason string    `json:"reason"`
+}
+
+// readAuditLog returns the records in the audit log at path.
+func readAuditLog(t *testing.T, path string) []auditEntry {
+	t.Helper()
+	file, err := os.Open(path) // #nosec G304 -- test temp file
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	var entries []auditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Invalid audit record %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// waitForAudit polls the audit log until it holds a record of action and
+// returns that record.
+func waitForAudit(t *testing.T, path, action string) auditEntry {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		for _, entry := range readAuditLog(t, path) {
+			if entry.Action == action {
+				return entry
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a %s audit record, got %+v", action, readAuditLog(t, path))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// TestAuditLogSecurityEvents verifies that rejected origins, failed
+// authentication, oversized messages and rate-limit drops are recorded with
+// the client IP, the identity when known, and a timestamp.
+func TestAuditLogSecurityEvents(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		moderationUsers(cfg)
+		cfg.AuditLogPath = auditPath
+		cfg.AdminToken = testAdminToken
+		cfg.MaxMessageSize = 128
+		cfg.RateLimit = server.RateLimitConfig{Burst: 1, RefillInterval: time.Hour}
+	})
+
+	header := http.Header{}
+	header.Set("Origin", "http://evil.example")
+	if _, resp, err := websocket.DefaultDialer.Dial(buildWebSocketURL(t, testServer.URL), header); err == nil {
+		t.Error("Expected connection from a disallowed origin to be refused")
+	} else if resp != nil {
+		_ = resp.Body.Close()
+	}
+	if got := waitForAudit(t, auditPath, "origin_rejected"); got.IP != "127.0.0.1" || !strings.Contains(got.Reason, "evil.example") || got.Time.IsZero() {
+		t.Errorf("Unexpected origin_rejected record: %+v", got)
+	}
+
+	if _, resp, err := websocket.DefaultDialer.Dial(buildWebSocketURL(t, testServer.URL)+"?token=bogus", newOriginHeader(testServer.URL)); err == nil {
+		t.Error("Expected unknown token to be refused")
+	} else if resp != nil {
+		_ = resp.Body.Close()
+	}
+	resp := adminRequest(t, http.MethodGet, testServer.URL+"/admin/bans", "wrong-token", "")
+	_ = resp.Body.Close()
+	var failures []auditEntry
+	for _, entry := range readAuditLog(t, auditPath) {
+		if entry.Action == "auth_failed" {
+			failures = append(failures, entry)
+		}
+	}
+	if len(failures) != 2 || failures[0].IP != "127.0.0.1" || failures[1].IP != "127.0.0.1" {
+		t.Errorf("Expected two auth_failed records with the client IP, got %+v", failures)
+	}
+
+	alice := dialAsUser(t, testServer.URL, "alice-token")
+	sendFrame(t, alice, `{"content":"first"}`)
+	sendFrame(t, alice, `{"content":"second"}`)
+	sendFrame(t, alice, `{"content":"third"}`)
+	if got := waitForAudit(t, auditPath, "rate_limited"); got.Actor != "alice" || got.IP != "127.0.0.1" {
+		t.Errorf("Unexpected rate_limited record: %+v", got)
+	}
+
+	bob := dialAsUser(t, testServer.URL, "bob-token")
+	sendFrame(t, bob, `{"content":"`+strings.Repeat("x", 256)+`"}`)
+	if got := waitForAudit(t, auditPath, "message_too_large"); got.Actor != "bob" || got.IP != "127.0.0.1" {
+		t.Errorf("Unexpected message_too_large record: %+v", got)
+	}
+	// Both drops belong to one throttling episode, recorded once.
+	throttled := 0
+	for _, entry := range readAuditLog(t, auditPath) {
+		if entry.Action == "rate_limited" {
+			throttled++
+		}
+	}
+	if throttled != 1 {
+		t.Errorf("Expected one rate_limited record for the episode, got %d", throttled)
+	}
+}
+
+// TestAuditLogRefusalsAndModeration verifies that moderation commands carry
+// the moderator's IP, that requests refused by the deny list or a user ban
+// are recorded, and that a client retrying while refused is recorded a
+// bounded number of times.
+func TestAuditLogRefusalsAndModeration(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		moderationUsers(cfg)
+		cfg.Users = append(cfg.Users, server.UserAccount{Name: "carol", Token: "carol-token"})
+		cfg.AuditLogPath = auditPath
+		cfg.AdminToken = testAdminToken
+		cfg.BanListPath = filepath.Join(t.TempDir(), "bans.json")
+		cfg.TrustedProxies = []string{"127.0.0.1"}
+		cfg.IPDenyList = []string{"203.0.113.0/24"}
+	})
+
+	mod := dialAsUser(t, testServer.URL, "mod-token")
+	sendFrame(t, mod, `{"type":"mute","target":"carol","duration":"1h"}`)
+	if got := waitForAudit(t, auditPath, "mute"); got.Actor != "mod" || got.IP != "127.0.0.1" {
+		t.Errorf("Unexpected mute record: %+v", got)
+	}
+
+	resp := adminRequest(t, http.MethodPost, testServer.URL+"/admin/bans", testAdminToken, `{"user":"carol","duration":"1h"}`)
+	_ = resp.Body.Close()
+	t.Cleanup(func() {
+		_ = adminRequest(t, http.MethodDelete, testServer.URL+"/admin/bans?user=carol", testAdminToken, "").Body.Close()
+	})
+	banned := http.Header{}
+	banned.Set("Authorization", "Bearer carol-token")
+	if status := dialWithHeaders(t, testServer.URL, banned); status != http.StatusForbidden {
+		t.Fatalf("Expected banned user to get %d, got %d", http.StatusForbidden, status)
+	}
+	if got := waitForAudit(t, auditPath, "access_denied"); got.Actor != "carol" || got.IP != "127.0.0.1" || got.Reason != "user is banned" {
+		t.Errorf("Unexpected access_denied record for a banned user: %+v", got)
+	}
+
+	const denied = "203.0.113.7"
+	for i := 0; i < 30; i++ {
+		if status := dialWithHeaders(t, testServer.URL, asClient(denied)); status != http.StatusForbidden {
+			t.Fatalf("Expected denied client to get %d, got %d", http.StatusForbidden, status)
+		}
+	}
+	var refusals []auditEntry
+	for _, entry := range readAuditLog(t, auditPath) {
+		if entry.Action == "access_denied" && entry.IP == denied {
+			refusals = append(refusals, entry)
+		}
+	}
+	// The budget of 20 records is followed by one saying that further
+	// refusals are not recorded.
+	if len(refusals) != 21 || refusals[0].Reason != "address is denied" || !strings.Contains(refusals[20].Reason, "suppressed") {
+		t.Errorf("Expected 21 access_denied records for %s, got %+v", denied, refusals)
+	}
+}
+
+// TestAuditLogRotation verifies that the audit log is rotated when it
+// reaches its maximum size and that only the configured number of rotated
+// files is kept.
+func TestAuditLogRotation(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		cfg.AuditLogPath = auditPath
+		cfg.AdminToken = testAdminToken
+		cfg.AuditMaxBytes = 200
+		cfg.AuditBackups = 2
+	})
+
+	for i := 0; i < 10; i++ {
+		resp := adminRequest(t, http.MethodGet, testServer.URL+"/admin/bans", "wrong-token", "")
+		_ = resp.Body.Close()
+	}
+
+	for _, path := range []string{auditPath, auditPath + ".1", auditPath + ".2"} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Expected %s to exist: %v", filepath.Base(path), err)
+		}
+		if info.Size() > 200 {
+			t.Errorf("Expected %s to stay within 200 bytes, got %d", filepath.Base(path), info.Size())
+		}
+	}
+	if _, err := os.Stat(auditPath + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected no more than two rotated files, got error %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+package integration
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tyrowin/gochat/internal/server"
+	"github.com/gorilla/websocket"
+)
+
+const testAdminToken = "admin-secret"
+
+// adminRequest sends an authenticated request to the admin API.
+func adminRequest(t *testing.T, method, url, token, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create admin request: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Admin request failed: %v", err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+// asClient returns headers that make a request from the trusted test proxy
+// appear to come from ip.
+func asClient(ip string) http.Header {
+	return forwardedHeader("X-Forwarded-For", ip)
+}
+
+// TestIPAllowAndDenyLists verifies that configured CIDR lists refuse
+// connections with 403 and that the deny list takes precedence.
+func TestIPAllowAndDenyLists(t *testing.T) {
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		cfg.TrustedProxies = []string{"127.0.0.1"}
+		cfg.IPAllowList = []string{"198.51.100.0/24", "203.0.113.0/24"}
+		cfg.IPDenyList = []string{"203.0.113.0/25"}
+	})
+
+	tests := []struct {
+		ip   string
+		want int
+	}{
+		{"198.51.100.7", 0},
+		{"203.0.113.200", 0},
+		{"203.0.113.7", http.StatusForbidden},
+		{"192.0.2.7", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if status := dialWithHeaders(t, testServer.URL, asClient(tt.ip)); status != tt.want {
+			t.Errorf("Client %s: expected status %d, got %d", tt.ip, tt.want, status)
+		}
+	}
+}
+
+// TestAdminBanDisconnectsAndPersists verifies that a runtime ban closes
+// matching connections with the ban reason, refuses new ones, survives a
+// reload from the ban file, and can be lifted.
+func TestAdminBanDisconnectsAndPersists(t *testing.T) {
+	banPath := filepath.Join(t.TempDir(), "bans.json")
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		cfg.TrustedProxies = []string{"127.0.0.1"}
+		cfg.AdminToken = testAdminToken
+		cfg.BanListPath = banPath
+	})
+	const banned = "203.0.113.50"
+
+	header := newOriginHeader(testServer.URL)
+	header.Set("X-Forwarded-For", banned)
+	conn, resp, err := websocket.DefaultDialer.Dial(buildWebSocketURL(t, testServer.URL), header)
+	if err != nil {
+		t.Fatalf("Failed to connect before ban: %v", err)
+	}
+	_ = resp.Body.Close()
+	defer func() { _ = conn.Close() }()
+	time.Sleep(50 * time.Millisecond)
+
+	resp = adminRequest(t, http.MethodPost, testServer.URL+"/admin/bans", testAdminToken,
+		`{"cidr":"`+banned+`","reason":"spam","duration":"1h"}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+	var created struct {
+		CIDR         string `json:"cidr"`
+		Disconnected int    `json:"disconnected"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode ban response: %v", err)
+	}
+	if created.CIDR != banned+"/32" || created.Disconnected != 1 {
+		t.Errorf("Unexpected ban response: %+v", created)
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatalf(errMsgReadDeadline, err)
+	}
+	_, _, err = conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != "banned: spam" {
+		t.Errorf("Expected policy violation close with reason, got %v", err)
+	}
+
+	if status := dialWithHeaders(t, testServer.URL, asClient(banned)); status != http.StatusForbidden {
+		t.Errorf("Expected banned client to get %d, got %d", http.StatusForbidden, status)
+	}
+
+	// Point the server at a copy of the ban file to simulate a restart.
+	data, err := os.ReadFile(banPath) // #nosec G304 -- test temp file
+	if err != nil {
+		t.Fatalf("Ban file was not written: %v", err)
+	}
+	reloadPath := filepath.Join(t.TempDir(), "bans.json")
+	if err := os.WriteFile(reloadPath, data, 0o600); err != nil {
+		t.Fatalf("Failed to copy ban file: %v", err)
+	}
+	configureServerForTest(t, testServer.URL, func(cfg *server.Config) {
+		cfg.TrustedProxies = []string{"127.0.0.1"}
+		cfg.AdminToken = testAdminToken
+		cfg.BanListPath = reloadPath
+	})
+	if status := dialWithHeaders(t, testServer.URL, asClient(banned)); status != http.StatusForbidden {
+		t.Errorf("Expected ban to survive reload, got status %d", status)
+	}
+
+	resp = adminRequest(t, http.MethodDelete, testServer.URL+"/admin/bans?cidr="+banned, testAdminToken, "")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected status %d lifting ban, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+	if status := dialWithHeaders(t, testServer.URL, asClient(banned)); status != 0 {
+		t.Errorf("Expected connection after lifting ban, got status %d", status)
+	}
+}
+
+// TestAdminBanExpires verifies that a ban stops applying after its duration.
+func TestAdminBanExpires(t *testing.T) {
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		cfg.TrustedProxies = []string{"127.0.0.1"}
+		cfg.AdminToken = testAdminToken
+	})
+	const banned = "203.0.113.60"
+
+	resp := adminRequest(t, http.MethodPost, testServer.URL+"/admin/bans", testAdminToken,
+		`{"cidr":"`+banned+`","duration":"300ms"}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+	if status := dialWithHeaders(t, testServer.URL, asClient(banned)); status != http.StatusForbidden {
+		t.Errorf("Expected banned client to get %d, got %d", http.StatusForbidden, status)
+	}
+
+	time.Sleep(400 * time.Millisecond)
+	if status := dialWithHeaders(t, testServer.URL, asClient(banned)); status != 0 {
+		t.Errorf("Expected connection after ban expired, got status %d", status)
+	}
+}
+
+// TestAdminBansRequireToken verifies authentication on the admin API.
+func TestAdminBansRequireToken(t *testing.T) {
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		cfg.AdminToken = testAdminToken
+	})
+
+	if resp := adminRequest(t, http.MethodGet, testServer.URL+"/admin/bans", "", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status %d without token, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+	if resp := adminRequest(t, http.MethodGet, testServer.URL+"/admin/bans", "wrong", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status %d with wrong token, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+	if resp := adminRequest(t, http.MethodPost, testServer.URL+"/admin/bans", testAdminToken, `{"cidr":"not-an-ip"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status %d for invalid CIDR, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+
+	configureServerForTest(t, testServer.URL, nil)
+	if resp := adminRequest(t, http.MethodGet, testServer.URL+"/admin/bans", testAdminToken, ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status %d with admin API disabled, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
@@ -0,0 +1,115 @@
+package integration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Tyrowin/gochat/internal/bench"
+	"github.com/Tyrowin/gochat/internal/server"
+	"github.com/Tyrowin/gochat/test/testhelpers"
+)
+
+// TestBenchRunAgainstServer runs a short benchmark against a live server and
+// verifies that messages are delivered and latency is measured.
+func TestBenchRunAgainstServer(t *testing.T) {
+	server.StartHub()
+
+	testServer := testhelpers.CreateTestServer(server.SetupRoutes())
+	defer testServer.Close()
+	configureServerForTest(t, testServer.URL, nil)
+
+	cfg := bench.DefaultConfig()
+	cfg.URL = buildWebSocketURL(t, testServer.URL)
+	cfg.Origin = testServer.URL
+	cfg.Clients = 4
+	cfg.RampRate = 0
+	cfg.MessageRate = 2
+	cfg.Duration = time.Second
+	cfg.DrainTimeout = 500 * time.Millisecond
+
+	report, err := bench.Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Benchmark run failed: %v", err)
+	}
+
+	if report.Connections.Established != int64(cfg.Clients) {
+		t.Errorf("Expected %d established connections, got %d", cfg.Clients, report.Connections.Established)
+	}
+	if report.Connections.Dropped != 0 {
+		t.Errorf("Expected no dropped connections, got %d", report.Connections.Dropped)
+	}
+	if report.Messages.Sent == 0 || report.Messages.Received == 0 {
+		t.Fatalf("Expected messages to flow, got %+v", report.Messages)
+	}
+	if report.Messages.RateLimited != 0 || report.Messages.Undelivered != 0 {
+		t.Errorf("Expected no rate-limited or undelivered messages at 2 msg/s, got %+v", report.Messages)
+	}
+	if report.Latency.Samples != uint64(report.Messages.Received) {
+		t.Errorf("Expected one latency sample per received message, got %d for %d",
+			report.Latency.Samples, report.Messages.Received)
+	}
+}
+
+// TestBenchRunReportsRateLimiting verifies that messages discarded by the
+// server's per-connection rate limiter are reported as rate limited.
+func TestBenchRunReportsRateLimiting(t *testing.T) {
+	server.StartHub()
+
+	testServer := testhelpers.CreateTestServer(server.SetupRoutes())
+	defer testServer.Close()
+	configureServerForTest(t, testServer.URL, func(cfg *server.Config) {
+		cfg.RateLimit = server.RateLimitConfig{Burst: 2, RefillInterval: 10 * time.Second}
+	})
+
+	cfg := bench.DefaultConfig()
+	cfg.URL = buildWebSocketURL(t, testServer.URL)
+	cfg.Origin = testServer.URL
+	cfg.Clients = 2
+	cfg.RampRate = 0
+	cfg.MessageRate = 10
+	cfg.Duration = 600 * time.Millisecond
+	cfg.DrainTimeout = 300 * time.Millisecond
+
+	report, err := bench.Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Benchmark run failed: %v", err)
+	}
+
+	// No tokens are refilled during the run, so each client is throttled in
+	// a single episode and told so once.
+	if report.Messages.RateLimited != int64(cfg.Clients) {
+		t.Errorf("Expected one rate_limited error per client, got %+v", report.Messages)
+	}
+	if report.Messages.Undelivered != 0 {
+		t.Errorf("Expected rate-limited messages not to count as undelivered, got %+v", report.Messages)
+	}
+}
+
+// TestBenchRunSingleClient verifies that a lone client, which has no peer to
+// deliver to, reports neither rate-limited nor undelivered messages.
+func TestBenchRunSingleClient(t *testing.T) {
+	server.StartHub()
+
+	testServer := testhelpers.CreateTestServer(server.SetupRoutes())
+	defer testServer.Close()
+	configureServerForTest(t, testServer.URL, nil)
+
+	cfg := bench.DefaultConfig()
+	cfg.URL = buildWebSocketURL(t, testServer.URL)
+	cfg.Origin = testServer.URL
+	cfg.Clients = 1
+	cfg.RampRate = 0
+	cfg.MessageRate = 2
+	cfg.Duration = 600 * time.Millisecond
+	cfg.DrainTimeout = 200 * time.Millisecond
+
+	report, err := bench.Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Benchmark run failed: %v", err)
+	}
+
+	if report.Messages.Sent == 0 || report.Messages.RateLimited != 0 || report.Messages.Undelivered != 0 {
+		t.Errorf("Expected sent messages with no losses reported, got %+v", report.Messages)
+	}
+}
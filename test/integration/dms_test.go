@@ -0,0 +1,147 @@
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/Tyrowin/gochat/internal/server"
+)
+
+// dmFrame is a direct message or one of the events about it.
+type dmFrame struct {
+	Type    string `json:"type"`
+	ID      uint64 `json:"id"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Content string `json:"content"`
+	Queued  bool   `json:"queued"`
+}
+
+func nextDM(t *testing.T, r *frameReader) dmFrame {
+	t.Helper()
+	r.next(t)
+	var f dmFrame
+	if err := json.Unmarshal(r.last, &f); err != nil {
+		t.Fatalf("Invalid frame %q: %v", r.last, err)
+	}
+	return f
+}
+
+// inboxSizes fetches the inbox sizes from the admin API.
+func inboxSizes(t *testing.T, baseURL string) map[string]int {
+	t.Helper()
+	resp := adminRequest(t, http.MethodGet, baseURL+"/admin/inboxes", testAdminToken, "")
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from /admin/inboxes, got %d", resp.StatusCode)
+	}
+	var body struct {
+		Inboxes []server.InboxSize `json:"inboxes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Invalid inboxes response: %v", err)
+	}
+	sizes := make(map[string]int)
+	for _, inbox := range body.Inboxes {
+		sizes[inbox.User] = inbox.Size
+	}
+	return sizes
+}
+
+func dmUsers(cfg *server.Config) {
+	moderationUsers(cfg)
+	cfg.AdminToken = testAdminToken
+	cfg.Inbox.MaxSize = 2
+}
+
+// TestDirectMessageQueuedForOfflineUser verifies that direct messages to an
+// offline user wait in a capped inbox, are delivered in order on connect,
+// and that the sender is told when they are delivered.
+func TestDirectMessageQueuedForOfflineUser(t *testing.T) {
+	testServer := startSSETestServer(t, dmUsers)
+	alice := dialAsUser(t, testServer.URL, "alice-token")
+
+	var ids []uint64
+	for _, content := range []string{"one", "two", "three"} {
+		sendFrame(t, alice, `{"type":"dm","target":"bob","content":"`+content+`"}`)
+		sent := nextDM(t, alice)
+		if sent.Type != "dm_sent" || !sent.Queued || sent.To != "bob" {
+			t.Fatalf("Expected the message to be queued, got %s", alice.last)
+		}
+		ids = append(ids, sent.ID)
+	}
+	if sizes := inboxSizes(t, testServer.URL); sizes["bob"] != 2 {
+		t.Errorf("Expected bob's inbox to be capped at 2, got %v", sizes)
+	}
+
+	bob := dialAsUser(t, testServer.URL, "bob-token")
+	for i, want := range []string{"two", "three"} {
+		got := nextDM(t, bob)
+		if got.Type != "dm" || got.From != "alice" || got.Content != want || got.ID != ids[i+1] {
+			t.Errorf("Expected queued message %q, got %s", want, bob.last)
+		}
+		if delivered := nextDM(t, alice); delivered.Type != "delivered" || delivered.ID != ids[i+1] {
+			t.Errorf("Expected delivery of %d, got %s", ids[i+1], alice.last)
+		}
+	}
+	if sizes := inboxSizes(t, testServer.URL); sizes["bob"] != 0 {
+		t.Errorf("Expected bob's inbox to be empty, got %v", sizes)
+	}
+}
+
+// TestDirectMessageToOnlineUser verifies immediate delivery and the
+// validation of direct messages.
+func TestDirectMessageToOnlineUser(t *testing.T) {
+	testServer := startSSETestServer(t, dmUsers)
+	alice := dialAsUser(t, testServer.URL, "alice-token")
+	bob := dialAsUser(t, testServer.URL, "bob-token")
+
+	sendFrame(t, alice, `{"type":"dm","target":"bob","content":"psst"}`)
+	if got := nextDM(t, bob); got.Type != "dm" || got.Content != "psst" {
+		t.Errorf("Expected the direct message, got %s", bob.last)
+	}
+	if got := nextDM(t, alice); got.Type != "dm_sent" || got.Queued {
+		t.Errorf("Expected an unqueued dm_sent, got %s", alice.last)
+	}
+	if got := nextDM(t, alice); got.Type != "delivered" {
+		t.Errorf("Expected a delivered event, got %s", alice.last)
+	}
+
+	sendFrame(t, alice, `{"type":"dm","target":"nobody","content":"hi"}`)
+	if got := alice.next(t); got.Code != "unknown_user" {
+		t.Errorf("Expected unknown_user, got %s", alice.last)
+	}
+	guest := &frameReader{conn: dialWebSocket(t, testServer.URL)}
+	sendFrame(t, guest, `{"type":"dm","target":"bob","content":"hi"}`)
+	if got := guest.next(t); got.Code != "forbidden" {
+		t.Errorf("Expected forbidden for a guest, got %s", guest.last)
+	}
+}
+
+// TestDirectMessageToParkedSessionWaitsInInbox verifies that a direct message
+// is only reported delivered once a live connection receives it, and that
+// its id does not collide with room message ids.
+func TestDirectMessageToParkedSessionWaitsInInbox(t *testing.T) {
+	testServer := startSSETestServer(t, dmUsers)
+	alice := dialAsUser(t, testServer.URL, "alice-token")
+	bob, session := dialResumable(t, testServer.URL, "")
+
+	sendFrame(t, alice, `{"content":"room message"}`)
+	room := bob.next(t)
+	dropConnection(bob)
+
+	sendFrame(t, alice, `{"type":"dm","target":"bob","content":"are you there?"}`)
+	sent := nextDM(t, alice)
+	if sent.Type != "dm_sent" || !sent.Queued || sent.ID <= room.ID {
+		t.Fatalf("Expected the message to be queued with an id after %d, got %s", room.ID, alice.last)
+	}
+
+	bob, _ = dialResumable(t, testServer.URL, session.ResumeToken)
+	if got := nextDM(t, bob); got.Type != "dm" || got.ID != sent.ID {
+		t.Errorf("Expected the queued message on resume, got %s", bob.last)
+	}
+	if got := nextDM(t, alice); got.Type != "delivered" || got.ID != sent.ID {
+		t.Errorf("Expected a delivered event after the resume, got %s", alice.last)
+	}
+}
@@ -0,0 +1,136 @@
+package integration
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/Tyrowin/gochat/internal/server"
+	"github.com/gorilla/websocket"
+)
+
+// drainSubprocessEnv marks the test process that runs a drain test. Draining
+// cannot be undone, so it must not affect the hub shared by other tests.
+const drainSubprocessEnv = "GOCHAT_DRAIN_SUBPROCESS"
+
+// runDrainSubprocess reruns the named test in a fresh process and reports
+// whether the caller is that process, which may drain its hub.
+func runDrainSubprocess(t *testing.T) bool {
+	t.Helper()
+	if os.Getenv(drainSubprocessEnv) == "1" {
+		return true
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^"+t.Name()+"$") // #nosec G204 -- reruns this test binary
+	cmd.Env = append(os.Environ(), drainSubprocessEnv+"=1")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Drain test failed: %v\n%s", err, output)
+	}
+	return false
+}
+
+// TestDrain verifies that a draining server reports not ready, refuses new
+// connections, tells connected clients to reconnect, and closes the
+// remaining WebSocket connections with 1001 Going Away within the drain
+// timeout.
+func TestDrain(t *testing.T) {
+	if !runDrainSubprocess(t) {
+		return
+	}
+
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		moderationUsers(cfg)
+		cfg.DrainTimeout = time.Second
+		cfg.ReconnectDelay = 200 * time.Millisecond
+	})
+	socket := dialAsUser(t, testServer.URL, "alice-token")
+	stream := openSSE(t, testServer.URL, nil)
+
+	drained := make(chan struct{})
+	start := time.Now()
+	go func() {
+		server.GetHub().Drain()
+		close(drained)
+	}()
+
+	if got := socket.next(t); got.Type != "server_shutting_down" {
+		t.Fatalf("Expected a server_shutting_down event, got %s", socket.last)
+	}
+	var event struct {
+		ReconnectAfter int `json:"reconnect_after"`
+	}
+	if err := json.Unmarshal(socket.last, &event); err != nil || event.ReconnectAfter != 1 {
+		t.Errorf("Expected reconnect_after of 1 second, got %s", socket.last)
+	}
+	if got := stream.next(t, 2*time.Second); got.Data == "" {
+		t.Errorf("Expected the SSE client to receive the shutdown event, got %+v", got)
+	}
+
+	if code, status := probe(t, testServer.URL+"/readyz"); code != http.StatusServiceUnavailable || status.Status != "draining" {
+		t.Errorf("Expected readiness to report draining, got %d %+v", code, status)
+	}
+	_, resp, err := websocket.DefaultDialer.Dial(buildWebSocketURL(t, testServer.URL), newOriginHeader(testServer.URL))
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("Expected new connections to be refused with 503 and Retry-After, got %v", err)
+	}
+	if resp != nil {
+		_ = resp.Body.Close()
+	}
+
+	if err := socket.conn.SetReadDeadline(time.Now().Add(3 * time.Second)); err != nil {
+		t.Fatalf(errMsgReadDeadline, err)
+	}
+	_, _, err = socket.conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway {
+		t.Errorf("Expected a 1001 Going Away close, got %v", err)
+	}
+
+	select {
+	case <-drained:
+		if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+			t.Errorf("Expected the drain to wait for the timeout while clients stayed, took %v", elapsed)
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("Expected the drain to end after the drain timeout")
+	}
+}
+
+// TestDrainEndsWhenResumableClientsLeave verifies that a resumable client
+// that disconnects during a drain is not kept for resumption, so the drain
+// ends as soon as it leaves instead of waiting for the timeout.
+func TestDrainEndsWhenResumableClientsLeave(t *testing.T) {
+	if !runDrainSubprocess(t) {
+		return
+	}
+
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		moderationUsers(cfg)
+		cfg.DrainTimeout = 5 * time.Second
+		cfg.ReconnectDelay = time.Second
+	})
+	bob, _ := dialResumable(t, testServer.URL, "")
+
+	drained := make(chan struct{})
+	go func() {
+		server.GetHub().Drain()
+		close(drained)
+	}()
+	if got := bob.next(t); got.Type != "server_shutting_down" {
+		t.Fatalf("Expected a server_shutting_down event, got %s", bob.last)
+	}
+	start := time.Now()
+	dropConnection(bob)
+
+	select {
+	case <-drained:
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Expected the drain to end once the client left, took %v", elapsed)
+		}
+	case <-time.After(6 * time.Second):
+		t.Error("Expected the drain to end")
+	}
+}
@@ -0,0 +1,89 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Tyrowin/gochat/internal/server"
+)
+
+// TestEditMessage verifies that authors can edit their messages, that the
+// edit is fanned out and replayed, and that blank edits and edits by other
+// members are refused.
+func TestEditMessage(t *testing.T) {
+	testServer := startSSETestServer(t, moderationUsers)
+	alice := dialAsUser(t, testServer.URL, "alice-token")
+	bob := dialAsUser(t, testServer.URL, "bob-token")
+
+	sendFrame(t, alice, `{"content":"helo"}`)
+	original := bob.next(t)
+	if original.ID == 0 || original.Content != "helo" {
+		t.Fatalf("Expected message with an id, got %+v", original)
+	}
+
+	sendFrame(t, bob, fmt.Sprintf(`{"type":"edit","id":%d,"content":"hijacked"}`, original.ID))
+	if got := bob.next(t); got.Code != "forbidden" {
+		t.Errorf("Expected forbidden error editing another member's message, got %+v", got)
+	}
+
+	sendFrame(t, alice, fmt.Sprintf(`{"type":"edit","id":%d,"content":" \t "}`, original.ID))
+	if got := alice.next(t); got.Code != "empty_edit" {
+		t.Errorf("Expected empty_edit error for a blank edit, got %+v", got)
+	}
+
+	sendFrame(t, alice, fmt.Sprintf(`{"type":"edit","id":%d,"content":"hello"}`, original.ID))
+	for _, client := range []*frameReader{alice, bob} {
+		got := client.next(t)
+		if got.Type != "edit" || got.ID != original.ID || got.Message == nil ||
+			got.Message.Content != "hello" || got.Message.EditedAt == nil {
+			t.Errorf("Expected edit event, got %+v", got)
+		}
+	}
+
+	_, session := poll(t, testServer.URL, url.Values{"cursor": {strconv.FormatUint(original.ID-1, 10)}})
+	status, replay := poll(t, testServer.URL, url.Values{"session": {session.SessionID}, "timeout": {"2"}})
+	if status != http.StatusOK || len(replay.Messages) == 0 || replay.Messages[0].Message.Content != "hello" {
+		t.Errorf("Expected replay to carry the edited content, got %d %+v", status, replay)
+	}
+}
+
+// TestDeleteMessageLeavesTombstone verifies that a moderator can delete a
+// member's message and that replay returns a tombstone without its content.
+func TestDeleteMessageLeavesTombstone(t *testing.T) {
+	testServer := startSSETestServer(t, moderationUsers)
+	mod := dialAsUser(t, testServer.URL, "mod-token")
+	alice := dialAsUser(t, testServer.URL, "alice-token")
+
+	sendFrame(t, alice, `{"content":"secret"}`)
+	original := mod.next(t)
+
+	sendFrame(t, mod, fmt.Sprintf(`{"type":"delete","id":%d}`, original.ID))
+	if got := alice.next(t); got.Type != "delete" || got.ID != original.ID {
+		t.Errorf("Expected delete event, got %+v", got)
+	}
+	mod.next(t)
+
+	stream := openSSE(t, testServer.URL, http.Header{"Last-Event-ID": {strconv.FormatUint(original.ID-1, 10)}})
+	event := stream.next(t, 2*time.Second)
+	var replayed server.Message
+	if err := json.Unmarshal([]byte(event.Data), &replayed); err != nil {
+		t.Fatalf("Invalid replayed message %q: %v", event.Data, err)
+	}
+	if !replayed.Deleted || replayed.Content != "" || replayed.Sender != "" || replayed.ID != original.ID {
+		t.Errorf("Expected tombstone, got %+v", replayed)
+	}
+
+	sendFrame(t, alice, fmt.Sprintf(`{"type":"edit","id":%d,"content":"again"}`, original.ID))
+	if got := alice.next(t); got.Code != "message_not_found" {
+		t.Errorf("Expected message_not_found editing a tombstone, got %+v", got)
+	}
+	sendFrame(t, alice, `{"type":"delete","id":999999999}`)
+	if got := alice.next(t); got.Code != "message_not_found" {
+		t.Errorf("Expected message_not_found for unknown id, got %+v", got)
+	}
+}
@@ -0,0 +1,108 @@
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/Tyrowin/gochat/internal/server"
+)
+
+// healthStatus mirrors the body of GET /healthz and GET /readyz.
+type healthStatus struct {
+	Status     string `json:"status"`
+	Version    string `json:"version"`
+	Uptime     *int64 `json:"uptime_seconds"`
+	Clients    int    `json:"clients"`
+	Components map[string]struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	} `json:"components"`
+}
+
+// probe requests a health endpoint and decodes its response.
+func probe(t *testing.T, url string) (int, healthStatus) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf(errFailedRequest, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Expected application/json, got %s", contentType)
+	}
+	var status healthStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode health response: %v", err)
+	}
+	return resp.StatusCode, status
+}
+
+// TestHealthProbes verifies that both probes report the version, uptime,
+// client count and component status of a ready server.
+func TestHealthProbes(t *testing.T) {
+	dir := t.TempDir()
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		cfg.BanListPath = filepath.Join(dir, "bans.json")
+	})
+	dialWebSocket(t, testServer.URL)
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		code, status := probe(t, testServer.URL+path)
+		if code != http.StatusOK || status.Status != "ok" {
+			t.Errorf("Expected %s to report ok, got %d %+v", path, code, status)
+		}
+		if status.Version == "" || status.Uptime == nil || status.Clients < 1 {
+			t.Errorf("Expected %s to report version, uptime and clients, got %+v", path, status)
+		}
+		if status.Components["hub"].Status != "ok" || status.Components["ban_list"].Status != "ok" {
+			t.Errorf("Expected hub and ban list components to be ok, got %+v", status.Components)
+		}
+	}
+}
+
+// TestReadinessFailsWhenStorageIsUnreachable verifies that readiness fails,
+// and liveness does not, when a storage directory is missing.
+func TestReadinessFailsWhenStorageIsUnreachable(t *testing.T) {
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		cfg.AuditLogPath = filepath.Join(t.TempDir(), "missing", "audit.log")
+	})
+
+	code, status := probe(t, testServer.URL+"/readyz")
+	if code != http.StatusServiceUnavailable || status.Status != "unavailable" {
+		t.Errorf("Expected readiness to fail, got %d %+v", code, status)
+	}
+	if component := status.Components["audit_log"]; component.Status != "unavailable" || component.Error == "" {
+		t.Errorf("Expected the audit log component to be unavailable with an error, got %+v", component)
+	}
+
+	if code, status := probe(t, testServer.URL+"/healthz"); code != http.StatusOK || status.Status != "ok" {
+		t.Errorf("Expected liveness to succeed, got %d %+v", code, status)
+	}
+}
+
+// TestUnknownPathsAreNotFound verifies that the health check is served on /
+// only, so unknown paths are not mistaken for it.
+func TestUnknownPathsAreNotFound(t *testing.T) {
+	testServer := startSSETestServer(t, nil)
+
+	resp, err := http.Get(testServer.URL + "/nonexistent")
+	if err != nil {
+		t.Fatalf(errFailedRequest, err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf(errExpectedStatusCode, http.StatusNotFound, resp.StatusCode)
+	}
+
+	resp, err = http.Post(testServer.URL+"/readyz", "text/plain", http.NoBody)
+	if err != nil {
+		t.Fatalf(errFailedRequest, err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf(errExpectedStatusCode, http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+}
@@ -0,0 +1,133 @@
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+// historyPage mirrors the body of GET /api/v1/rooms/{room}/messages.
+type historyPage struct {
+	Room     string `json:"room"`
+	Messages []struct {
+		ID      uint64 `json:"id"`
+		Content string `json:"content"`
+		Sender  string `json:"sender"`
+		Deleted bool   `json:"deleted"`
+	} `json:"messages"`
+	HasMore bool `json:"has_more"`
+}
+
+func (p historyPage) ids() []uint64 {
+	ids := make([]uint64, 0, len(p.Messages))
+	for _, msg := range p.Messages {
+		ids = append(ids, msg.ID)
+	}
+	return ids
+}
+
+// fetchHistory requests a page of a room's history as the user holding token.
+func fetchHistory(t *testing.T, baseURL, room, token string, query url.Values) (int, historyPage) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, baseURL+"/api/v1/rooms/"+room+"/messages?"+query.Encode(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create history request: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("History request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var page historyPage
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+			t.Fatalf("Invalid history response: %v", err)
+		}
+	}
+	return resp.StatusCode, page
+}
+
+func equalIDs(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestHistoryPaging verifies paging backwards and forwards through the
+// history with stable ordering.
+func TestHistoryPaging(t *testing.T) {
+	testServer := startSSETestServer(t, searchUsers)
+
+	var ids []uint64
+	for i := 0; i < 5; i++ {
+		status, id := postIngest(t, testServer.URL, "general", bearer("ci-key"), `{"content":"page `+strconv.Itoa(i)+`"}`)
+		if status != http.StatusCreated {
+			t.Fatalf("Expected 201 from ingest, got %d", status)
+		}
+		ids = append(ids, id)
+	}
+	cursor := func(id uint64) string { return strconv.FormatUint(id, 10) }
+
+	status, latest := fetchHistory(t, testServer.URL, "general", "alice-token", url.Values{"limit": {"2"}})
+	if status != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", status)
+	}
+	if !equalIDs(latest.ids(), ids[3:]) || !latest.HasMore || latest.Room != "general" {
+		t.Errorf("Expected the latest two messages %v, got %+v", ids[3:], latest)
+	}
+	if latest.Messages[1].Content != "page 4" || latest.Messages[1].Sender != "ci-bot" {
+		t.Errorf("Expected messages in the client format, got %+v", latest.Messages[1])
+	}
+
+	_, older := fetchHistory(t, testServer.URL, "general", "alice-token", url.Values{"before": {cursor(ids[3])}, "limit": {"2"}})
+	if !equalIDs(older.ids(), ids[1:3]) {
+		t.Errorf("Expected %v before %d, got %v", ids[1:3], ids[3], older.ids())
+	}
+
+	_, newer := fetchHistory(t, testServer.URL, "general", "alice-token", url.Values{"after": {cursor(ids[1])}, "limit": {"2"}})
+	if !equalIDs(newer.ids(), ids[2:4]) || !newer.HasMore {
+		t.Errorf("Expected %v after %d with more, got %+v", ids[2:4], ids[1], newer)
+	}
+	_, last := fetchHistory(t, testServer.URL, "general", "alice-token", url.Values{"after": {cursor(ids[3])}})
+	if !equalIDs(last.ids(), ids[4:]) || last.HasMore {
+		t.Errorf("Expected only %d and no more, got %+v", ids[4], last)
+	}
+}
+
+// TestHistoryRejectsInvalidRequests verifies authentication, membership and
+// parameter validation.
+func TestHistoryRejectsInvalidRequests(t *testing.T) {
+	testServer := startSSETestServer(t, searchUsers)
+
+	cases := []struct {
+		name  string
+		room  string
+		token string
+		query url.Values
+		want  int
+	}{
+		{"guest", "general", "", nil, http.StatusUnauthorized},
+		{"unknown token", "general", "nope", nil, http.StatusUnauthorized},
+		{"unknown room", "random", "alice-token", nil, http.StatusNotFound},
+		{"both cursors", "general", "alice-token", url.Values{"before": {"5"}, "after": {"1"}}, http.StatusBadRequest},
+		{"bad cursor", "general", "alice-token", url.Values{"before": {"x"}}, http.StatusBadRequest},
+		{"bad limit", "general", "alice-token", url.Values{"limit": {"0"}}, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		if status, _ := fetchHistory(t, testServer.URL, tc.room, tc.token, tc.query); status != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, status)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tyrowin/gochat/internal/server"
+)
+
+// postIngest sends a message to the ingestion API and returns the status and
+// the assigned id, if any.
+func postIngest(t *testing.T, baseURL, room string, header http.Header, body string) (int, uint64) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, baseURL+"/api/v1/rooms/"+room+"/messages", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create ingest request: %v", err)
+	}
+	req.Header = header
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to post message: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var result struct {
+		ID   uint64 `json:"id"`
+		Room string `json:"room"`
+	}
+	if resp.StatusCode == http.StatusCreated {
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode ingest response: %v", err)
+		}
+		if result.Room != room {
+			t.Errorf("Expected room %q in response, got %q", room, result.Room)
+		}
+	}
+	return resp.StatusCode, result.ID
+}
+
+func bearer(key string) http.Header {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+key)
+	return header
+}
+
+// TestIngestBroadcastsAsIntegration verifies that an authenticated
+// integration's message reaches WebSocket clients with the integration name
+// as sender, and that the assigned id is returned.
+func TestIngestBroadcastsAsIntegration(t *testing.T) {
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		cfg.APIKeys = []server.APIKey{{Name: "ci-bot", Key: "ci-key"}}
+	})
+	receiver := dialWebSocket(t, testServer.URL)
+
+	status, id := postIngest(t, testServer.URL, server.DefaultRoom, bearer("ci-key"), `{"content":"build passed","sender":"spoofed"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d", http.StatusCreated, status)
+	}
+	if id == 0 {
+		t.Errorf("Expected a message id in the response")
+	}
+
+	if err := receiver.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatalf(errMsgReadDeadline, err)
+	}
+	var msg server.Message
+	if err := receiver.ReadJSON(&msg); err != nil {
+		t.Fatalf("Failed to read broadcast: %v", err)
+	}
+	if msg.Content != "build passed" || msg.Sender != "ci-bot" {
+		t.Errorf("Expected message from ci-bot, got %+v", msg)
+	}
+
+	_, next := postIngest(t, testServer.URL, server.DefaultRoom, bearer("ci-key"), `{"content":"deploy started"}`)
+	if next <= id {
+		t.Errorf("Expected increasing message ids, got %d after %d", next, id)
+	}
+}
+
+// TestIngestIgnoresKeysNamedAfterUsers verifies that an API key whose name
+// belongs to a user or guest is ignored, so an integration cannot post as
+// them.
+func TestIngestIgnoresKeysNamedAfterUsers(t *testing.T) {
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		moderationUsers(cfg)
+		cfg.APIKeys = []server.APIKey{
+			{Name: "alice", Key: "alice-key"},
+			{Name: "guest-bot", Key: "guest-key"},
+			{Name: "ci-bot", Key: "ci-key"},
+		}
+	})
+
+	for _, key := range []string{"alice-key", "guest-key"} {
+		if status, _ := postIngest(t, testServer.URL, server.DefaultRoom, bearer(key), `{"content":"x"}`); status != http.StatusUnauthorized {
+			t.Errorf("Expected key %q to be refused with %d, got %d", key, http.StatusUnauthorized, status)
+		}
+	}
+	if status, _ := postIngest(t, testServer.URL, server.DefaultRoom, bearer("ci-key"), `{"content":"x"}`); status != http.StatusCreated {
+		t.Errorf("Expected the ci-bot key to be accepted, got %d", status)
+	}
+}
+
+// TestIngestRejectsInvalidRequests verifies authentication, room, size and
+// validation checks on the ingestion API.
+func TestIngestRejectsInvalidRequests(t *testing.T) {
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		cfg.MaxMessageSize = 64
+		cfg.APIKeys = []server.APIKey{{Name: "alerts", Key: "alerts-key"}}
+	})
+
+	apiKeyHeader := http.Header{}
+	apiKeyHeader.Set("X-API-Key", "alerts-key")
+
+	tests := []struct {
+		name   string
+		room   string
+		header http.Header
+		body   string
+		want   int
+	}{
+		{"missing key", server.DefaultRoom, http.Header{}, `{"content":"x"}`, http.StatusUnauthorized},
+		{"wrong key", server.DefaultRoom, bearer("nope"), `{"content":"x"}`, http.StatusUnauthorized},
+		{"unknown room", "elsewhere", bearer("alerts-key"), `{"content":"x"}`, http.StatusNotFound},
+		{"oversized body", server.DefaultRoom, bearer("alerts-key"), `{"content":"` + strings.Repeat("a", 100) + `"}`, http.StatusRequestEntityTooLarge},
+		{"invalid json", server.DefaultRoom, bearer("alerts-key"), `not json`, http.StatusBadRequest},
+		{"x-api-key header", server.DefaultRoom, apiKeyHeader, `{"content":"ok"}`, http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _ := postIngest(t, testServer.URL, tt.room, tt.header, tt.body)
+			if status != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, status)
+			}
+		})
+	}
+}
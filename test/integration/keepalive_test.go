@@ -0,0 +1,156 @@
+package integration
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Tyrowin/gochat/internal/server"
+	"github.com/gorilla/websocket"
+)
+
+// awaitPing reads from conn until the server pings it or timeout passes.
+func awaitPing(t *testing.T, conn *websocket.Conn, timeout time.Duration) bool {
+	t.Helper()
+	pinged := make(chan struct{}, 1)
+	conn.SetPingHandler(func(string) error {
+		select {
+		case pinged <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		t.Fatalf(errMsgReadDeadline, err)
+	}
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	select {
+	case <-pinged:
+		return true
+	default:
+		return false
+	}
+}
+
+// TestKeepalivePingPeriod verifies that WebSocket clients are pinged at the
+// configured period.
+func TestKeepalivePingPeriod(t *testing.T) {
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		cfg.Keepalive = server.KeepaliveConfig{PongWait: 2 * time.Second, PingPeriod: 100 * time.Millisecond}
+	})
+
+	conn := dialWebSocket(t, testServer.URL)
+	if !awaitPing(t, conn, 500*time.Millisecond) {
+		t.Error("Expected a ping within 500ms")
+	}
+}
+
+// TestKeepalivePingPeriodIsValidated verifies that a ping period that is not
+// shorter than the pong wait is replaced by one that is, so that clients
+// are pinged before their connection times out.
+func TestKeepalivePingPeriodIsValidated(t *testing.T) {
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		cfg.Keepalive = server.KeepaliveConfig{PongWait: 300 * time.Millisecond, PingPeriod: time.Minute}
+	})
+
+	conn := dialWebSocket(t, testServer.URL)
+	if !awaitPing(t, conn, 2*time.Second) {
+		t.Error("Expected a ping before the pong wait elapsed")
+	}
+}
+
+// TestKeepalivePongWaitClosesSilentConnections verifies that a connection
+// that does not answer pings is closed after the pong wait.
+func TestKeepalivePongWaitClosesSilentConnections(t *testing.T) {
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		cfg.Keepalive = server.KeepaliveConfig{PongWait: 200 * time.Millisecond, PingPeriod: 100 * time.Millisecond}
+	})
+
+	conn := dialWebSocket(t, testServer.URL)
+	// Not reading means pings go unanswered.
+	time.Sleep(600 * time.Millisecond)
+
+	conn.SetPingHandler(func(string) error { return nil })
+	if err := conn.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatalf(errMsgReadDeadline, err)
+	}
+	var err error
+	for err == nil {
+		_, _, err = conn.ReadMessage()
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		t.Errorf("Expected the server to close the silent connection, got %v", err)
+	}
+}
+
+// TestKeepaliveTransportOverride verifies that a per-transport override
+// applies to its transport only: long polls are answered after the
+// overridden ping period.
+func TestKeepaliveTransportOverride(t *testing.T) {
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		cfg.Keepalive.Transports = map[string]server.KeepalivePolicy{"longpoll": {PingPeriod: 200 * time.Millisecond}}
+	})
+	session := openPollSession(t, testServer.URL)
+
+	start := time.Now()
+	status, _ := poll(t, testServer.URL, url.Values{"session": {session.SessionID}, "timeout": {"5"}})
+	if status != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, status)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the poll to return after the overridden period, took %v", elapsed)
+	}
+
+	conn := dialWebSocket(t, testServer.URL)
+	if awaitPing(t, conn, 500*time.Millisecond) {
+		t.Error("Expected the WebSocket ping period to be unaffected by the long-poll override")
+	}
+	// Let the hub unregister the client before the next test counts clients.
+	_ = conn.Close()
+	time.Sleep(50 * time.Millisecond)
+}
+
+// TestCreateServerUsesConfiguredTimeouts verifies that the HTTP server
+// timeouts come from the configuration.
+func TestCreateServerUsesConfiguredTimeouts(t *testing.T) {
+	configureServerForTest(t, "http://localhost", func(cfg *server.Config) {
+		cfg.ReadTimeout = 5 * time.Second
+		cfg.WriteTimeout = 20 * time.Second
+		cfg.IdleTimeout = 25 * time.Second
+	})
+
+	srv := server.CreateServer(":0", http.NewServeMux())
+	if srv.ReadTimeout != 5*time.Second || srv.WriteTimeout != 20*time.Second || srv.IdleTimeout != 25*time.Second {
+		t.Errorf("Expected timeouts 5s/20s/25s, got %v/%v/%v", srv.ReadTimeout, srv.WriteTimeout, srv.IdleTimeout)
+	}
+}
+
+// TestDurationSettingsAcceptGoDurations verifies that duration settings take
+// either whole seconds or Go duration syntax, and that invalid values keep
+// the default.
+func TestDurationSettingsAcceptGoDurations(t *testing.T) {
+	t.Setenv("KEEPALIVE_PONG_WAIT", "90s")
+	t.Setenv("KEEPALIVE_PING_PERIOD", "45")
+	t.Setenv("HTTP_IDLE_TIMEOUT", "2m")
+	t.Setenv("DRAIN_TIMEOUT", "soon")
+	t.Setenv("RETENTION_ROOMS", "general:720h:")
+
+	cfg := server.NewConfigFromEnv()
+	if cfg.Keepalive.PongWait != 90*time.Second || cfg.Keepalive.PingPeriod != 45*time.Second || cfg.IdleTimeout != 2*time.Minute {
+		t.Errorf("Expected 90s, 45s and 2m, got %s, %s and %s", cfg.Keepalive.PongWait, cfg.Keepalive.PingPeriod, cfg.IdleTimeout)
+	}
+	if want := server.NewConfig().DrainTimeout; cfg.DrainTimeout != want {
+		t.Errorf("Expected the default drain timeout %s for an invalid value, got %s", want, cfg.DrainTimeout)
+	}
+	if got := cfg.Retention.Rooms["general"].MaxAge; got != 720*time.Hour {
+		t.Errorf("Expected a 720h room retention age, got %s", got)
+	}
+}
@@ -0,0 +1,271 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Tyrowin/gochat/internal/server"
+	"github.com/gorilla/websocket"
+)
+
+// pollResponse mirrors the body returned by GET /poll.
+type pollResponse struct {
+	SessionID string `json:"session_id"`
+	Cursor    uint64 `json:"cursor"`
+	Messages  []struct {
+		ID      uint64         `json:"id"`
+		Message server.Message `json:"message"`
+	} `json:"messages"`
+}
+
+// poll issues GET /poll with the given query and decodes the response.
+func poll(t *testing.T, baseURL string, query url.Values) (int, pollResponse) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, baseURL+"/poll?"+query.Encode(), http.NoBody)
+	if err != nil {
+		t.Fatalf("Failed to create poll request: %v", err)
+	}
+	req.Header.Set("Origin", baseURL)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to poll: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var body pollResponse
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("Failed to decode poll response: %v", err)
+		}
+	}
+	return resp.StatusCode, body
+}
+
+// openPollSession creates a new long-poll session.
+func openPollSession(t *testing.T, baseURL string) pollResponse {
+	t.Helper()
+	status, session := poll(t, baseURL, url.Values{})
+	if status != http.StatusOK || session.SessionID == "" {
+		t.Fatalf("Failed to create long-poll session: status %d, body %+v", status, session)
+	}
+	return session
+}
+
+// TestLongPollSessionRegistersWithHub verifies that a long-poll session is
+// counted as a hub member.
+func TestLongPollSessionRegistersWithHub(t *testing.T) {
+	testServer := startSSETestServer(t, nil)
+
+	before := server.GetHub().ClientCount()
+	openPollSession(t, testServer.URL)
+
+	if after := server.GetHub().ClientCount(); after != before+1 {
+		t.Errorf("Expected %d hub clients after creating a session, got %d", before+1, after)
+	}
+}
+
+// TestLongPollReceivesBroadcasts verifies that a waiting poll returns as soon
+// as a WebSocket client broadcasts, and that the cursor advances.
+func TestLongPollReceivesBroadcasts(t *testing.T) {
+	testServer := startSSETestServer(t, nil)
+
+	session := openPollSession(t, testServer.URL)
+	sender := dialWebSocket(t, testServer.URL)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = sender.WriteMessage(websocket.TextMessage, mustMarshalMessage(t, "to poller"))
+	}()
+
+	start := time.Now()
+	status, body := poll(t, testServer.URL, url.Values{"session": {session.SessionID}, "timeout": {"5"}})
+	if status != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, status)
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Errorf("Poll did not return promptly when a message arrived")
+	}
+	if len(body.Messages) != 1 || body.Messages[0].Message.Content != "to poller" {
+		t.Fatalf("Unexpected poll messages: %+v", body.Messages)
+	}
+	if body.Cursor != body.Messages[0].ID || body.Cursor <= session.Cursor {
+		t.Errorf("Expected cursor to advance to %d, got %d", body.Messages[0].ID, body.Cursor)
+	}
+}
+
+// TestLongPollCursorSkipsServerEvents verifies that the cursor follows the
+// highest message id in a batch even when the batch ends with an event that
+// has no id, so that the next poll does not replay the message.
+func TestLongPollCursorSkipsServerEvents(t *testing.T) {
+	testServer := startSSETestServer(t, nil)
+
+	session := openPollSession(t, testServer.URL)
+	sender := dialWebSocket(t, testServer.URL)
+	if err := sender.WriteMessage(websocket.TextMessage, mustMarshalMessage(t, "before typing")); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	if err := sender.WriteMessage(websocket.TextMessage, []byte(`{"type":"typing_start"}`)); err != nil {
+		t.Fatalf("Failed to send typing notification: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	_, body := poll(t, testServer.URL, url.Values{"session": {session.SessionID}, "timeout": {"2"}})
+	if len(body.Messages) != 2 || body.Messages[1].ID != 0 {
+		t.Fatalf("Expected a message followed by a typing event, got %+v", body.Messages)
+	}
+	if body.Cursor != body.Messages[0].ID {
+		t.Fatalf("Expected cursor %d, got %d", body.Messages[0].ID, body.Cursor)
+	}
+
+	query := url.Values{"session": {session.SessionID}, "cursor": {fmt.Sprint(body.Cursor)}, "timeout": {"1"}}
+	if _, next := poll(t, testServer.URL, query); len(next.Messages) != 0 {
+		t.Errorf("Expected no replayed messages, got %+v", next.Messages)
+	}
+
+	// Stop typing so that no typing event reaches clients of later tests.
+	if err := sender.WriteMessage(websocket.TextMessage, []byte(`{"type":"typing_stop"}`)); err != nil {
+		t.Fatalf("Failed to send typing notification: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+}
+
+// TestLongPollTimesOutWithoutMessages verifies that an idle poll returns an
+// empty batch after the requested timeout.
+func TestLongPollTimesOutWithoutMessages(t *testing.T) {
+	testServer := startSSETestServer(t, nil)
+	session := openPollSession(t, testServer.URL)
+
+	start := time.Now()
+	status, body := poll(t, testServer.URL, url.Values{"session": {session.SessionID}, "timeout": {"1"}})
+	if status != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, status)
+	}
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("Poll returned after %v, expected it to wait for the timeout", elapsed)
+	}
+	if len(body.Messages) != 0 {
+		t.Errorf("Expected no messages, got %+v", body.Messages)
+	}
+}
+
+// TestLongPollSendsThroughMessagesEndpoint verifies that long-poll clients
+// send through POST /messages and reach WebSocket clients.
+func TestLongPollSendsThroughMessagesEndpoint(t *testing.T) {
+	testServer := startSSETestServer(t, nil)
+
+	session := openPollSession(t, testServer.URL)
+	receiver := dialWebSocket(t, testServer.URL)
+
+	status := postMessage(t, testServer.URL, testServer.URL, session.SessionID, `{"content":"from poller"}`)
+	if status != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d", http.StatusAccepted, status)
+	}
+
+	if err := receiver.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatalf(errMsgReadDeadline, err)
+	}
+	var msg server.Message
+	if err := receiver.ReadJSON(&msg); err != nil {
+		t.Fatalf("Failed to read broadcast: %v", err)
+	}
+	if msg.Content != "from poller" {
+		t.Errorf("Expected content %q, got %q", "from poller", msg.Content)
+	}
+}
+
+// TestLongPollResendsFromStaleCursor verifies that a client which lost a
+// poll response gets the messages again by sending its previous cursor.
+func TestLongPollResendsFromStaleCursor(t *testing.T) {
+	testServer := startSSETestServer(t, nil)
+
+	session := openPollSession(t, testServer.URL)
+	sender := dialWebSocket(t, testServer.URL)
+
+	for i := 0; i < 2; i++ {
+		if err := sender.WriteMessage(websocket.TextMessage, mustMarshalMessage(t, fmt.Sprintf("lost %d", i))); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// The client never sees this response.
+	_, lost := poll(t, testServer.URL, url.Values{"session": {session.SessionID}, "timeout": {"2"}})
+	if len(lost.Messages) != 2 {
+		t.Fatalf("Expected 2 messages in first poll, got %d", len(lost.Messages))
+	}
+
+	query := url.Values{
+		"session": {session.SessionID},
+		"cursor":  {fmt.Sprint(session.Cursor)},
+		"timeout": {"1"},
+	}
+	status, retry := poll(t, testServer.URL, query)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, status)
+	}
+	if len(retry.Messages) != 2 || retry.Messages[0].Message.Content != "lost 0" {
+		t.Fatalf("Expected the lost messages to be resent, got %+v", retry.Messages)
+	}
+	if retry.Cursor != lost.Cursor {
+		t.Errorf("Expected cursor %d after resend, got %d", lost.Cursor, retry.Cursor)
+	}
+}
+
+// TestLongPollRejectsInvalidRequests verifies session, concurrency and origin
+// checks on GET /poll.
+func TestLongPollRejectsInvalidRequests(t *testing.T) {
+	testServer := startSSETestServer(t, nil)
+
+	t.Run("unknown session", func(t *testing.T) {
+		status, _ := poll(t, testServer.URL, url.Values{"session": {"missing"}})
+		if status != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, status)
+		}
+	})
+
+	t.Run("concurrent poll", func(t *testing.T) {
+		session := openPollSession(t, testServer.URL)
+		query := url.Values{"session": {session.SessionID}, "timeout": {"1"}}
+
+		var wg sync.WaitGroup
+		statuses := make([]int, 2)
+		for i := range statuses {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if i == 1 {
+					time.Sleep(100 * time.Millisecond)
+				}
+				statuses[i], _ = poll(t, testServer.URL, query)
+			}(i)
+		}
+		wg.Wait()
+
+		if statuses[0] != http.StatusOK || statuses[1] != http.StatusConflict {
+			t.Errorf("Expected statuses [200 409], got %v", statuses)
+		}
+	})
+
+	t.Run("disallowed origin", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, testServer.URL+"/poll", http.NoBody)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		req.Header.Set("Origin", "http://evil.example")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to make request: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected status %d, got %d", http.StatusForbidden, resp.StatusCode)
+		}
+	})
+}
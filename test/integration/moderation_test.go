@@ -0,0 +1,379 @@
+package integration
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tyrowin/gochat/internal/server"
+	"github.com/gorilla/websocket"
+)
+
+// frame is a decoded server frame: a chat message or a typed event.
+type frame struct {
+	Type       string          `json:"type"`
+	ID         uint64          `json:"id"`
+	Content    string          `json:"content"`
+	Deleted    bool            `json:"deleted"`
+	InReplyTo  uint64          `json:"in_reply_to"`
+	Message    *server.Message `json:"message"`
+	Sender     string          `json:"sender"`
+	Action     string          `json:"action"`
+	Actor      string          `json:"actor"`
+	Target     string          `json:"target"`
+	Code       string          `json:"code"`
+	RetryAfter int             `json:"retry_after"`
+	Emoji      string          `json:"emoji"`
+	Delta      int             `json:"delta"`
+	Count      int             `json:"count"`
+	User       string          `json:"user"`
+	Typing     bool            `json:"typing"`
+}
+
+// frameReader splits WebSocket messages into frames; the server batches
+// queued frames into one message separated by newlines. last holds the raw
+// form of the most recently returned frame.
+type frameReader struct {
+	conn    *websocket.Conn
+	pending [][]byte
+	last    []byte
+}
+
+func (r *frameReader) next(t *testing.T) frame {
+	t.Helper()
+	for len(r.pending) == 0 {
+		if err := r.conn.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+			t.Fatalf(errMsgReadDeadline, err)
+		}
+		_, message, err := r.conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		r.pending = bytes.Split(message, []byte{'\n'})
+	}
+
+	var f frame
+	if err := json.Unmarshal(r.pending[0], &f); err != nil {
+		t.Fatalf("Failed to decode frame %q: %v", r.pending[0], err)
+	}
+	r.last = r.pending[0]
+	r.pending = r.pending[1:]
+	return f
+}
+
+// dialAsUser connects a WebSocket client authenticated with token and reads
+// the presence snapshot the server sends first, leaving it in last.
+func dialAsUser(t *testing.T, baseURL, token string) *frameReader {
+	t.Helper()
+	return dialWithQuery(t, baseURL, url.Values{"token": {token}})
+}
+
+// dialWithQuery connects an authenticated WebSocket client with the given
+// query parameters, which must include the token.
+func dialWithQuery(t *testing.T, baseURL string, query url.Values) *frameReader {
+	t.Helper()
+	token := query.Get("token")
+	conn, resp, err := websocket.DefaultDialer.Dial(buildWebSocketURL(t, baseURL)+"?"+query.Encode(), newOriginHeader(baseURL))
+	if err != nil {
+		t.Fatalf("Failed to connect as %s: %v", token, err)
+	}
+	_ = resp.Body.Close()
+	t.Cleanup(func() { _ = conn.Close() })
+	r := &frameReader{conn: conn}
+	if got := r.next(t); got.Type != "presence" {
+		t.Fatalf("Expected presence snapshot first, got %s", r.last)
+	}
+	time.Sleep(50 * time.Millisecond)
+	return r
+}
+
+func sendFrame(t *testing.T, r *frameReader, body string) {
+	t.Helper()
+	if err := r.conn.WriteMessage(websocket.TextMessage, []byte(body)); err != nil {
+		t.Fatalf("Failed to send frame: %v", err)
+	}
+}
+
+func moderationUsers(cfg *server.Config) {
+	cfg.Users = []server.UserAccount{
+		{Name: "owner", Token: "owner-token", Role: server.RoleOwner},
+		{Name: "mod", Token: "mod-token", Role: server.RoleModerator},
+		{Name: "alice", Token: "alice-token"},
+		{Name: "bob", Token: "bob-token"},
+	}
+}
+
+// TestUnknownTokenIsRejected verifies that a token that matches no user is
+// refused on every transport while clients without a token join as guests.
+func TestUnknownTokenIsRejected(t *testing.T) {
+	testServer := startSSETestServer(t, moderationUsers)
+
+	_, resp, err := websocket.DefaultDialer.Dial(buildWebSocketURL(t, testServer.URL)+"?token=bogus", newOriginHeader(testServer.URL))
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected WebSocket upgrade to be refused with %d, got %v", http.StatusUnauthorized, err)
+	}
+	if resp != nil {
+		_ = resp.Body.Close()
+	}
+
+	if status, _ := poll(t, testServer.URL, url.Values{"token": {"bogus"}}); status != http.StatusUnauthorized {
+		t.Errorf("Expected long-poll session to be refused with %d, got %d", http.StatusUnauthorized, status)
+	}
+
+	dialWebSocket(t, testServer.URL)
+}
+
+// TestMuteAndUnmute verifies that a moderator can mute a member, that the
+// muted member gets an error instead of broadcasting, and that members
+// cannot moderate.
+func TestMuteAndUnmute(t *testing.T) {
+	testServer := startSSETestServer(t, moderationUsers)
+	mod := dialAsUser(t, testServer.URL, "mod-token")
+	alice := dialAsUser(t, testServer.URL, "alice-token")
+	bob := dialAsUser(t, testServer.URL, "bob-token")
+
+	sendFrame(t, alice, `{"content":"hello"}`)
+	if got := bob.next(t); got.Content != "hello" || got.Sender != "alice" {
+		t.Fatalf("Expected message from alice, got %+v", got)
+	}
+	mod.next(t)
+
+	sendFrame(t, bob, `{"type":"mute","target":"alice","duration":"1h"}`)
+	if got := bob.next(t); got.Type != "error" || got.Code != "forbidden" {
+		t.Errorf("Expected forbidden error for member, got %+v", got)
+	}
+
+	sendFrame(t, mod, `{"type":"mute","target":"alice","duration":"1h","reason":"spam"}`)
+	for _, client := range []*frameReader{mod, alice, bob} {
+		if got := client.next(t); got.Type != "moderation" || got.Action != "mute" || got.Target != "alice" || got.Actor != "mod" {
+			t.Errorf("Expected mute announcement, got %+v", got)
+		}
+	}
+
+	sendFrame(t, alice, `{"content":"still here"}`)
+	if got := alice.next(t); got.Type != "error" || got.Code != "muted" {
+		t.Errorf("Expected muted error, got %+v", got)
+	}
+
+	// The unmute announcement is the next thing bob sees, so the muted
+	// message was never broadcast.
+	sendFrame(t, mod, `{"type":"unmute","target":"alice"}`)
+	alice.next(t)
+	if got := bob.next(t); got.Action != "unmute" {
+		t.Errorf("Expected unmute announcement, got %+v", got)
+	}
+	sendFrame(t, alice, `{"content":"back"}`)
+	if got := bob.next(t); got.Content != "back" {
+		t.Errorf("Expected message after unmute, got %+v", got)
+	}
+}
+
+// TestModeratorsCannotModerateEqualRoles verifies the role hierarchy.
+func TestModeratorsCannotModerateEqualRoles(t *testing.T) {
+	testServer := startSSETestServer(t, moderationUsers)
+	mod := dialAsUser(t, testServer.URL, "mod-token")
+
+	sendFrame(t, mod, `{"type":"kick","target":"owner"}`)
+	if got := mod.next(t); got.Code != "forbidden" {
+		t.Errorf("Expected forbidden error kicking owner, got %+v", got)
+	}
+	sendFrame(t, mod, `{"type":"kick","target":"nobody"}`)
+	if got := mod.next(t); got.Code != "unknown_user" {
+		t.Errorf("Expected unknown_user error, got %+v", got)
+	}
+}
+
+// TestKickSendsReasonInCloseFrame verifies that a kicked user's connection
+// is closed with the reason.
+func TestKickSendsReasonInCloseFrame(t *testing.T) {
+	testServer := startSSETestServer(t, moderationUsers)
+	owner := dialAsUser(t, testServer.URL, "owner-token")
+	mod := dialAsUser(t, testServer.URL, "mod-token")
+
+	sendFrame(t, owner, `{"type":"kick","target":"mod","reason":"cool off"}`)
+
+	if err := mod.conn.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatalf(errMsgReadDeadline, err)
+	}
+	_, _, err := mod.conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != "kicked: cool off" {
+		t.Errorf("Expected policy violation close with kick reason, got %v", err)
+	}
+
+	if got := owner.next(t); got.Action != "kick" || got.Target != "mod" {
+		t.Errorf("Expected kick announcement, got %+v", got)
+	}
+}
+
+// TestModeratorsMuteAndKickGuestsByMessage verifies that a guest connection
+// is targeted through a message it sent, that muting it leaves other guests
+// alone, and that a long kick reason is cut to a valid close frame.
+func TestModeratorsMuteAndKickGuestsByMessage(t *testing.T) {
+	testServer := startSSETestServer(t, moderationUsers)
+	mod := dialAsUser(t, testServer.URL, "mod-token")
+	guest := &frameReader{conn: dialWebSocket(t, testServer.URL)}
+	other := &frameReader{conn: dialWebSocket(t, testServer.URL)}
+
+	sendFrame(t, guest, `{"content":"spam"}`)
+	spam := mod.next(t)
+	other.next(t)
+
+	sendFrame(t, mod, `{"type":"ban","id":`+strconv.FormatUint(spam.ID, 10)+`}`)
+	if got := mod.next(t); got.Code != "unknown_user" {
+		t.Errorf("Expected unknown_user error banning a guest, got %+v", got)
+	}
+
+	sendFrame(t, mod, `{"type":"mute","id":`+strconv.FormatUint(spam.ID, 10)+`}`)
+	announcement := mod.next(t)
+	if announcement.Action != "mute" || !strings.HasPrefix(announcement.Target, "guest-") {
+		t.Fatalf("Expected mute announcement for the guest, got %+v", announcement)
+	}
+	guest.next(t)
+	other.next(t)
+
+	sendFrame(t, guest, `{"content":"more spam"}`)
+	if got := guest.next(t); got.Code != "muted" {
+		t.Errorf("Expected muted error, got %+v", got)
+	}
+	sendFrame(t, other, `{"content":"hi"}`)
+	if got := mod.next(t); got.Content != "hi" {
+		t.Errorf("Expected the other guest's message, got %+v", got)
+	}
+	guest.next(t)
+
+	reason := strings.Repeat("é", 100)
+	sendFrame(t, mod, `{"type":"kick","id":`+strconv.FormatUint(spam.ID, 10)+`,"reason":"`+reason+`"}`)
+	if err := guest.conn.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatalf(errMsgReadDeadline, err)
+	}
+	var err error
+	for err == nil {
+		_, _, err = guest.conn.ReadMessage()
+	}
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation || !strings.HasPrefix(closeErr.Text, "kicked: éé") {
+		t.Errorf("Expected policy violation close with the cut kick reason, got %v", err)
+	}
+	if got := other.next(t); got.Action != "kick" || got.Target != announcement.Target {
+		t.Errorf("Expected kick announcement, got %+v", got)
+	}
+}
+
+// TestBanUserAndAuditLog verifies that a banned user is disconnected and
+// refused, and that moderation actions are written to the audit log.
+func TestBanUserAndAuditLog(t *testing.T) {
+	dir := t.TempDir()
+	auditPath := filepath.Join(dir, "audit.log")
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		moderationUsers(cfg)
+		cfg.BanListPath = filepath.Join(dir, "bans.json")
+		cfg.AuditLogPath = auditPath
+	})
+	mod := dialAsUser(t, testServer.URL, "mod-token")
+	bob := dialAsUser(t, testServer.URL, "bob-token")
+
+	sendFrame(t, mod, `{"type":"ban","target":"bob","reason":"abuse"}`)
+	if err := bob.conn.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatalf(errMsgReadDeadline, err)
+	}
+	_, _, err := bob.conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Text != "banned: abuse" {
+		t.Errorf("Expected close with ban reason, got %v", err)
+	}
+	mod.next(t)
+
+	_, resp, err := websocket.DefaultDialer.Dial(buildWebSocketURL(t, testServer.URL)+"?token=bob-token", newOriginHeader(testServer.URL))
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected banned user to be refused with %d, got %v", http.StatusForbidden, err)
+	}
+	if resp != nil {
+		_ = resp.Body.Close()
+	}
+
+	sendFrame(t, mod, `{"type":"unban","target":"bob"}`)
+	mod.next(t)
+	dialAsUser(t, testServer.URL, "bob-token")
+
+	file, err := os.Open(auditPath) // #nosec G304 -- test temp file
+	if err != nil {
+		t.Fatalf("Audit log was not written: %v", err)
+	}
+	defer func() { _ = file.Close() }()
+	var actions []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record struct {
+			Action string `json:"action"`
+			Actor  string `json:"actor"`
+			Target string `json:"target"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Invalid audit record %q: %v", scanner.Text(), err)
+		}
+		// The refused reconnect is recorded with bob as the actor.
+		if record.Action == "access_denied" {
+			if record.Actor != "bob" {
+				t.Errorf("Unexpected audit record: %+v", record)
+			}
+		} else if record.Actor != "mod" || record.Target != "bob" {
+			t.Errorf("Unexpected audit record: %+v", record)
+		}
+		actions = append(actions, record.Action)
+	}
+	if len(actions) != 3 || actions[0] != "ban" || actions[1] != "access_denied" || actions[2] != "unban" {
+		t.Errorf("Expected ban, access_denied and unban audit records, got %v", actions)
+	}
+}
+
+// TestSlowMode verifies that slow mode limits how often non-moderators can
+// send, on WebSocket and POST /messages, and that moderators are exempt.
+func TestSlowMode(t *testing.T) {
+	testServer := startSSETestServer(t, moderationUsers)
+	mod := dialAsUser(t, testServer.URL, "mod-token")
+	alice := dialAsUser(t, testServer.URL, "alice-token")
+	t.Cleanup(func() {
+		_ = mod.conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"slow_mode","interval":"0s"}`))
+		time.Sleep(50 * time.Millisecond)
+	})
+
+	sendFrame(t, mod, `{"type":"slow_mode","interval":"30s"}`)
+	mod.next(t)
+	alice.next(t)
+
+	sendFrame(t, alice, `{"content":"first"}`)
+	if got := mod.next(t); got.Content != "first" {
+		t.Fatalf("Expected first message, got %+v", got)
+	}
+	sendFrame(t, alice, `{"content":"second"}`)
+	if got := alice.next(t); got.Code != "slow_mode" || got.RetryAfter < 1 {
+		t.Errorf("Expected slow_mode error with retry_after, got %+v", got)
+	}
+
+	sendFrame(t, mod, `{"content":"mod one"}`)
+	sendFrame(t, mod, `{"content":"mod two"}`)
+	for _, want := range []string{"mod one", "mod two"} {
+		if got := alice.next(t); got.Content != want {
+			t.Errorf("Expected %q from exempt moderator, got %+v", want, got)
+		}
+	}
+
+	stream := openSSE(t, testServer.URL, nil)
+	defer stream.close()
+	if status := postMessage(t, testServer.URL, testServer.URL, stream.session, `{"content":"one"}`); status != http.StatusAccepted {
+		t.Errorf("Expected status %d, got %d", http.StatusAccepted, status)
+	}
+	if status := postMessage(t, testServer.URL, testServer.URL, stream.session, `{"content":"two"}`); status != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d in slow mode, got %d", http.StatusTooManyRequests, status)
+	}
+}
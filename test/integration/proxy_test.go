@@ -0,0 +1,143 @@
+package integration
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Tyrowin/gochat/internal/server"
+	"github.com/gorilla/websocket"
+)
+
+// dialWithHeaders attempts a WebSocket connection with extra request headers
+// and returns the refusal status, or 0 if the connection was accepted.
+func dialWithHeaders(t *testing.T, baseURL string, extra http.Header) int {
+	t.Helper()
+	header := newOriginHeader(baseURL)
+	for key, values := range extra {
+		header[key] = values
+	}
+
+	conn, resp, err := websocket.DefaultDialer.Dial(buildWebSocketURL(t, baseURL), header)
+	if err != nil {
+		if resp == nil {
+			t.Fatalf("Failed to dial WebSocket: %v", err)
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	_ = resp.Body.Close()
+	t.Cleanup(func() { _ = conn.Close() })
+	return 0
+}
+
+func forwardedHeader(name, value string) http.Header {
+	header := http.Header{}
+	header.Set(name, value)
+	return header
+}
+
+// startProxyTestServer allows one connection attempt per client address per
+// minute, so admission results reveal which address the server attributed
+// each request to.
+func startProxyTestServer(t *testing.T, trusted []string) string {
+	t.Helper()
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		cfg.TrustedProxies = trusted
+		cfg.ConnectionLimits.UpgradeBurst = 1
+		cfg.ConnectionLimits.UpgradeInterval = time.Minute
+	})
+	return testServer.URL
+}
+
+// TestTrustedProxyHeadersIdentifyClients verifies that each forwarding header
+// is honoured for requests from a trusted proxy.
+func TestTrustedProxyHeadersIdentifyClients(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+	}{
+		{"x-forwarded-for", forwardedHeader("X-Forwarded-For", "203.0.113.10")},
+		{"x-real-ip", forwardedHeader("X-Real-IP", "203.0.113.11")},
+		{"forwarded", forwardedHeader("Forwarded", `for="[2001:db8::1]:4711";proto=https`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			baseURL := startProxyTestServer(t, []string{"127.0.0.1/32"})
+
+			if status := dialWithHeaders(t, baseURL, tt.header); status != 0 {
+				t.Fatalf("First connection refused with status %d", status)
+			}
+			if status := dialWithHeaders(t, baseURL, tt.header); status != http.StatusTooManyRequests {
+				t.Errorf("Expected second connection from the same client to get %d, got %d", http.StatusTooManyRequests, status)
+			}
+			other := forwardedHeader("X-Forwarded-For", "198.51.100.20")
+			if status := dialWithHeaders(t, baseURL, other); status != 0 {
+				t.Errorf("Connection from a different client refused with status %d", status)
+			}
+		})
+	}
+}
+
+// TestTrustedProxyIgnoresSpoofedHops verifies that addresses a client
+// prepends to X-Forwarded-For are not used: the nearest untrusted hop is the
+// client.
+func TestTrustedProxyIgnoresSpoofedHops(t *testing.T) {
+	baseURL := startProxyTestServer(t, []string{"127.0.0.1/32", "172.16.0.0/12"})
+	const client = "203.0.113.30"
+
+	first := forwardedHeader("X-Forwarded-For", "192.0.2.1, "+client+", 172.16.2.3")
+	if status := dialWithHeaders(t, baseURL, first); status != 0 {
+		t.Fatalf("First connection refused with status %d", status)
+	}
+
+	spoofed := forwardedHeader("X-Forwarded-For", "192.0.2.99, "+client+", 172.16.2.3")
+	if status := dialWithHeaders(t, baseURL, spoofed); status != http.StatusTooManyRequests {
+		t.Errorf("Expected spoofed hop to be ignored and the connection refused, got status %d", status)
+	}
+}
+
+// TestUntrustedPeerForwardingHeadersIgnored verifies that forwarding headers
+// from peers that are not trusted proxies have no effect.
+func TestUntrustedPeerForwardingHeadersIgnored(t *testing.T) {
+	baseURL := startProxyTestServer(t, nil)
+
+	if status := dialWithHeaders(t, baseURL, forwardedHeader("X-Forwarded-For", "203.0.113.40")); status != 0 {
+		t.Fatalf("First connection refused with status %d", status)
+	}
+	if status := dialWithHeaders(t, baseURL, forwardedHeader("X-Forwarded-For", "203.0.113.41")); status != http.StatusTooManyRequests {
+		t.Errorf("Expected connection to be attributed to the peer and refused, got status %d", status)
+	}
+}
+
+// TestGuestsBehindOneProxyHaveDistinctIdentities verifies that guests whose
+// requests are forwarded for the same address cannot edit each other's
+// messages.
+func TestGuestsBehindOneProxyHaveDistinctIdentities(t *testing.T) {
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		cfg.TrustedProxies = []string{"127.0.0.1/32"}
+	})
+	header := newOriginHeader(testServer.URL)
+	header.Set("X-Forwarded-For", "203.0.113.50")
+
+	var guests []*frameReader
+	for i := 0; i < 2; i++ {
+		conn, resp, err := websocket.DefaultDialer.Dial(buildWebSocketURL(t, testServer.URL), header)
+		if err != nil {
+			t.Fatalf("Failed to connect guest %d: %v", i, err)
+		}
+		_ = resp.Body.Close()
+		t.Cleanup(func() { _ = conn.Close() })
+		guests = append(guests, &frameReader{conn: conn})
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	sendFrame(t, guests[0], `{"content":"mine"}`)
+	original := guests[1].next(t)
+	sendFrame(t, guests[1], fmt.Sprintf(`{"type":"edit","id":%d,"content":"yours"}`, original.ID))
+	if got := guests[1].next(t); got.Code != "forbidden" {
+		t.Errorf("Expected forbidden editing another guest's message, got %s", guests[1].last)
+	}
+}
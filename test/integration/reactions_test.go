@@ -0,0 +1,95 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Tyrowin/gochat/internal/server"
+)
+
+// TestReactions verifies that reactions are broadcast as deltas, counted
+// once per user, and included in history replay.
+func TestReactions(t *testing.T) {
+	testServer := startSSETestServer(t, moderationUsers)
+	alice := dialAsUser(t, testServer.URL, "alice-token")
+	bob := dialAsUser(t, testServer.URL, "bob-token")
+
+	sendFrame(t, alice, `{"content":"lunch?"}`)
+	original := bob.next(t)
+
+	react := fmt.Sprintf(`{"type":"react","id":%d,"emoji":"👍"}`, original.ID)
+	sendFrame(t, alice, react)
+	for _, client := range []*frameReader{alice, bob} {
+		got := client.next(t)
+		if got.Type != "reaction" || got.ID != original.ID || got.Emoji != "👍" ||
+			got.Delta != 1 || got.Count != 1 || got.User != "alice" {
+			t.Errorf("Expected reaction delta, got %+v", got)
+		}
+	}
+
+	// Reacting twice is a no-op, so bob's reaction is the next event.
+	sendFrame(t, alice, react)
+	sendFrame(t, bob, react)
+	if got := alice.next(t); got.Type != "reaction" || got.Count != 2 || got.User != "bob" {
+		t.Errorf("Expected count 2 after bob reacted, got %+v", got)
+	}
+	bob.next(t)
+
+	sendFrame(t, alice, fmt.Sprintf(`{"type":"unreact","id":%d,"emoji":"👍"}`, original.ID))
+	if got := bob.next(t); got.Type != "reaction" || got.Delta != -1 || got.Count != 1 {
+		t.Errorf("Expected count 1 after alice unreacted, got %+v", got)
+	}
+	alice.next(t)
+
+	stream := openSSE(t, testServer.URL, http.Header{"Last-Event-ID": {strconv.FormatUint(original.ID-1, 10)}})
+	event := stream.next(t, 2*time.Second)
+	var replayed server.Message
+	if err := json.Unmarshal([]byte(event.Data), &replayed); err != nil {
+		t.Fatalf("Invalid replayed message %q: %v", event.Data, err)
+	}
+	if len(replayed.Reactions) != 1 || replayed.Reactions[0] != (server.ReactionCount{Emoji: "👍", Count: 1}) {
+		t.Errorf("Expected replay to carry the reaction counts, got %+v", replayed.Reactions)
+	}
+}
+
+// TestReactionErrors verifies the validation of reactions and the limit on
+// distinct emoji per message.
+func TestReactionErrors(t *testing.T) {
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		moderationUsers(cfg)
+		cfg.MaxReactions = 1
+		cfg.RateLimit.Burst = 20
+	})
+	alice := dialAsUser(t, testServer.URL, "alice-token")
+	bob := dialAsUser(t, testServer.URL, "bob-token")
+
+	sendFrame(t, alice, `{"content":"vote"}`)
+	original := bob.next(t)
+
+	cases := []struct {
+		body string
+		code string
+	}{
+		{fmt.Sprintf(`{"type":"react","id":%d,"emoji":""}`, original.ID), "invalid_emoji"},
+		{fmt.Sprintf(`{"type":"react","id":%d,"emoji":"a b"}`, original.ID), "invalid_emoji"},
+		{`{"type":"react","id":999999999,"emoji":"👍"}`, "message_not_found"},
+		{fmt.Sprintf(`{"type":"unreact","id":%d,"emoji":"👍"}`, original.ID), "reaction_not_found"},
+	}
+	for _, tc := range cases {
+		sendFrame(t, bob, tc.body)
+		if got := bob.next(t); got.Code != tc.code {
+			t.Errorf("Expected %s for %s, got %+v", tc.code, tc.body, got)
+		}
+	}
+
+	sendFrame(t, bob, fmt.Sprintf(`{"type":"react","id":%d,"emoji":"👍"}`, original.ID))
+	bob.next(t)
+	sendFrame(t, bob, fmt.Sprintf(`{"type":"react","id":%d,"emoji":"🎉"}`, original.ID))
+	if got := bob.next(t); got.Code != "reaction_limit" {
+		t.Errorf("Expected reaction_limit for a second distinct emoji, got %+v", got)
+	}
+}
@@ -0,0 +1,132 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/Tyrowin/gochat/internal/server"
+)
+
+// presenceSnapshot is the presence event sent to authenticated clients on
+// connect.
+type presenceSnapshot struct {
+	Users    []string          `json:"users"`
+	Unread   map[string]int    `json:"unread"`
+	LastRead map[string]uint64 `json:"last_read"`
+}
+
+// dialForPresence connects as token and returns the presence snapshot.
+func dialForPresence(t *testing.T, baseURL, token string) (*frameReader, presenceSnapshot) {
+	t.Helper()
+	r := dialAsUser(t, baseURL, token)
+	var snapshot presenceSnapshot
+	if err := json.Unmarshal(r.last, &snapshot); err != nil {
+		t.Fatalf("Invalid presence snapshot %q: %v", r.last, err)
+	}
+	return r, snapshot
+}
+
+// TestReadMarkersAndUnreadCounts verifies that read markers produce read
+// receipts and drive the unread counts of the presence snapshot.
+func TestReadMarkersAndUnreadCounts(t *testing.T) {
+	testServer := startSSETestServer(t, moderationUsers)
+	alice := dialAsUser(t, testServer.URL, "alice-token")
+	bob := dialAsUser(t, testServer.URL, "bob-token")
+
+	sendFrame(t, alice, `{"content":"one"}`)
+	sendFrame(t, alice, `{"content":"two"}`)
+	bob.next(t)
+	second := bob.next(t)
+
+	sendFrame(t, bob, fmt.Sprintf(`{"type":"read","id":%d}`, second.ID))
+	if got := alice.next(t); got.Type != "read_receipt" || got.User != "bob" || got.ID != second.ID {
+		t.Errorf("Expected bob's read receipt, got %s", alice.last)
+	}
+
+	// Moving a marker backwards is ignored and sends no receipt.
+	sendFrame(t, bob, fmt.Sprintf(`{"type":"read","id":%d}`, second.ID-1))
+
+	_, snapshot := dialForPresence(t, testServer.URL, "bob-token")
+	if snapshot.Unread[server.DefaultRoom] != 0 || snapshot.LastRead[server.DefaultRoom] != second.ID {
+		t.Errorf("Expected nothing unread up to %d, got %+v", second.ID, snapshot)
+	}
+	if len(snapshot.Users) != 2 || snapshot.Users[0] != "alice" || snapshot.Users[1] != "bob" {
+		t.Errorf("Expected alice and bob online, got %v", snapshot.Users)
+	}
+
+	sendFrame(t, alice, `{"content":"three"}`)
+	bob.next(t)
+	sendFrame(t, bob, `{"content":"own messages are not unread"}`)
+	if got := alice.next(t); got.Content != "own messages are not unread" {
+		t.Errorf("Expected bob's message, got %s", alice.last)
+	}
+	_, snapshot = dialForPresence(t, testServer.URL, "bob-token")
+	if snapshot.Unread[server.DefaultRoom] != 1 {
+		t.Errorf("Expected one unread message, got %+v", snapshot)
+	}
+}
+
+// TestReadMarkerErrors verifies the validation of read frames.
+func TestReadMarkerErrors(t *testing.T) {
+	testServer := startSSETestServer(t, moderationUsers)
+	alice := dialAsUser(t, testServer.URL, "alice-token")
+
+	cases := []struct {
+		body string
+		code string
+	}{
+		{`{"type":"read","id":999999999}`, "message_not_found"},
+		{`{"type":"read","id":1,"room":"elsewhere"}`, "unknown_room"},
+	}
+	for _, tc := range cases {
+		sendFrame(t, alice, tc.body)
+		if got := alice.next(t); got.Code != tc.code {
+			t.Errorf("Expected %s for %s, got %s", tc.code, tc.body, alice.last)
+		}
+	}
+
+	guest := &frameReader{conn: dialWebSocket(t, testServer.URL)}
+	sendFrame(t, guest, `{"type":"read","id":1}`)
+	if got := guest.next(t); got.Code != "forbidden" {
+		t.Errorf("Expected forbidden for a guest, got %s", guest.last)
+	}
+}
+
+// TestDirectMessageReadReceipts verifies that marking a direct message
+// conversation as read sends a receipt to the other participant.
+func TestDirectMessageReadReceipts(t *testing.T) {
+	testServer := startSSETestServer(t, dmUsers)
+	alice := dialAsUser(t, testServer.URL, "alice-token")
+	bob := dialAsUser(t, testServer.URL, "bob-token")
+
+	sendFrame(t, alice, `{"type":"dm","target":"bob","content":"hi"}`)
+	dm := nextDM(t, bob)
+	if dm.Type != "dm" || dm.From != "alice" {
+		t.Fatalf("Expected alice's direct message, got %s", bob.last)
+	}
+	nextDM(t, alice) // dm_sent
+	nextDM(t, alice) // delivered
+
+	for body, code := range map[string]string{
+		fmt.Sprintf(`{"type":"read","target":"alice","id":%d}`, dm.ID+1): "message_not_found",
+		fmt.Sprintf(`{"type":"read","target":"bob","id":%d}`, dm.ID):     "unknown_user",
+	} {
+		sendFrame(t, bob, body)
+		if got := bob.next(t); got.Code != code {
+			t.Errorf("Expected %s for %s, got %s", code, body, bob.last)
+		}
+	}
+
+	sendFrame(t, bob, fmt.Sprintf(`{"type":"read","target":"alice","id":%d}`, dm.ID))
+	var receipt struct {
+		Type string `json:"type"`
+		DM   bool   `json:"dm"`
+		User string `json:"user"`
+		ID   uint64 `json:"id"`
+	}
+	alice.next(t)
+	if err := json.Unmarshal(alice.last, &receipt); err != nil || receipt.Type != "read_receipt" || !receipt.DM || receipt.User != "bob" || receipt.ID != dm.ID {
+		t.Errorf("Expected bob's direct message read receipt, got %s", alice.last)
+	}
+}
@@ -0,0 +1,97 @@
+package integration
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Tyrowin/gochat/internal/server"
+)
+
+// sessionFrame is the session event of a resumable connection.
+type sessionFrame struct {
+	Type        string `json:"type"`
+	ResumeToken string `json:"resume_token"`
+	Resumed     bool   `json:"resumed"`
+}
+
+// dialResumable connects bob with a resumable session, resuming the session
+// of token if it is set, and returns the session event.
+func dialResumable(t *testing.T, baseURL, token string) (*frameReader, sessionFrame) {
+	t.Helper()
+	query := url.Values{"token": {"bob-token"}, "resumable": {"1"}}
+	if token != "" {
+		query.Set("resume_token", token)
+	}
+	r := dialWithQuery(t, baseURL, query)
+	r.next(t)
+	var session sessionFrame
+	if err := json.Unmarshal(r.last, &session); err != nil || session.Type != "session" || session.ResumeToken == "" {
+		t.Fatalf("Expected a session event, got %s", r.last)
+	}
+	return r, session
+}
+
+// dropConnection closes a connection without a close handshake, as a lost
+// mobile connection would, and gives the hub time to notice.
+func dropConnection(r *frameReader) {
+	_ = r.conn.Close()
+	time.Sleep(100 * time.Millisecond)
+}
+
+// TestSessionResumption verifies that a client reconnecting with its resume
+// token within the grace window gets the messages it missed, and that a
+// token works only once.
+func TestSessionResumption(t *testing.T) {
+	testServer := startSSETestServer(t, moderationUsers)
+	alice := dialAsUser(t, testServer.URL, "alice-token")
+	bob, session := dialResumable(t, testServer.URL, "")
+	if session.Resumed {
+		t.Errorf("Expected a new session, got %s", bob.last)
+	}
+
+	dropConnection(bob)
+	sendFrame(t, alice, `{"content":"while you were away"}`)
+	time.Sleep(50 * time.Millisecond)
+
+	bob, resumed := dialResumable(t, testServer.URL, session.ResumeToken)
+	if !resumed.Resumed || resumed.ResumeToken == session.ResumeToken {
+		t.Errorf("Expected a resumed session with a new token, got %s", bob.last)
+	}
+	if got := bob.next(t); got.Content != "while you were away" {
+		t.Errorf("Expected the queued message, got %s", bob.last)
+	}
+
+	// The old token was used up.
+	dropConnection(bob)
+	_, again := dialResumable(t, testServer.URL, session.ResumeToken)
+	if again.Resumed {
+		t.Error("Expected a used resume token to be refused")
+	}
+}
+
+// TestSessionResumptionLimits verifies that sessions expire after the grace
+// window and cannot be resumed after a kick.
+func TestSessionResumptionLimits(t *testing.T) {
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		moderationUsers(cfg)
+		cfg.ResumeGrace = 200 * time.Millisecond
+	})
+
+	bob, session := dialResumable(t, testServer.URL, "")
+	dropConnection(bob)
+	time.Sleep(300 * time.Millisecond)
+	if _, resumed := dialResumable(t, testServer.URL, session.ResumeToken); resumed.Resumed {
+		t.Error("Expected the session to expire after the grace window")
+	}
+
+	mod := dialAsUser(t, testServer.URL, "mod-token")
+	_, session = dialResumable(t, testServer.URL, "")
+	sendFrame(t, mod, `{"type":"kick","target":"bob"}`)
+	mod.next(t)
+	time.Sleep(100 * time.Millisecond)
+	if _, resumed := dialResumable(t, testServer.URL, session.ResumeToken); resumed.Resumed {
+		t.Error("Expected a kicked session not to be resumable")
+	}
+}
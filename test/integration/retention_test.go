@@ -0,0 +1,68 @@
+package integration
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Tyrowin/gochat/internal/server"
+)
+
+// waitForHistory polls the history API until check accepts the latest page.
+func waitForHistory(t *testing.T, baseURL string, check func(historyPage) bool) historyPage {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_, page := fetchHistory(t, baseURL, "general", "alice-token", url.Values{"limit": {"100"}})
+		if check(page) || time.Now().After(deadline) {
+			return page
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// TestRetentionPrunesByCount verifies that the janitor keeps at most the
+// room's maximum count, with the room override taking precedence over the
+// global policy.
+func TestRetentionPrunesByCount(t *testing.T) {
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		searchUsers(cfg)
+		cfg.Retention.MaxCount = 1
+		cfg.Retention.Rooms = map[string]server.RetentionPolicy{"general": {MaxCount: 3}}
+		cfg.Retention.Interval = 20 * time.Millisecond
+	})
+
+	var ids []uint64
+	for i := 0; i < 5; i++ {
+		status, id := postIngest(t, testServer.URL, "general", bearer("ci-key"), `{"content":"kept `+strconv.Itoa(i)+`"}`)
+		if status != http.StatusCreated {
+			t.Fatalf("Expected 201 from ingest, got %d", status)
+		}
+		ids = append(ids, id)
+	}
+
+	page := waitForHistory(t, testServer.URL, func(p historyPage) bool { return equalIDs(p.ids(), ids[2:]) })
+	if !equalIDs(page.ids(), ids[2:]) {
+		t.Errorf("Expected only the newest three messages %v, got %v", ids[2:], page.ids())
+	}
+}
+
+// TestRetentionPrunesByAge verifies that messages older than the maximum
+// age are pruned.
+func TestRetentionPrunesByAge(t *testing.T) {
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		searchUsers(cfg)
+		cfg.Retention.MaxAge = 100 * time.Millisecond
+		cfg.Retention.Interval = 20 * time.Millisecond
+	})
+
+	if status, _ := postIngest(t, testServer.URL, "general", bearer("ci-key"), `{"content":"short-lived"}`); status != http.StatusCreated {
+		t.Fatalf("Expected 201 from ingest, got %d", status)
+	}
+	page := waitForHistory(t, testServer.URL, func(p historyPage) bool { return len(p.Messages) == 0 })
+	if len(page.Messages) != 0 {
+		t.Errorf("Expected every message to expire, got %v", page.ids())
+	}
+}
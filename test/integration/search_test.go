@@ -0,0 +1,182 @@
+package integration
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tyrowin/gochat/internal/server"
+)
+
+// searchResponse mirrors the body of GET /api/v1/search.
+type searchResponse struct {
+	Results []struct {
+		ID      uint64          `json:"id"`
+		Room    string          `json:"room"`
+		Sender  string          `json:"sender"`
+		SentAt  time.Time       `json:"sent_at"`
+		Snippet string          `json:"snippet"`
+		Message json.RawMessage `json:"message"`
+	} `json:"results"`
+	NextCursor string `json:"next_cursor"`
+}
+
+// search queries the search API as the user holding token and returns the
+// status and, for 200 responses, the decoded body.
+func search(t *testing.T, baseURL, token string, query url.Values) (int, searchResponse) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, baseURL+"/api/v1/search?"+query.Encode(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create search request: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Search request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var body searchResponse
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("Invalid search response: %v", err)
+		}
+	}
+	return resp.StatusCode, body
+}
+
+func searchUsers(cfg *server.Config) {
+	moderationUsers(cfg)
+	cfg.APIKeys = []server.APIKey{{Name: "ci-bot", Key: "ci-key"}, {Name: "alerts", Key: "alerts-key"}}
+	cfg.RateLimit.Burst = 20
+}
+
+// uniqueWord returns a word no other test sends, so that results are not
+// affected by the messages of other tests in the shared history.
+func uniqueWord(prefix string) string {
+	return prefix + strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// TestSearchFindsMessages verifies matching, filters, snippets and paging.
+func TestSearchFindsMessages(t *testing.T) {
+	testServer := startSSETestServer(t, searchUsers)
+	word := uniqueWord("needle")
+
+	var ids []uint64
+	for _, post := range []struct{ key, content string }{
+		{"ci-key", "Build " + word + " passed"},
+		{"alerts-key", "Disk <full> on " + strings.ToUpper(word)},
+		{"ci-key", "Build " + word + " failed"},
+		{"ci-key", "Unrelated message"},
+	} {
+		status, id := postIngest(t, testServer.URL, "general", bearer(post.key), `{"content":"`+post.content+`"}`)
+		if status != http.StatusCreated {
+			t.Fatalf("Expected 201 from ingest, got %d", status)
+		}
+		ids = append(ids, id)
+	}
+
+	status, page := search(t, testServer.URL, "alice-token", url.Values{"q": {word}, "limit": {"2"}})
+	if status != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", status)
+	}
+	if len(page.Results) != 2 || page.Results[0].ID != ids[2] || page.Results[1].ID != ids[1] {
+		t.Fatalf("Expected the two newest matches, got %+v", page.Results)
+	}
+	if want := "Disk &lt;full&gt; on <mark>" + strings.ToUpper(word) + "</mark>"; page.Results[1].Snippet != want {
+		t.Errorf("Expected snippet %q, got %q", want, page.Results[1].Snippet)
+	}
+	if page.Results[0].Sender != "ci-bot" || page.Results[0].Room != "general" || page.Results[0].SentAt.IsZero() {
+		t.Errorf("Unexpected result metadata: %+v", page.Results[0])
+	}
+	if page.NextCursor == "" {
+		t.Fatal("Expected a cursor for the next page")
+	}
+
+	_, next := search(t, testServer.URL, "alice-token", url.Values{"q": {word}, "limit": {"2"}, "cursor": {page.NextCursor}})
+	if len(next.Results) != 1 || next.Results[0].ID != ids[0] || next.NextCursor != "" {
+		t.Errorf("Expected the last match and no cursor, got %+v", next)
+	}
+
+	_, filtered := search(t, testServer.URL, "alice-token", url.Values{"q": {"build " + word}, "sender": {"ci-bot"}})
+	if len(filtered.Results) != 2 {
+		t.Errorf("Expected both ci-bot builds, got %+v", filtered.Results)
+	}
+	_, none := search(t, testServer.URL, "alice-token", url.Values{"q": {word}, "before": {"2000-01-01T00:00:00Z"}})
+	if len(none.Results) != 0 {
+		t.Errorf("Expected no messages before 2000, got %+v", none.Results)
+	}
+	_, later := search(t, testServer.URL, "alice-token", url.Values{"q": {word}, "from": {time.Now().Add(time.Hour).Format(time.RFC3339)}})
+	if len(later.Results) != 0 {
+		t.Errorf("Expected no messages from an hour ahead, got %+v", later.Results)
+	}
+	_, since := search(t, testServer.URL, "alice-token", url.Values{"q": {word}, "from": {"2000-01-01T00:00:00Z"}})
+	if len(since.Results) != 3 {
+		t.Errorf("Expected every match since 2000, got %+v", since.Results)
+	}
+	if status, _ := search(t, testServer.URL, "alice-token", url.Values{"q": {word}, "from": {"yesterday"}}); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid from time, got %d", status)
+	}
+}
+
+// TestSearchFollowsEditsAndDeletes verifies that the index is updated when
+// messages change.
+func TestSearchFollowsEditsAndDeletes(t *testing.T) {
+	testServer := startSSETestServer(t, searchUsers)
+	before, after := uniqueWord("draft"), uniqueWord("final")
+
+	alice := dialAsUser(t, testServer.URL, "alice-token")
+	bob := dialAsUser(t, testServer.URL, "bob-token")
+	sendFrame(t, alice, `{"content":"`+before+`","client_id":"s1"}`)
+	ack := alice.next(t)
+	if ack.Type != "ack" {
+		t.Fatalf("Expected an ack, got %s", alice.last)
+	}
+	bob.next(t)
+
+	sendFrame(t, alice, `{"type":"edit","id":`+strconv.FormatUint(ack.ID, 10)+`,"content":"`+after+`"}`)
+	bob.next(t)
+	if _, got := search(t, testServer.URL, "bob-token", url.Values{"q": {before}}); len(got.Results) != 0 {
+		t.Errorf("Expected the old content to be gone, got %+v", got.Results)
+	}
+	if _, got := search(t, testServer.URL, "bob-token", url.Values{"q": {after}}); len(got.Results) != 1 {
+		t.Errorf("Expected the edited message, got %+v", got.Results)
+	}
+
+	sendFrame(t, alice, `{"type":"delete","id":`+strconv.FormatUint(ack.ID, 10)+`}`)
+	bob.next(t)
+	if _, got := search(t, testServer.URL, "bob-token", url.Values{"q": {after}}); len(got.Results) != 0 {
+		t.Errorf("Expected the deleted message to be gone, got %+v", got.Results)
+	}
+}
+
+// TestSearchRejectsInvalidRequests verifies authentication and validation.
+func TestSearchRejectsInvalidRequests(t *testing.T) {
+	testServer := startSSETestServer(t, searchUsers)
+
+	cases := []struct {
+		name  string
+		token string
+		query url.Values
+		want  int
+	}{
+		{"guest", "", url.Values{"q": {"hello"}}, http.StatusUnauthorized},
+		{"unknown token", "nope", url.Values{"q": {"hello"}}, http.StatusUnauthorized},
+		{"empty query", "alice-token", url.Values{"q": {"  !? "}}, http.StatusBadRequest},
+		{"unknown room", "alice-token", url.Values{"q": {"hello"}, "room": {"random"}}, http.StatusNotFound},
+		{"bad before", "alice-token", url.Values{"q": {"hello"}, "before": {"yesterday"}}, http.StatusBadRequest},
+		{"bad cursor", "alice-token", url.Values{"q": {"hello"}, "cursor": {"x"}}, http.StatusBadRequest},
+		{"bad limit", "alice-token", url.Values{"q": {"hello"}, "limit": {"1000"}}, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		if status, _ := search(t, testServer.URL, tc.token, tc.query); status != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, status)
+		}
+	}
+}
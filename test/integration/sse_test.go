@@ -0,0 +1,327 @@
+package integration
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tyrowin/gochat/internal/server"
+	"github.com/gorilla/websocket"
+)
+
+// sseEvent is a single parsed text/event-stream event.
+type sseEvent struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// sseConn is an open /sse stream.
+type sseConn struct {
+	resp    *http.Response
+	reader  *bufio.Reader
+	cancel  context.CancelFunc
+	session string
+}
+
+// openSSE connects to /sse and consumes the initial session event.
+func openSSE(t *testing.T, baseURL string, header http.Header) *sseConn {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/sse", http.NoBody)
+	if err != nil {
+		cancel()
+		t.Fatalf("Failed to create SSE request: %v", err)
+	}
+	req.Header.Set("Origin", baseURL)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		t.Fatalf("Failed to open SSE stream: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		cancel()
+		t.Fatalf("Expected SSE status 200, got %d", resp.StatusCode)
+	}
+
+	conn := &sseConn{resp: resp, reader: bufio.NewReader(resp.Body), cancel: cancel}
+	t.Cleanup(conn.close)
+
+	event := conn.next(t, 2*time.Second)
+	if event.Event != "session" {
+		t.Fatalf("Expected first event to be a session event, got %+v", event)
+	}
+	var payload struct {
+		SessionID string `json:"session_id"`
+	}
+	if err := json.Unmarshal([]byte(event.Data), &payload); err != nil || payload.SessionID == "" {
+		t.Fatalf("Invalid session event data %q: %v", event.Data, err)
+	}
+	conn.session = payload.SessionID
+	return conn
+}
+
+func (c *sseConn) close() {
+	c.cancel()
+	_ = c.resp.Body.Close()
+}
+
+// next reads the next event, skipping comments, or fails after timeout.
+func (c *sseConn) next(t *testing.T, timeout time.Duration) sseEvent {
+	t.Helper()
+
+	result := make(chan sseEvent, 1)
+	failure := make(chan error, 1)
+	go func() {
+		event, err := readSSEEvent(c.reader)
+		if err != nil {
+			failure <- err
+			return
+		}
+		result <- event
+	}()
+
+	select {
+	case event := <-result:
+		return event
+	case err := <-failure:
+		t.Fatalf("Failed to read SSE event: %v", err)
+	case <-time.After(timeout):
+		t.Fatalf("Timed out waiting for SSE event")
+	}
+	return sseEvent{}
+}
+
+func readSSEEvent(reader *bufio.Reader) (sseEvent, error) {
+	var event sseEvent
+	var data []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return event, err
+		}
+		line = strings.TrimRight(line, "\n")
+
+		switch {
+		case line == "":
+			if len(data) == 0 && event.ID == "" && event.Event == "" {
+				continue
+			}
+			event.Data = strings.Join(data, "\n")
+			return event, nil
+		case strings.HasPrefix(line, ":"):
+			continue
+		case strings.HasPrefix(line, "id: "):
+			event.ID = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event.Event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = append(data, strings.TrimPrefix(line, "data: "))
+		}
+	}
+}
+
+// postMessage sends a message body through POST /messages.
+func postMessage(t *testing.T, baseURL, origin, session, body string) int {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, baseURL+"/messages", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create POST request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Origin", origin)
+	if session != "" {
+		req.Header.Set("X-Session-ID", session)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to POST message: %v", err)
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode
+}
+
+func startSSETestServer(t *testing.T, customize func(cfg *server.Config)) *httptest.Server {
+	t.Helper()
+	server.StartHub()
+
+	testServer := httptest.NewServer(server.SetupRoutes())
+	t.Cleanup(testServer.Close)
+	configureServerForTest(t, testServer.URL, customize)
+	return testServer
+}
+
+func dialWebSocket(t *testing.T, baseURL string) *websocket.Conn {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial(buildWebSocketURL(t, baseURL), newOriginHeader(baseURL))
+	if err != nil {
+		t.Fatalf("Failed to connect WebSocket client: %v", err)
+	}
+	_ = resp.Body.Close()
+	t.Cleanup(func() { _ = conn.Close() })
+	// Give the hub time to register the client before messages are sent.
+	time.Sleep(50 * time.Millisecond)
+	return conn
+}
+
+// TestSSEReceivesWebSocketBroadcasts verifies that SSE clients receive
+// messages broadcast by WebSocket clients, tagged with a history id.
+func TestSSEReceivesWebSocketBroadcasts(t *testing.T) {
+	testServer := startSSETestServer(t, nil)
+
+	stream := openSSE(t, testServer.URL, nil)
+	sender := dialWebSocket(t, testServer.URL)
+
+	if err := sender.WriteMessage(websocket.TextMessage, mustMarshalMessage(t, "hello sse")); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	event := stream.next(t, 2*time.Second)
+	if event.ID == "" {
+		t.Error("Expected SSE event to carry an id")
+	}
+	var msg server.Message
+	if err := json.Unmarshal([]byte(event.Data), &msg); err != nil {
+		t.Fatalf("Invalid SSE event data %q: %v", event.Data, err)
+	}
+	if msg.Content != "hello sse" {
+		t.Errorf("Expected content %q, got %q", "hello sse", msg.Content)
+	}
+}
+
+// TestSSEPostMessageBroadcasts verifies that messages posted by an SSE
+// client reach WebSocket clients.
+func TestSSEPostMessageBroadcasts(t *testing.T) {
+	testServer := startSSETestServer(t, nil)
+
+	stream := openSSE(t, testServer.URL, nil)
+	receiver := dialWebSocket(t, testServer.URL)
+
+	status := postMessage(t, testServer.URL, testServer.URL, stream.session, `{"content":"from sse"}`)
+	if status != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d", http.StatusAccepted, status)
+	}
+
+	if err := receiver.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatalf(errMsgReadDeadline, err)
+	}
+	var msg server.Message
+	if err := receiver.ReadJSON(&msg); err != nil {
+		t.Fatalf("Failed to read broadcast: %v", err)
+	}
+	if msg.Content != "from sse" {
+		t.Errorf("Expected content %q, got %q", "from sse", msg.Content)
+	}
+}
+
+// TestSSEPostMessageValidation verifies that POST /messages applies the
+// session, origin, size, validation and rate-limit checks.
+func TestSSEPostMessageValidation(t *testing.T) {
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		cfg.MaxMessageSize = 64
+		cfg.RateLimit = server.RateLimitConfig{Burst: 1, RefillInterval: time.Minute}
+	})
+	stream := openSSE(t, testServer.URL, nil)
+
+	tests := []struct {
+		name     string
+		origin   string
+		session  string
+		body     string
+		expected int
+	}{
+		{"unknown session", testServer.URL, "nope", `{"content":"x"}`, http.StatusUnauthorized},
+		{"disallowed origin", "http://evil.example", stream.session, `{"content":"x"}`, http.StatusForbidden},
+		{"oversized body", testServer.URL, stream.session, `{"content":"` + strings.Repeat("a", 100) + `"}`, http.StatusRequestEntityTooLarge},
+		{"invalid json", testServer.URL, stream.session, `not json`, http.StatusBadRequest},
+		{"rate limited", testServer.URL, stream.session, `{"content":"x"}`, http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := postMessage(t, testServer.URL, tt.origin, tt.session, tt.body)
+			if status != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, status)
+			}
+		})
+	}
+}
+
+// TestSSERejectsDisallowedOrigin verifies that /sse applies the origin check.
+func TestSSERejectsDisallowedOrigin(t *testing.T) {
+	testServer := startSSETestServer(t, nil)
+
+	req, err := http.NewRequest(http.MethodGet, testServer.URL+"/sse", http.NoBody)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Origin", "http://evil.example")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to make request: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, resp.StatusCode)
+	}
+}
+
+// TestSSEAllowsSameOriginRequests verifies that /sse accepts the requests a
+// browser makes from a page on the same origin: with no Origin header, or
+// with one naming the server's own host.
+func TestSSEAllowsSameOriginRequests(t *testing.T) {
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		cfg.AllowedOrigins = []string{"http://chat.example"}
+	})
+
+	openSSE(t, testServer.URL, http.Header{"Origin": nil})
+	openSSE(t, testServer.URL, http.Header{"Origin": {testServer.URL}})
+}
+
+// TestSSEResumesFromLastEventID verifies that a reconnecting SSE client
+// receives the messages it missed after the id in Last-Event-ID.
+func TestSSEResumesFromLastEventID(t *testing.T) {
+	testServer := startSSETestServer(t, nil)
+
+	first := openSSE(t, testServer.URL, nil)
+	sender := dialWebSocket(t, testServer.URL)
+
+	for _, content := range []string{"one", "two", "three"} {
+		if err := sender.WriteMessage(websocket.TextMessage, mustMarshalMessage(t, content)); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+	}
+
+	seen := first.next(t, 2*time.Second)
+	first.close()
+
+	header := http.Header{}
+	header.Set("Last-Event-ID", seen.ID)
+	resumed := openSSE(t, testServer.URL, header)
+
+	for _, expected := range []string{"two", "three"} {
+		event := resumed.next(t, 2*time.Second)
+		var msg server.Message
+		if err := json.Unmarshal([]byte(event.Data), &msg); err != nil {
+			t.Fatalf("Invalid SSE event data %q: %v", event.Data, err)
+		}
+		if msg.Content != expected {
+			t.Errorf("Expected replayed content %q, got %q", expected, msg.Content)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Tyrowin/gochat/internal/server"
+)
+
+// threadFrame is the server's answer to a thread query or a thread summary.
+type threadFrame struct {
+	Type         string           `json:"type"`
+	ID           uint64           `json:"id"`
+	Root         *server.Message  `json:"root"`
+	Replies      []server.Message `json:"replies"`
+	ReplyCount   int              `json:"reply_count"`
+	LastReplyID  uint64           `json:"last_reply_id"`
+	Participants []string         `json:"participants"`
+}
+
+// nextThreadFrame reads the next frame and decodes it as a thread frame.
+func nextThreadFrame(t *testing.T, r *frameReader) threadFrame {
+	t.Helper()
+	r.next(t)
+	var f threadFrame
+	if err := json.Unmarshal(r.last, &f); err != nil {
+		t.Fatalf("Failed to decode thread frame %q: %v", r.last, err)
+	}
+	return f
+}
+
+// TestReplyThreads verifies that replies carry in_reply_to, that replies to
+// replies join the root thread, that summaries are pushed to the room, and
+// that thread queries return the root and its replies.
+func TestReplyThreads(t *testing.T) {
+	testServer := startSSETestServer(t, moderationUsers)
+	alice := dialAsUser(t, testServer.URL, "alice-token")
+	bob := dialAsUser(t, testServer.URL, "bob-token")
+
+	sendFrame(t, alice, `{"content":"lunch?"}`)
+	root := bob.next(t)
+
+	sendFrame(t, bob, fmt.Sprintf(`{"content":"yes","in_reply_to":%d}`, root.ID))
+	reply := alice.next(t)
+	if reply.Content != "yes" || reply.InReplyTo != root.ID {
+		t.Fatalf("Expected reply to %d, got %+v", root.ID, reply)
+	}
+	for _, client := range []*frameReader{alice, bob} {
+		summary := nextThreadFrame(t, client)
+		if summary.Type != "thread_summary" || summary.ID != root.ID || summary.ReplyCount != 1 ||
+			summary.LastReplyID != reply.ID || len(summary.Participants) != 1 || summary.Participants[0] != "bob" {
+			t.Errorf("Unexpected thread summary: %+v", summary)
+		}
+	}
+
+	// A reply to a reply joins the root's thread.
+	sendFrame(t, alice, fmt.Sprintf(`{"content":"noon","in_reply_to":%d}`, reply.ID))
+	if got := bob.next(t); got.InReplyTo != root.ID {
+		t.Errorf("Expected nested reply to point at root %d, got %+v", root.ID, got)
+	}
+	if summary := nextThreadFrame(t, bob); summary.ReplyCount != 2 {
+		t.Errorf("Expected two replies in summary, got %+v", summary)
+	}
+	alice.next(t)
+
+	sendFrame(t, alice, fmt.Sprintf(`{"type":"thread","id":%d}`, reply.ID))
+	thread := nextThreadFrame(t, alice)
+	if thread.Type != "thread" || thread.ID != root.ID || thread.Root == nil || thread.Root.Content != "lunch?" {
+		t.Fatalf("Unexpected thread root: %+v", thread)
+	}
+	if len(thread.Replies) != 2 || thread.Replies[0].Content != "yes" || thread.Replies[1].Content != "noon" {
+		t.Errorf("Unexpected thread replies: %+v", thread.Replies)
+	}
+}
+
+// TestReplyToUnknownMessage verifies that replies must refer to a retained
+// message, over WebSocket and the ingestion API.
+func TestReplyToUnknownMessage(t *testing.T) {
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		moderationUsers(cfg)
+		cfg.APIKeys = []server.APIKey{{Name: "ci-bot", Key: "ci-key"}}
+	})
+	alice := dialAsUser(t, testServer.URL, "alice-token")
+
+	sendFrame(t, alice, `{"content":"orphan","in_reply_to":999999999}`)
+	if got := alice.next(t); got.Code != "message_not_found" {
+		t.Errorf("Expected message_not_found, got %+v", got)
+	}
+
+	status, _ := postIngest(t, testServer.URL, server.DefaultRoom, bearer("ci-key"), `{"content":"orphan","in_reply_to":999999999}`)
+	if status != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, status)
+	}
+}
@@ -0,0 +1,154 @@
+package integration
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Tyrowin/gochat/internal/server"
+)
+
+// exportRecord mirrors one line of GET /admin/export.
+type exportRecord struct {
+	Type    string    `json:"type"`
+	Room    string    `json:"room"`
+	User    string    `json:"user"`
+	Role    string    `json:"role"`
+	ID      uint64    `json:"id"`
+	Author  string    `json:"author"`
+	SentAt  time.Time `json:"sent_at"`
+	Message struct {
+		Content string `json:"content"`
+		Sender  string `json:"sender"`
+	} `json:"message"`
+}
+
+func transferUsers(cfg *server.Config) {
+	searchUsers(cfg)
+	cfg.AdminToken = testAdminToken
+}
+
+// TestExportWritesRecordsAndTranscript verifies the JSON Lines export and
+// the plain-text transcript.
+func TestExportWritesRecordsAndTranscript(t *testing.T) {
+	testServer := startSSETestServer(t, transferUsers)
+	word := uniqueWord("export")
+	status, id := postIngest(t, testServer.URL, "general", bearer("ci-key"), `{"content":"`+word+`"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("Expected 201 from ingest, got %d", status)
+	}
+
+	since := url.QueryEscape(time.Now().Add(-time.Minute).UTC().Format(time.RFC3339))
+	resp := adminRequest(t, http.MethodGet, testServer.URL+"/admin/export?room=general&since="+since, testAdminToken, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from export, got %d", resp.StatusCode)
+	}
+	counts := make(map[string]int)
+	var found *exportRecord
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var record exportRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Invalid export line %q: %v", scanner.Text(), err)
+		}
+		counts[record.Type]++
+		if record.Type == "message" && record.ID == id {
+			found = &record
+		}
+	}
+	if counts["room"] != 1 || counts["member"] != 4 {
+		t.Errorf("Expected one room and four members, got %v", counts)
+	}
+	if found == nil || found.Author != "ci-bot" || found.Message.Content != word || found.SentAt.IsZero() {
+		t.Fatalf("Expected message %d in the export, got %+v", id, found)
+	}
+
+	resp = adminRequest(t, http.MethodGet, testServer.URL+"/admin/export?format=text", testAdminToken, "")
+	transcript, _ := io.ReadAll(resp.Body)
+	if want := fmt.Sprintf("#%d ci-bot: %s\n", id, word); !strings.HasPrefix(string(transcript), "# general\n") || !strings.Contains(string(transcript), want) {
+		t.Errorf("Expected transcript line %q, got %q", want, transcript)
+	}
+
+	future := url.QueryEscape(time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	resp = adminRequest(t, http.MethodGet, testServer.URL+"/admin/export?since="+future, testAdminToken, "")
+	body, _ := io.ReadAll(resp.Body)
+	if strings.Contains(string(body), `"type":"message"`) {
+		t.Errorf("Expected no messages after the since filter, got %s", body)
+	}
+}
+
+// TestImportPreservesIDs verifies that imported messages keep their ids,
+// can be paged and searched, and that ids cannot be imported twice.
+func TestImportPreservesIDs(t *testing.T) {
+	testServer := startSSETestServer(t, transferUsers)
+	_, last := postIngest(t, testServer.URL, "general", bearer("ci-key"), `{"content":"before import"}`)
+
+	word := uniqueWord("imported")
+	first, second := last+100, last+101
+	sentAt := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	var body strings.Builder
+	body.WriteString(`{"type":"room","room":"general"}` + "\n")
+	body.WriteString(`{"type":"member","room":"general","user":"alice","role":"member"}` + "\n")
+	for _, id := range []uint64{first, second} {
+		ids := strconv.FormatUint(id, 10)
+		fmt.Fprintf(&body, `{"type":"message","room":"general","id":%s,"author":"alice","sent_at":%q,"message":{"id":%s,"content":"%s","sender":"alice"},"reactions":[{"emoji":"👍","users":["bob"]}]}`+"\n", ids, sentAt, ids, word)
+	}
+
+	resp := adminRequest(t, http.MethodPost, testServer.URL+"/admin/import", testAdminToken, body.String())
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from import, got %d", resp.StatusCode)
+	}
+	var result struct {
+		Rooms, Members, Messages int
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.Rooms != 1 || result.Members != 1 || result.Messages != 2 {
+		t.Errorf("Unexpected import result %+v (%v)", result, err)
+	}
+
+	_, page := fetchHistory(t, testServer.URL, "general", "alice-token", url.Values{"after": {strconv.FormatUint(last, 10)}})
+	if !equalIDs(page.ids(), []uint64{first, second}) {
+		t.Errorf("Expected the imported ids %d and %d, got %v", first, second, page.ids())
+	}
+	if _, found := search(t, testServer.URL, "alice-token", url.Values{"q": {word}}); len(found.Results) != 2 {
+		t.Errorf("Expected the imported messages to be searchable, got %+v", found.Results)
+	}
+	if _, next := postIngest(t, testServer.URL, "general", bearer("ci-key"), `{"content":"after import"}`); next <= second {
+		t.Errorf("Expected new ids after %d, got %d", second, next)
+	}
+
+	resp = adminRequest(t, http.MethodPost, testServer.URL+"/admin/import", testAdminToken, body.String())
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected 409 when importing the ids again, got %d", resp.StatusCode)
+	}
+}
+
+// TestImportRejectsInvalidExports verifies that invalid imports are refused
+// as a whole.
+func TestImportRejectsInvalidExports(t *testing.T) {
+	testServer := startSSETestServer(t, transferUsers)
+
+	cases := []struct {
+		name  string
+		token string
+		body  string
+		want  int
+	}{
+		{"no token", "", `{"type":"room","room":"general"}`, http.StatusUnauthorized},
+		{"not json", testAdminToken, "not json", http.StatusBadRequest},
+		{"unknown room", testAdminToken, `{"type":"room","room":"random"}`, http.StatusNotFound},
+		{"unknown type", testAdminToken, `{"type":"channel","room":"general"}`, http.StatusBadRequest},
+		{"no time", testAdminToken, `{"type":"message","room":"general","id":999999,"message":{"id":999999,"content":"x"}}`, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		if resp := adminRequest(t, http.MethodPost, testServer.URL+"/admin/import", tc.token, tc.body); resp.StatusCode != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package integration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Tyrowin/gochat/internal/server"
+)
+
+// typingUsers configures the moderation test users with a tight chat rate
+// limit, so that tests notice typing frames spending chat budget.
+func typingUsers(typing server.TypingConfig) func(cfg *server.Config) {
+	return func(cfg *server.Config) {
+		moderationUsers(cfg)
+		cfg.RateLimit = server.RateLimitConfig{Burst: 1, RefillInterval: time.Minute}
+		cfg.Typing = typing
+	}
+}
+
+// TestTypingIndicators verifies that typing changes reach the other clients
+// only when the state changes, and that typing frames are throttled apart
+// from the chat rate limit.
+func TestTypingIndicators(t *testing.T) {
+	testServer := startSSETestServer(t, typingUsers(server.TypingConfig{
+		Timeout:   time.Minute,
+		RateLimit: server.RateLimitConfig{Burst: 3, RefillInterval: time.Minute},
+	}))
+	alice := dialAsUser(t, testServer.URL, "alice-token")
+	bob := dialAsUser(t, testServer.URL, "bob-token")
+
+	sendFrame(t, alice, `{"type":"typing_start"}`)
+	if got := bob.next(t); got.Type != "typing" || got.User != "alice" || !got.Typing {
+		t.Errorf("Expected alice to start typing, got %s", bob.last)
+	}
+
+	// A repeated start only refreshes the indicator.
+	sendFrame(t, alice, `{"type":"typing_start"}`)
+	sendFrame(t, alice, `{"type":"typing_stop"}`)
+	if got := bob.next(t); got.Type != "typing" || got.Typing {
+		t.Errorf("Expected alice to stop typing, got %s", bob.last)
+	}
+
+	// The typing budget of 3 is spent, so this start is dropped, while the
+	// chat message still fits in its own budget.
+	sendFrame(t, alice, `{"type":"typing_start"}`)
+	sendFrame(t, alice, `{"content":"hi"}`)
+	if got := bob.next(t); got.Type != "" || got.Content != "hi" {
+		t.Errorf("Expected the chat message, got %s", bob.last)
+	}
+}
+
+// TestTypingExpires verifies that a typing indicator is cleared when no stop
+// arrives and when the typing client disconnects.
+func TestTypingExpires(t *testing.T) {
+	testServer := startSSETestServer(t, typingUsers(server.TypingConfig{Timeout: 200 * time.Millisecond}))
+	alice := dialAsUser(t, testServer.URL, "alice-token")
+	bob := dialAsUser(t, testServer.URL, "bob-token")
+
+	sendFrame(t, alice, `{"type":"typing_start"}`)
+	bob.next(t)
+	start := time.Now()
+	if got := bob.next(t); got.Type != "typing" || got.Typing {
+		t.Errorf("Expected the indicator to expire, got %s", bob.last)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected expiry after about 200ms, took %s", elapsed)
+	}
+
+	sendFrame(t, bob, `{"type":"typing_start"}`)
+	alice.next(t)
+	_ = bob.conn.Close()
+	if got := alice.next(t); got.Type != "typing" || got.User != "bob" || got.Typing {
+		t.Errorf("Expected bob's indicator to clear on disconnect, got %s", alice.last)
+	}
+}
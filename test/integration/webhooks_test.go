@@ -0,0 +1,278 @@
+package integration
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Tyrowin/gochat/internal/server"
+	"github.com/gorilla/websocket"
+)
+
+// webhookRequest is a delivery captured by a test receiver.
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+	event  struct {
+		ID   string          `json:"id"`
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+}
+
+// startWebhookReceiver records every delivery and answers with the status
+// returned by respond for the n-th request (starting at 1).
+func startWebhookReceiver(t *testing.T, respond func(n int32) int) (*httptest.Server, <-chan webhookRequest) {
+	t.Helper()
+
+	requests := make(chan webhookRequest, 64)
+	var count atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		req := webhookRequest{header: r.Header.Clone(), body: body}
+		if err := json.Unmarshal(body, &req.event); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		select {
+		case requests <- req:
+		default:
+		}
+		w.WriteHeader(respond(count.Add(1)))
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver, requests
+}
+
+func nextWebhook(t *testing.T, requests <-chan webhookRequest, timeout time.Duration) webhookRequest {
+	t.Helper()
+	select {
+	case req := <-requests:
+		return req
+	case <-time.After(timeout):
+		t.Fatalf("Timed out waiting for webhook delivery")
+		return webhookRequest{}
+	}
+}
+
+func sendWebSocketMessage(t *testing.T, conn *websocket.Conn, content string) {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, mustMarshalMessage(t, content)); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+}
+
+// TestWebhookDeliversSignedMessageEvents verifies that broadcasts are POSTed
+// to subscribers with a valid HMAC signature and event headers.
+func TestWebhookDeliversSignedMessageEvents(t *testing.T) {
+	const secret = "webhook-secret"
+	receiver, requests := startWebhookReceiver(t, func(int32) int { return http.StatusOK })
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		moderationUsers(cfg)
+		cfg.Webhooks.Subscriptions = []server.WebhookSubscription{
+			{URL: receiver.URL, Secret: secret, Events: []string{server.WebhookEventMessage}},
+		}
+	})
+
+	sender := dialAsUser(t, testServer.URL, "alice-token")
+	sendFrame(t, sender, `{"content":"to the webhook"}`)
+
+	req := nextWebhook(t, requests, 3*time.Second)
+	if req.event.Type != server.WebhookEventMessage {
+		t.Fatalf("Expected %q event, got %q", server.WebhookEventMessage, req.event.Type)
+	}
+	timestamp, err := strconv.ParseInt(req.header.Get("X-GoChat-Timestamp"), 10, 64)
+	if err != nil || time.Since(time.Unix(timestamp, 0)).Abs() > time.Minute {
+		t.Errorf("Expected a current X-GoChat-Timestamp, got %q", req.header.Get("X-GoChat-Timestamp"))
+	}
+	if got, want := req.header.Get("X-GoChat-Signature"), server.SignWebhookPayload(secret, timestamp, req.body); got != want {
+		t.Errorf("Expected signature %q, got %q", want, got)
+	}
+	if got := server.SignWebhookPayload(secret, timestamp+1, req.body); got == req.header.Get("X-GoChat-Signature") {
+		t.Error("Expected the signature to cover the timestamp")
+	}
+	if got := req.header.Get("X-GoChat-Event"); got != server.WebhookEventMessage {
+		t.Errorf("Expected X-GoChat-Event %q, got %q", server.WebhookEventMessage, got)
+	}
+	if got := req.header.Get("X-GoChat-Delivery"); got != req.event.ID {
+		t.Errorf("Expected X-GoChat-Delivery %q to match event id %q", got, req.event.ID)
+	}
+
+	var data struct {
+		MessageID uint64         `json:"message_id"`
+		Sender    string         `json:"sender"`
+		Message   server.Message `json:"message"`
+	}
+	if err := json.Unmarshal(req.event.Data, &data); err != nil {
+		t.Fatalf("Failed to decode message data: %v", err)
+	}
+	if data.MessageID == 0 || data.Sender != "alice" || data.Message.Content != "to the webhook" {
+		t.Errorf("Unexpected message data: %+v", data)
+	}
+}
+
+// TestWebhookDeliversJoinEvents verifies that presence events are filtered
+// by the subscription's event list.
+func TestWebhookDeliversJoinEvents(t *testing.T) {
+	receiver, requests := startWebhookReceiver(t, func(int32) int { return http.StatusOK })
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		cfg.Webhooks.Subscriptions = []server.WebhookSubscription{
+			{URL: receiver.URL, Secret: "s", Events: []string{server.WebhookEventJoin}},
+		}
+	})
+
+	dialWebSocket(t, testServer.URL)
+
+	req := nextWebhook(t, requests, 3*time.Second)
+	if req.event.Type != server.WebhookEventJoin {
+		t.Fatalf("Expected %q event, got %q", server.WebhookEventJoin, req.event.Type)
+	}
+	var data struct {
+		Client    string `json:"client"`
+		Transport string `json:"transport"`
+		Clients   int    `json:"clients"`
+	}
+	if err := json.Unmarshal(req.event.Data, &data); err != nil {
+		t.Fatalf("Failed to decode join data: %v", err)
+	}
+	if data.Transport != "websocket" || data.Clients < 1 {
+		t.Errorf("Unexpected join data: %+v", data)
+	}
+	// Subscribers get the guest label, never the client's address.
+	if !strings.HasPrefix(data.Client, "guest-") || strings.Contains(string(req.event.Data), "127.0.0.1") {
+		t.Errorf("Expected join data to identify the guest without its address, got %s", req.event.Data)
+	}
+}
+
+// TestWebhookRetriesFailedDeliveries verifies that a 5xx response is retried
+// with the same delivery id.
+func TestWebhookRetriesFailedDeliveries(t *testing.T) {
+	receiver, requests := startWebhookReceiver(t, func(n int32) int {
+		if n == 1 {
+			return http.StatusInternalServerError
+		}
+		return http.StatusOK
+	})
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		cfg.Webhooks.InitialBackoff = 20 * time.Millisecond
+		cfg.Webhooks.Subscriptions = []server.WebhookSubscription{
+			{URL: receiver.URL, Secret: "s", Events: []string{server.WebhookEventMessage}},
+		}
+	})
+
+	sender := dialWebSocket(t, testServer.URL)
+	sendWebSocketMessage(t, sender, "retry me")
+
+	first := nextWebhook(t, requests, 3*time.Second)
+	second := nextWebhook(t, requests, 3*time.Second)
+	if first.event.ID != second.event.ID {
+		t.Errorf("Expected retry to reuse delivery id %q, got %q", first.event.ID, second.event.ID)
+	}
+}
+
+// TestWebhookDeadLettersAfterMaxAttempts verifies that a delivery which keeps
+// failing is written to the dead-letter file.
+func TestWebhookDeadLettersAfterMaxAttempts(t *testing.T) {
+	receiver, requests := startWebhookReceiver(t, func(int32) int { return http.StatusServiceUnavailable })
+	deadLetterPath := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+	testServer := startSSETestServer(t, func(cfg *server.Config) {
+		cfg.Webhooks.InitialBackoff = 20 * time.Millisecond
+		cfg.Webhooks.MaxAttempts = 2
+		cfg.Webhooks.DeadLetterPath = deadLetterPath
+		cfg.Webhooks.Subscriptions = []server.WebhookSubscription{
+			{URL: receiver.URL, Secret: "s", Events: []string{server.WebhookEventMessage}},
+		}
+	})
+
+	sender := dialWebSocket(t, testServer.URL)
+	sendWebSocketMessage(t, sender, "never delivered")
+
+	delivery := nextWebhook(t, requests, 3*time.Second)
+	nextWebhook(t, requests, 3*time.Second)
+
+	var record struct {
+		Delivery string `json:"delivery"`
+		Attempts int    `json:"attempts"`
+		Error    string `json:"error"`
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if readFirstJSONLine(t, deadLetterPath, &record) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Dead-letter record was not written to %s", deadLetterPath)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if record.Delivery != delivery.event.ID || record.Attempts != 2 || record.Error == "" {
+		t.Errorf("Unexpected dead-letter record: %+v", record)
+	}
+}
+
+// TestWebhookDeadLettersPendingRetriesOnShutdown verifies that a delivery
+// waiting for a retry when the hub shuts down goes to the dead-letter file.
+func TestWebhookDeadLettersPendingRetriesOnShutdown(t *testing.T) {
+	receiver, requests := startWebhookReceiver(t, func(int32) int { return http.StatusServiceUnavailable })
+	deadLetterPath := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+	configureServerForTest(t, receiver.URL, func(cfg *server.Config) {
+		cfg.Webhooks.InitialBackoff = time.Hour
+		cfg.Webhooks.DeadLetterPath = deadLetterPath
+		cfg.Webhooks.Subscriptions = []server.WebhookSubscription{
+			{URL: receiver.URL, Secret: "s", Events: []string{server.WebhookEventMessage}},
+		}
+	})
+
+	hub := server.NewHub()
+	go hub.Run()
+	hub.GetBroadcastChan() <- server.BroadcastMessage{Payload: []byte(`{"content":"pending retry"}`)}
+	delivery := nextWebhook(t, requests, 3*time.Second)
+
+	// Give the worker time to schedule the retry.
+	time.Sleep(50 * time.Millisecond)
+	if err := hub.Shutdown(5 * time.Second); err != nil {
+		t.Fatalf("Hub shutdown failed: %v", err)
+	}
+
+	var record struct {
+		Delivery string `json:"delivery"`
+		Attempts int    `json:"attempts"`
+	}
+	if !readFirstJSONLine(t, deadLetterPath, &record) || record.Delivery != delivery.event.ID || record.Attempts != 1 {
+		t.Errorf("Expected the pending retry to be dead-lettered, got %+v", record)
+	}
+}
+
+// readFirstJSONLine decodes the first line of path into v and reports whether
+// a line was available.
+func readFirstJSONLine(t *testing.T, path string, v interface{}) bool {
+	t.Helper()
+	file, err := os.Open(path) // #nosec G304 -- test temp file
+	if err != nil {
+		return false
+	}
+	defer func() { _ = file.Close() }()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return false
+	}
+	if err := json.Unmarshal(scanner.Bytes(), v); err != nil {
+		t.Fatalf("Failed to decode dead-letter record: %v", err)
+	}
+	return true
+}
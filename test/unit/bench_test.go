@@ -0,0 +1,97 @@
+package unit
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Tyrowin/gochat/internal/bench"
+)
+
+// TestLatencyHistogramPercentiles verifies that percentiles reported by the
+// benchmark histogram stay within the bucket error of the true values.
+func TestLatencyHistogramPercentiles(t *testing.T) {
+	h := bench.NewLatencyHistogram()
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	if h.Count() != 1000 {
+		t.Fatalf("Expected 1000 samples, got %d", h.Count())
+	}
+	if h.Min() != time.Millisecond {
+		t.Errorf("Expected min 1ms, got %v", h.Min())
+	}
+	if h.Max() != time.Second {
+		t.Errorf("Expected max 1s, got %v", h.Max())
+	}
+
+	tests := []struct {
+		percentile float64
+		expected   time.Duration
+	}{
+		{0.50, 500 * time.Millisecond},
+		{0.90, 900 * time.Millisecond},
+		{0.99, 990 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		got := h.Percentile(tt.percentile)
+		tolerance := tt.expected / 25
+		if got < tt.expected-tolerance || got > tt.expected+tolerance {
+			t.Errorf("Percentile(%v) = %v, expected %v ± %v", tt.percentile, got, tt.expected, tolerance)
+		}
+	}
+}
+
+// TestLatencyHistogramMerge verifies that merging histograms combines counts
+// and extremes.
+func TestLatencyHistogramMerge(t *testing.T) {
+	a := bench.NewLatencyHistogram()
+	b := bench.NewLatencyHistogram()
+	a.Record(2 * time.Millisecond)
+	b.Record(time.Millisecond)
+	b.Record(8 * time.Millisecond)
+
+	a.Merge(b)
+	a.Merge(nil)
+
+	if a.Count() != 3 {
+		t.Errorf("Expected 3 samples after merge, got %d", a.Count())
+	}
+	if a.Min() != time.Millisecond || a.Max() != 8*time.Millisecond {
+		t.Errorf("Unexpected min/max after merge: %v/%v", a.Min(), a.Max())
+	}
+	if a.Mean() != time.Duration(11)*time.Millisecond/3 {
+		t.Errorf("Unexpected mean after merge: %v", a.Mean())
+	}
+}
+
+// TestBenchReportJSON verifies that the report serializes with the stable
+// field names used to compare releases.
+func TestBenchReportJSON(t *testing.T) {
+	report := &bench.Report{}
+	report.Messages.RateLimited = 3
+
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("Report is not valid JSON: %v", err)
+	}
+
+	for _, key := range []string{"connections", "messages", "throughput", "latency_ms"} {
+		if _, ok := decoded[key]; !ok {
+			t.Errorf("Report JSON is missing %q", key)
+		}
+	}
+
+	messages, ok := decoded["messages"].(map[string]interface{})
+	if !ok || messages["rate_limited"] != float64(3) {
+		t.Errorf("Expected messages.rate_limited to be 3, got %v", decoded["messages"])
+	}
+}